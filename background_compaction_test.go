@@ -0,0 +1,173 @@
+package yldb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/config"
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var backgroundCompactionPath = "./test_data/test_background_compaction"
+
+// 等待后台任务结束且所有列族都不再需要Compaction
+func waitForCompactions(db *YLDB) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	for {
+		busy := db.backgroundBusy()
+		for _, cf := range db.cfs {
+			busy = busy || cf.current.NeedsCompaction()
+		}
+		if !busy {
+			return
+		}
+		db.cond.Wait()
+	}
+}
+
+func TestBackgroundCompaction(t *testing.T) {
+	_ = os.RemoveAll(backgroundCompactionPath)
+	opts := &utils.Options{
+		ColumnFamilyOptions:      utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10},
+		MaxBackgroundCompactions: 4,
+	}
+	db, err := OpenWithOptions(backgroundCompactionPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := db.CreateColumnFamily("other", &utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 多个goroutine并发写入两个列族，产生大量flush和major compaction
+	const writers, n = 4, 3000
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += writers {
+				key := []byte(fmt.Sprintf("key%05d", i))
+				_ = db.Set(key, key, nil)
+				_ = db.SetCF(cf, key, []byte("cf-"+string(key)), nil)
+				if i%3 == 0 {
+					_ = db.Delete(key, nil)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	waitForCompactions(db)
+
+	for _, c := range []*ColumnFamily{db.defaultCF, cf} {
+		if l0 := c.current.NumLevelFiles(0); l0 > config.L0CompactionTrigger {
+			t.Fatalf("expected at most %d files in level0, got %d", config.L0CompactionTrigger, l0)
+		}
+		checkNoObsoleteTables(t, c)
+	}
+	check := func() {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%05d", i))
+			value, err := db.Get(key, nil)
+			if i%3 == 0 && err == nil || i%3 != 0 && (err != nil || string(value) != string(key)) {
+				t.Fatalf("Get(%s) = (%q, %v)", key, value, err)
+			}
+			if value, err := db.GetCF(cf, key, nil); err != nil || string(value) != "cf-"+string(key) {
+				t.Fatalf("GetCF(%s) = (%q, %v)", key, value, err)
+			}
+		}
+	}
+	check()
+
+	db.Close()
+	db, err = OpenWithOptions(backgroundCompactionPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cf = db.GetColumnFamily("other")
+	check()
+}
+
+// 后台Compaction失败时记录错误并返回给被停止的写入，等待一段时间后重试
+func TestBackgroundCompactionError(t *testing.T) {
+	_ = os.RemoveAll(backgroundCompactionPath)
+	opts := &utils.Options{ColumnFamilies: map[string]*utils.ColumnFamilyOptions{
+		"broken": {Level0SlowdownWritesTrigger: config.L0CompactionTrigger + 1, Level0StopWritesTrigger: config.L0CompactionTrigger + 1},
+	}}
+	db, err := OpenWithOptions(backgroundCompactionPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cf, err := db.CreateColumnFamily("broken", opts.ColumnFamilies["broken"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 占满后台Compaction的并发数，flush的文件都留在L0中
+	db.mutex.Lock()
+	db.compactions = db.opts.GetMaxBackgroundCompactions()
+	for cf.current.NumLevelFiles(0) <= config.L0CompactionTrigger {
+		db.mutex.Unlock()
+		for i := 0; i < 10; i++ {
+			key := []byte(fmt.Sprintf("key%02d", i))
+			if err := db.SetCF(cf, key, key, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		db.mutex.Lock()
+		if err := db.flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 列族目录被替换为普通文件，Compaction无法创建输出文件
+	dir := cf.dir
+	if err := os.Rename(dir, dir+".bak"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db.compactions = 0
+	db.maybeScheduleCompaction()
+	for db.compactionError == nil {
+		db.cond.Wait()
+	}
+	db.mutex.Unlock()
+	if err := db.SetCF(cf, []byte("key00"), []byte("value"), nil); err == nil || err == errors.ErrWriteStall {
+		t.Fatalf("expected the background error, got %v", err)
+	}
+	if err := db.CompactRangeCF(cf, nil, nil, nil); err == nil {
+		t.Fatal("expected the background error")
+	}
+
+	// 恢复之后重试的Compaction成功，写入恢复
+	_ = os.Remove(dir)
+	if err := os.Rename(dir+".bak", dir); err != nil {
+		t.Fatal(err)
+	}
+	waitForCompactions(db)
+	db.mutex.Lock()
+	err = db.compactionError
+	db.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCF(cf, []byte("key00"), []byte("value"), nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		if value, err := db.GetCF(cf, key, nil); err != nil || string(value) != string(key) {
+			t.Fatalf("GetCF(%s) = (%q, %v)", key, value, err)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package backup
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Cauchy-NY/yldb"
+	"github.com/Cauchy-NY/yldb/errors"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Engine 管理一个备份目录中的多个备份版本，目录结构为：
+// - meta/<id>：备份的元数据，记录该版本包含的所有文件
+// - shared/：SST文件，按数据库中的相对路径、文件编号和校验和命名，被多个版本共享
+// - private/<id>/：该版本独有的MANIFEST、CURRENT和WAL文件
+// SST文件写入后不再修改，因此路径、编号和大小都相同的SST文件只需要备份一次
+type Engine struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+func Open(dir string) (*Engine, error) {
+	engine := &Engine{dir: dir}
+	for _, d := range []string{engine.metaDir(), engine.sharedDir(), engine.privateDir()} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return engine, nil
+}
+
+func (engine *Engine) metaDir() string {
+	return filepath.Join(engine.dir, "meta")
+}
+
+func (engine *Engine) sharedDir() string {
+	return filepath.Join(engine.dir, "shared")
+}
+
+func (engine *Engine) privateDir() string {
+	return filepath.Join(engine.dir, "private")
+}
+
+func (engine *Engine) metaFileName(id uint32) string {
+	return filepath.Join(engine.metaDir(), strconv.FormatUint(uint64(id), 10))
+}
+
+// 为db创建一个新的备份版本
+// 通过GetLiveFiles把MANIFEST、CURRENT和WAL写入该版本的私有目录，并保护当前引用的SST文件在备份期间不被删除，
+// 之前的备份中已有编号和大小都相同的SST文件时直接复用，不再读取，其余SST文件从数据库目录拷贝到shared中
+func (engine *Engine) CreateBackup(db *yldb.YLDB) (*Info, error) {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	infos, err := engine.backups()
+	if err != nil {
+		return nil, err
+	}
+	id := uint32(1)
+	backedUp := make(map[string]FileInfo)
+	for _, existing := range infos {
+		if existing.ID >= id {
+			id = existing.ID + 1
+		}
+		for _, file := range existing.Files {
+			backedUp[file.Path] = file
+		}
+	}
+
+	privateDir := filepath.Join(engine.privateDir(), strconv.FormatUint(uint64(id), 10))
+	_ = os.RemoveAll(privateDir)
+	live, err := db.GetLiveFiles(privateDir)
+	if err != nil {
+		_ = os.RemoveAll(privateDir)
+		return nil, err
+	}
+	defer live.Release()
+
+	info := &Info{ID: id, Timestamp: time.Now()}
+	for _, table := range live.Tables {
+		file, copied, err := engine.backupTable(live.Dir, table, backedUp)
+		if err != nil {
+			return nil, engine.abortBackup(privateDir, err)
+		}
+		info.add(file, copied)
+	}
+	err = filepath.Walk(privateDir, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil || fileInfo.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(privateDir, path)
+		if err != nil {
+			return err
+		}
+		checksum, size, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		info.add(FileInfo{
+			Path:       rel,
+			BackupPath: filepath.Join("private", filepath.Base(privateDir), rel),
+			Size:       size,
+			Checksum:   checksum,
+		}, true)
+		return nil
+	})
+	if err == nil {
+		err = writeMeta(engine.metaFileName(id), info)
+	}
+	if err != nil {
+		return nil, engine.abortBackup(privateDir, err)
+	}
+	return info, nil
+}
+
+// 删除未完成的备份版本留下的文件，返回err
+func (engine *Engine) abortBackup(privateDir string, err error) error {
+	_ = os.RemoveAll(privateDir)
+	_ = engine.garbageCollect()
+	return err
+}
+
+// 备份单个SST文件，返回文件信息以及是否实际发生了拷贝
+// 之前的备份中已有编号和大小相同的文件时复用备份时记录的校验和，否则拷贝时计算校验和并用于命名
+func (engine *Engine) backupTable(dbDir string, table yldb.LiveTable, backedUp map[string]FileInfo) (FileInfo, bool, error) {
+	if file, ok := backedUp[table.Path]; ok && file.Size == table.Size {
+		if stat, err := os.Stat(filepath.Join(engine.dir, file.BackupPath)); err == nil && stat.Size() == file.Size {
+			return file, false, nil
+		}
+	}
+
+	dir := filepath.Join("shared", filepath.Dir(table.Path))
+	if err := os.MkdirAll(filepath.Join(engine.dir, dir), 0755); err != nil {
+		return FileInfo{}, false, err
+	}
+	// 拷贝完成才知道校验和，先拷贝到不带校验和的文件名再重命名
+	staging := filepath.Join(engine.dir, dir, fmt.Sprintf("%06d.ldb", table.Number))
+	checksum, err := copyFile(filepath.Join(dbDir, table.Path), staging)
+	if err != nil {
+		return FileInfo{}, false, err
+	}
+	file := FileInfo{
+		Path:       table.Path,
+		BackupPath: filepath.Join(dir, fmt.Sprintf("%06d_%08x.ldb", table.Number, checksum)),
+		Size:       table.Size,
+		Checksum:   checksum,
+	}
+	if err := os.Rename(staging, filepath.Join(engine.dir, file.BackupPath)); err != nil {
+		_ = os.Remove(staging)
+		return FileInfo{}, false, err
+	}
+	return file, true, nil
+}
+
+// 返回所有备份版本，按ID排序
+func (engine *Engine) Backups() ([]*Info, error) {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	return engine.backups()
+}
+
+func (engine *Engine) backups() ([]*Info, error) {
+	ids, err := listMeta(engine.metaDir())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	infos := make([]*Info, 0, len(ids))
+	for _, id := range ids {
+		info, err := readMeta(engine.metaFileName(id), id)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// 删除一个备份版本，不再被任何版本引用的SST文件也会被删除
+func (engine *Engine) DeleteBackup(id uint32) error {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	if err := os.Remove(engine.metaFileName(id)); err != nil {
+		if os.IsNotExist(err) {
+			return errors.ErrBackupNotFound
+		}
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(engine.privateDir(), strconv.FormatUint(uint64(id), 10))); err != nil {
+		return err
+	}
+	return engine.garbageCollect()
+}
+
+// 只保留最新的keep个备份版本
+func (engine *Engine) PurgeOldBackups(keep int) error {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	ids, err := listMeta(engine.metaDir())
+	if err != nil {
+		return err
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	for i := 0; i < len(ids)-keep; i++ {
+		if err := os.Remove(engine.metaFileName(ids[i])); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(filepath.Join(engine.privateDir(), strconv.FormatUint(uint64(ids[i]), 10))); err != nil {
+			return err
+		}
+	}
+	return engine.garbageCollect()
+}
+
+// 删除shared中不再被任何版本引用的文件
+func (engine *Engine) garbageCollect() error {
+	infos, err := engine.backups()
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]bool)
+	for _, info := range infos {
+		for _, file := range info.Files {
+			referenced[file.BackupPath] = true
+		}
+	}
+	return filepath.Walk(engine.sharedDir(), func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil || fileInfo.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(engine.dir, path)
+		if err != nil {
+			return err
+		}
+		if !referenced[rel] {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// 将备份版本恢复到dir目录，dir不能已经存在，恢复后可以直接用Open打开
+// 拷贝过程中校验每个文件的大小和校验和，校验失败时删除dir并返回ErrBackupCorrupted
+func (engine *Engine) Restore(id uint32, dir string) error {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	if _, err := os.Stat(dir); err == nil {
+		return errors.ErrRestoreDirExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	info, err := readMeta(engine.metaFileName(id), id)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range info.Files {
+		if err = restoreFile(filepath.Join(engine.dir, file.BackupPath), filepath.Join(dir, file.Path), file); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+	}
+	return err
+}
+
+func restoreFile(src, dst string, file FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	checksum, err := copyFile(src, dst)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.ErrBackupCorrupted
+		}
+		return err
+	}
+	if stat, err := os.Stat(dst); err != nil || stat.Size() != file.Size || checksum != file.Checksum {
+		return errors.ErrBackupCorrupted
+	}
+	return nil
+}
+
+// 校验备份版本中所有文件都存在，且大小和校验和与备份时一致
+func (engine *Engine) Verify(id uint32) error {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	info, err := readMeta(engine.metaFileName(id), id)
+	if err != nil {
+		return err
+	}
+	for _, file := range info.Files {
+		checksum, size, err := fileChecksum(filepath.Join(engine.dir, file.BackupPath))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return errors.ErrBackupCorrupted
+			}
+			return err
+		}
+		if size != file.Size || checksum != file.Checksum {
+			return errors.ErrBackupCorrupted
+		}
+	}
+	return nil
+}
+
+func fileChecksum(path string) (uint32, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	hash := crc32.New(crcTable)
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return hash.Sum32(), size, nil
+}
+
+// 拷贝文件并返回拷贝内容的校验和
+func copyFile(src, dst string) (uint32, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	// 先写入临时文件再重命名，备份目录中存在的文件都是完整的
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	hash := crc32.New(crcTable)
+	_, err = io.Copy(io.MultiWriter(out, hash), in)
+	errs := []error{err, out.Sync(), out.Close()}
+	for _, err := range errs {
+		if err != nil {
+			_ = os.Remove(tmp)
+			return 0, err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return 0, err
+	}
+	return hash.Sum32(), nil
+}
@@ -0,0 +1,221 @@
+package backup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb"
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var (
+	dbPath      = "../test_data/test_backup_db"
+	backupPath  = "../test_data/test_backup"
+	restorePath = "../test_data/test_backup_restore"
+)
+
+func writeKeys(t *testing.T, db *yldb.YLDB, from, to int, prefix string) {
+	for i := from; i < to; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if err := db.Set(key, []byte(prefix+string(key)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkKeys(t *testing.T, dir string, from, to int, prefix string) {
+	db, err := yldb.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := from; i < to; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if value, err := db.Get(key, nil); err != nil || string(value) != prefix+string(key) {
+			t.Fatalf("Get(%s) = %q, %v", key, value, err)
+		}
+	}
+}
+
+func TestBackup(t *testing.T) {
+	_ = os.RemoveAll(dbPath)
+	_ = os.RemoveAll(backupPath)
+	_ = os.RemoveAll(restorePath)
+
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10}}
+	db, err := yldb.OpenWithOptions(dbPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	engine, err := Open(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeKeys(t, db, 0, 1000, "v1-")
+	first, err := engine.CreateBackup(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.CopiedSize != first.Size {
+		t.Fatalf("first backup copied %d of %d bytes", first.CopiedSize, first.Size)
+	}
+
+	// 第二次备份复用第一次备份中没有变化的SST文件
+	writeKeys(t, db, 1000, 1100, "v2-")
+	second, err := engine.CreateBackup(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.CopiedSize >= second.Size {
+		t.Fatalf("second backup copied %d of %d bytes", second.CopiedSize, second.Size)
+	}
+
+	infos, err := engine.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].ID != first.ID || infos[1].ID != second.ID || infos[1].Size != second.Size {
+		t.Fatalf("unexpected backups: %+v", infos)
+	}
+
+	if err := engine.Verify(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Restore(first.ID, restorePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Restore(first.ID, restorePath); err != errors.ErrRestoreDirExists {
+		t.Fatalf("expected ErrRestoreDirExists, got %v", err)
+	}
+	checkKeys(t, restorePath, 0, 1000, "v1-")
+	_ = os.RemoveAll(restorePath)
+
+	// 删除第一个版本后第二个版本仍然完整
+	if err := engine.DeleteBackup(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Verify(first.ID); err != errors.ErrBackupNotFound {
+		t.Fatalf("expected ErrBackupNotFound, got %v", err)
+	}
+	if err := engine.Verify(second.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Restore(second.ID, restorePath); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, restorePath, 0, 1000, "v1-")
+	checkKeys(t, restorePath, 1000, 1100, "v2-")
+	_ = os.RemoveAll(restorePath)
+
+	if err := engine.PurgeOldBackups(0); err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ := engine.Backups(); len(infos) != 0 {
+		t.Fatalf("expected no backups, got %d", len(infos))
+	}
+}
+
+func TestBackupCorrupted(t *testing.T) {
+	_ = os.RemoveAll(dbPath)
+	_ = os.RemoveAll(backupPath)
+	_ = os.RemoveAll(restorePath)
+
+	db, err := yldb.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	engine, err := Open(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKeys(t, db, 0, 100, "")
+	info, err := engine.CreateBackup(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var table string
+	for _, file := range info.Files {
+		if filepath.Ext(file.Path) == ".ldb" {
+			table = filepath.Join(backupPath, file.BackupPath)
+		}
+	}
+	if table == "" {
+		t.Fatal("backup contains no table file")
+	}
+	data, _ := ioutil.ReadFile(table)
+	data[0] ^= 0xff
+	_ = ioutil.WriteFile(table, data, 0644)
+
+	if err := engine.Verify(info.ID); err != errors.ErrBackupCorrupted {
+		t.Fatalf("expected ErrBackupCorrupted, got %v", err)
+	}
+	if err := engine.Restore(info.ID, restorePath); err != errors.ErrBackupCorrupted {
+		t.Fatalf("expected ErrBackupCorrupted, got %v", err)
+	}
+	if _, err := os.Stat(restorePath); !os.IsNotExist(err) {
+		t.Fatal("restore directory not removed")
+	}
+}
+
+// 非默认列族的SST文件备份到shared中对应的子目录，未变化的文件不会再次拷贝
+func TestBackupColumnFamily(t *testing.T) {
+	_ = os.RemoveAll(dbPath)
+	_ = os.RemoveAll(backupPath)
+	_ = os.RemoveAll(restorePath)
+
+	db, err := yldb.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	engine, err := Open(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := db.CreateColumnFamily("cf", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCF(cf, []byte("cf-key"), []byte("cf-value"), nil); err != nil {
+		t.Fatal(err)
+	}
+	first, err := engine.CreateBackup(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := engine.CreateBackup(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range second.Files {
+		if filepath.Ext(file.Path) == ".ldb" && filepath.Dir(file.BackupPath) != filepath.Join("shared", filepath.Dir(file.Path)) {
+			t.Fatalf("unexpected backup path %s for %s", file.BackupPath, file.Path)
+		}
+	}
+	if second.CopiedSize >= first.CopiedSize {
+		t.Fatalf("second backup copied %d bytes, first %d", second.CopiedSize, first.CopiedSize)
+	}
+	if _, err := os.Stat(filepath.Join(backupPath, "checkpoint.tmp")); !os.IsNotExist(err) {
+		t.Fatal("backup should not create a checkpoint")
+	}
+
+	if err := engine.Restore(second.ID, restorePath); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := yldb.Open(restorePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if value, err := restored.GetCF(restored.GetColumnFamily("cf"), []byte("cf-key"), nil); err != nil || string(value) != "cf-value" {
+		t.Fatalf("GetCF = %q, %v", value, err)
+	}
+}
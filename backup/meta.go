@@ -0,0 +1,135 @@
+package backup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+)
+
+// Info 描述一个备份版本
+type Info struct {
+	ID        uint32
+	Timestamp time.Time
+	// 恢复后数据库目录的总大小
+	Size int64
+	// 本次备份实际新拷贝的字节数，与之前的备份共享的SST文件不计入
+	CopiedSize int64
+	Files      []FileInfo
+}
+
+// FileInfo 描述备份中的一个文件
+type FileInfo struct {
+	// 文件在数据库目录下的相对路径
+	Path string
+	// 文件在备份目录下的相对路径
+	BackupPath string
+	Size       int64
+	Checksum   uint32
+}
+
+func (info *Info) add(file FileInfo, copied bool) {
+	info.Files = append(info.Files, file)
+	info.Size += file.Size
+	if copied {
+		info.CopiedSize += file.Size
+	}
+}
+
+// 元数据文件为文本格式：
+// 第一行：备份时间（Unix纳秒）
+// 第二行：文件数量
+// 之后每行一个文件：相对路径 备份路径 大小 CRC32C校验和
+func (info *Info) encodeTo(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%d\n%d\n", info.Timestamp.UnixNano(), len(info.Files))
+	for _, file := range info.Files {
+		fmt.Fprintf(bw, "%s %s %d %08x\n", filepath.ToSlash(file.Path), filepath.ToSlash(file.BackupPath),
+			file.Size, file.Checksum)
+	}
+	return bw.Flush()
+}
+
+func (info *Info) decodeFrom(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var timestamp int64
+	var numFiles int
+	if _, err := fmt.Fscanf(br, "%d\n%d\n", &timestamp, &numFiles); err != nil || numFiles < 0 {
+		return errors.ErrBackupMetaCorrupted
+	}
+	info.Timestamp = time.Unix(0, timestamp)
+	info.Files = make([]FileInfo, numFiles)
+	info.Size = 0
+	for i := range info.Files {
+		var path, backupPath, checksum string
+		file := &info.Files[i]
+		if _, err := fmt.Fscanf(br, "%s %s %d %s\n", &path, &backupPath, &file.Size, &checksum); err != nil {
+			return errors.ErrBackupMetaCorrupted
+		}
+		crc, err := strconv.ParseUint(checksum, 16, 32)
+		if err != nil {
+			return errors.ErrBackupMetaCorrupted
+		}
+		file.Path = filepath.FromSlash(path)
+		file.BackupPath = filepath.FromSlash(backupPath)
+		file.Checksum = uint32(crc)
+		info.Size += file.Size
+	}
+	return nil
+}
+
+func readMeta(fileName string, id uint32) (*Info, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.ErrBackupNotFound
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	info := &Info{ID: id}
+	if err := info.decodeFrom(file); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// 先写入临时文件再重命名，元数据文件存在即表示该备份完整
+func writeMeta(fileName string, info *Info) error {
+	tmp := fileName + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	errs := []error{info.encodeTo(file), file.Sync(), file.Close()}
+	for _, err := range errs {
+		if err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, fileName)
+}
+
+// 返回metaDir下所有备份的ID
+func listMeta(metaDir string) ([]uint32, error) {
+	infos, err := ioutil.ReadDir(metaDir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for _, info := range infos {
+		id, err := strconv.ParseUint(info.Name(), 10, 32)
+		if err == nil {
+			ids = append(ids, uint32(id))
+		}
+	}
+	return ids, nil
+}
@@ -2,24 +2,52 @@ package yldb
 
 import (
 	"encoding/binary"
+	"fmt"
+	"time"
 
+	"github.com/Cauchy-NY/yldb/errors"
 	"github.com/Cauchy-NY/yldb/ikey"
 )
 
 const (
 	batchHeaderLen    = 12
 	invalidBatchCount = 1<<32 - 1
+
+	// 只出现在Batch中的操作类型，表示写入非默认列族，操作类型之后紧跟varint编码的列族ID
+	batchKindColumnFamilyDelete ikey.InternalKeyKind = 4
+	batchKindColumnFamilySet    ikey.InternalKeyKind = 5
+	// 写入非默认列族的带过期时间的操作
+	batchKindColumnFamilySetWithTTL ikey.InternalKeyKind = 6
 )
 
+// BatchHandler 按写入顺序接收Batch回放出的每一个操作，任一方法返回error时回放终止
+// 之后新增的操作类型会以独立的可选接口提供，未实现对应接口的handler将收到错误
+type BatchHandler interface {
+	Set(key, value []byte) error
+	Delete(key []byte) error
+}
+
+// ColumnFamilyBatchHandler 接收写入非默认列族的操作，写入默认列族的操作仍通过BatchHandler回放
+type ColumnFamilyBatchHandler interface {
+	SetCF(cfID uint32, key, value []byte) error
+	DeleteCF(cfID uint32, key []byte) error
+}
+
+// TTLBatchHandler 接收带过期时间的写入操作，cfID为写入的列族ID，expiration为过期时刻的UnixNano
+type TTLBatchHandler interface {
+	SetWithTTL(cfID uint32, key, value []byte, expiration int64) error
+}
+
 // Batch 是一系列Set和Get的集合
 type Batch struct {
 	// Batch头部：
 	// - 首8字节：小端模式的操作序列号
 	// - 次4字节：小端模式的操作数量
 	// Batch内容：
-	// - 1字节：操作类型 Set(1) Delete(0)
+	// - 1字节：操作类型 Set(1) Delete(0) SetWithTTL(2) ColumnFamilySet(5) ColumnFamilyDelete(4) ColumnFamilySetWithTTL(6)
+	// - 列族ID（仅列族操作）
 	// - k/v 长度
-	// - k/v 内容
+	// - k/v 内容（带过期时间的操作的v为ikey.EncodeTTLValue编码后的内容）
 	data []byte
 }
 
@@ -44,6 +72,157 @@ func (b *Batch) Delete(key []byte) {
 	}
 }
 
+// 写入列族cf，cf为默认列族时与Set相同
+func (b *Batch) SetCF(cf *ColumnFamily, key, value []byte) {
+	if cf.id == defaultColumnFamilyID {
+		b.Set(key, value)
+		return
+	}
+	if len(b.data) == 0 {
+		b.init(len(key) + len(value) + 3*binary.MaxVarintLen64 + batchHeaderLen + 1)
+	}
+	if b.increment() {
+		b.data = append(b.data, byte(batchKindColumnFamilySet))
+		b.appendUvarint(uint64(cf.id))
+		b.appendKV(key)
+		b.appendKV(value)
+	}
+}
+
+// 删除列族cf中的key，cf为默认列族时与Delete相同
+func (b *Batch) DeleteCF(cf *ColumnFamily, key []byte) {
+	if cf.id == defaultColumnFamilyID {
+		b.Delete(key)
+		return
+	}
+	if len(b.data) == 0 {
+		b.init(len(key) + 2*binary.MaxVarintLen64 + batchHeaderLen + 1)
+	}
+	if b.increment() {
+		b.data = append(b.data, byte(batchKindColumnFamilyDelete))
+		b.appendUvarint(uint64(cf.id))
+		b.appendKV(key)
+	}
+}
+
+// 写入在ttl之后过期的key，过期之后读不到该key，并在之后的Compaction中被清除
+func (b *Batch) SetWithTTL(key, value []byte, ttl time.Duration) {
+	b.setWithTTL(defaultColumnFamilyID, key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// 写入列族cf中在ttl之后过期的key
+func (b *Batch) SetCFWithTTL(cf *ColumnFamily, key, value []byte, ttl time.Duration) {
+	b.setWithTTL(cf.id, key, value, time.Now().Add(ttl).UnixNano())
+}
+
+func (b *Batch) setWithTTL(cfID uint32, key, value []byte, expiration int64) {
+	if len(b.data) == 0 {
+		b.init(len(key) + len(value) + 3*binary.MaxVarintLen64 + batchHeaderLen + 9)
+	}
+	if b.increment() {
+		if cfID == defaultColumnFamilyID {
+			b.data = append(b.data, byte(ikey.InternalKeyKindSetWithTTL))
+		} else {
+			b.data = append(b.data, byte(batchKindColumnFamilySetWithTTL))
+			b.appendUvarint(uint64(cfID))
+		}
+		b.appendKV(key)
+		b.appendKV(ikey.EncodeTTLValue(value, expiration))
+	}
+}
+
+// 返回Batch的完整编码（包含头部），可用于持久化或跨进程传输
+func (b *Batch) Repr() []byte {
+	return b.data
+}
+
+// 以编码后的数据重置Batch，data格式错误时返回*errors.BatchError且Batch保持不变
+// Batch会直接引用data，调用方之后不应再修改data
+func (b *Batch) SetRepr(data []byte) error {
+	tmp := Batch{data: data}
+	if err := tmp.Iterate(nopBatchHandler{}); err != nil {
+		return err
+	}
+	b.data = data
+	return nil
+}
+
+// 返回Batch中的操作数量
+func (b *Batch) Count() uint32 {
+	if len(b.data) < batchHeaderLen {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b.countData())
+}
+
+// 返回Batch编码后的字节数
+func (b *Batch) Size() int {
+	return len(b.data)
+}
+
+// 返回Batch写入数据库时分配的首个序列号，未写入的Batch返回0
+func (b *Batch) SeqNum() uint64 {
+	if len(b.data) < batchHeaderLen {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b.seqNumData())
+}
+
+// 按写入顺序将Batch中的操作回放给handler
+// 编码错误时返回*errors.BatchError，指出出错的操作序号及其字节偏移
+func (b *Batch) Iterate(handler BatchHandler) error {
+	if len(b.data) == 0 {
+		return nil
+	}
+	if len(b.data) < batchHeaderLen {
+		return &errors.BatchError{Offset: 0, Reason: fmt.Sprintf("header too short: %d bytes", len(b.data))}
+	}
+
+	cfHandler, supportCF := handler.(ColumnFamilyBatchHandler)
+	var index uint32
+	it := b.iterator()
+	for len(it) > 0 {
+		offset := len(b.data) - len(it)
+		kind, cfID, userKey, value, reason := it.decode()
+		if reason != "" {
+			return &errors.BatchError{Index: index, Offset: offset, Reason: reason}
+		}
+		if cfID != defaultColumnFamilyID && !supportCF {
+			return errors.ErrBatchUnsupportedKind
+		}
+		var err error
+		switch {
+		case kind == ikey.InternalKeyKindSetWithTTL:
+			ttlHandler, supportTTL := handler.(TTLBatchHandler)
+			if !supportTTL {
+				return errors.ErrBatchUnsupportedKind
+			}
+			expiration, userValue, _ := ikey.DecodeTTLValue(value)
+			err = ttlHandler.SetWithTTL(cfID, userKey, userValue, expiration)
+		case kind == ikey.InternalKeyKindSet && cfID == defaultColumnFamilyID:
+			err = handler.Set(userKey, value)
+		case kind == ikey.InternalKeyKindDelete && cfID == defaultColumnFamilyID:
+			err = handler.Delete(userKey)
+		case kind == ikey.InternalKeyKindSet:
+			err = cfHandler.SetCF(cfID, userKey, value)
+		case kind == ikey.InternalKeyKindDelete:
+			err = cfHandler.DeleteCF(cfID, userKey)
+		}
+		if err != nil {
+			return err
+		}
+		index++
+	}
+	if count := b.Count(); count != index {
+		return &errors.BatchError{
+			Index:  index,
+			Offset: len(b.data),
+			Reason: fmt.Sprintf("count mismatch: header says %d, found %d", count, index),
+		}
+	}
+	return nil
+}
+
 func (b *Batch) init(cap int) {
 	n := 256
 	for n < cap {
@@ -84,19 +263,15 @@ func (b *Batch) setSeqNum(seqNum uint64) {
 	binary.LittleEndian.PutUint64(b.seqNumData(), seqNum)
 }
 
-func (b *Batch) seqNum() uint64 {
-	return binary.LittleEndian.Uint64(b.seqNumData())
-}
-
-func (b *Batch) count() uint32 {
-	return binary.LittleEndian.Uint32(b.countData())
+func (b *Batch) appendKV(kv []byte) {
+	b.appendUvarint(uint64(len(kv)))
+	b.data = append(b.data, kv...)
 }
 
-func (b *Batch) appendKV(kv []byte) {
+func (b *Batch) appendUvarint(u uint64) {
 	var buf [binary.MaxVarintLen64]byte
-	n := binary.PutUvarint(buf[:], uint64(len(kv)))
+	n := binary.PutUvarint(buf[:], u)
 	b.data = append(b.data, buf[:n]...)
-	b.data = append(b.data, kv...)
 }
 
 func (b *Batch) iterator() BatchIterator {
@@ -105,27 +280,55 @@ func (b *Batch) iterator() BatchIterator {
 
 type BatchIterator []byte
 
-// 返回这个batch的下一个操作
+// 返回这个batch中写入默认列族的下一个操作，遇到其他列族的操作时返回false
 func (t *BatchIterator) next() (kind ikey.InternalKeyKind, userKey []byte, value []byte, ok bool) {
+	kind, cfID, userKey, value, reason := t.decode()
+	if reason != "" || cfID != defaultColumnFamilyID {
+		return 0, nil, nil, false
+	}
+	return kind, userKey, value, true
+}
+
+// 解码下一个操作，列族操作的kind会被还原为Set/Delete/SetWithTTL，失败时返回非空的错误原因
+func (t *BatchIterator) decode() (kind ikey.InternalKeyKind, cfID uint32, userKey []byte, value []byte, reason string) {
 	p := *t
 	if len(p) == 0 {
-		return 0, nil, nil, false
+		return 0, 0, nil, nil, "unexpected end of batch"
 	}
 	kind, *t = ikey.InternalKeyKind(p[0]), p[1:]
-	if kind > ikey.InternalKeyKindMax {
-		return 0, nil, nil, false
+	switch kind {
+	case ikey.InternalKeyKindSet, ikey.InternalKeyKindDelete, ikey.InternalKeyKindSetWithTTL:
+	case batchKindColumnFamilySet, batchKindColumnFamilyDelete, batchKindColumnFamilySetWithTTL:
+		u, numBytes := binary.Uvarint(*t)
+		if numBytes <= 0 || u > 1<<32-1 {
+			return 0, 0, nil, nil, "malformed column family id"
+		}
+		cfID, *t = uint32(u), (*t)[numBytes:]
+		switch kind {
+		case batchKindColumnFamilySet:
+			kind = ikey.InternalKeyKindSet
+		case batchKindColumnFamilySetWithTTL:
+			kind = ikey.InternalKeyKindSetWithTTL
+		default:
+			kind = ikey.InternalKeyKindDelete
+		}
+	default:
+		return 0, 0, nil, nil, fmt.Sprintf("unknown kind %d", kind)
 	}
-	userKey, ok = t.nextStr()
+	userKey, ok := t.nextStr()
 	if !ok {
-		return 0, nil, nil, false
+		return 0, 0, nil, nil, "malformed key"
 	}
-	if kind == ikey.InternalKeyKindSet {
+	if kind != ikey.InternalKeyKindDelete {
 		value, ok = t.nextStr()
 		if !ok {
-			return 0, nil, nil, false
+			return 0, 0, nil, nil, "malformed value"
+		}
+		if _, _, ok := ikey.DecodeTTLValue(value); kind == ikey.InternalKeyKindSetWithTTL && !ok {
+			return 0, 0, nil, nil, "malformed ttl value"
 		}
 	}
-	return kind, userKey, value, true
+	return kind, cfID, userKey, value, ""
 }
 
 func (t *BatchIterator) nextStr() (s []byte, ok bool) {
@@ -141,3 +344,25 @@ func (t *BatchIterator) nextStr() (s []byte, ok bool) {
 	s, *t = p[:u], p[u:]
 	return s, true
 }
+
+type nopBatchHandler struct{}
+
+func (nopBatchHandler) Set(key, value []byte) error {
+	return nil
+}
+
+func (nopBatchHandler) Delete(key []byte) error {
+	return nil
+}
+
+func (nopBatchHandler) SetCF(cfID uint32, key, value []byte) error {
+	return nil
+}
+
+func (nopBatchHandler) DeleteCF(cfID uint32, key []byte) error {
+	return nil
+}
+
+func (nopBatchHandler) SetWithTTL(cfID uint32, key, value []byte, expiration int64) error {
+	return nil
+}
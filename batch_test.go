@@ -2,9 +2,12 @@ package yldb
 
 import (
 	"encoding/binary"
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/Cauchy-NY/yldb/errors"
 	"github.com/Cauchy-NY/yldb/ikey"
 )
 
@@ -97,3 +100,89 @@ func TestBatchIncrement(t *testing.T) {
 		}
 	}
 }
+
+type recordingHandler struct {
+	ops []string
+}
+
+func (h *recordingHandler) Set(key, value []byte) error {
+	h.ops = append(h.ops, "set:"+string(key)+"="+string(value))
+	return nil
+}
+
+func (h *recordingHandler) Delete(key []byte) error {
+	h.ops = append(h.ops, "delete:"+string(key))
+	return nil
+}
+
+func (h *recordingHandler) SetCF(cfID uint32, key, value []byte) error {
+	h.ops = append(h.ops, fmt.Sprintf("set(%d):%s=%s", cfID, key, value))
+	return nil
+}
+
+func (h *recordingHandler) DeleteCF(cfID uint32, key []byte) error {
+	h.ops = append(h.ops, fmt.Sprintf("delete(%d):%s", cfID, key))
+	return nil
+}
+
+func TestBatchRepr(t *testing.T) {
+	var b Batch
+	b.Set([]byte("roses"), []byte("red"))
+	b.Delete([]byte("violets"))
+	b.Set([]byte("grass"), []byte("green"))
+
+	var decoded Batch
+	if err := decoded.SetRepr(append([]byte(nil), b.Repr()...)); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Count() != 3 || decoded.Size() != b.Size() {
+		t.Fatalf("got count=%d size=%d, want count=3 size=%d", decoded.Count(), decoded.Size(), b.Size())
+	}
+
+	var h recordingHandler
+	if err := decoded.Iterate(&h); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"set:roses=red", "delete:violets", "set:grass=green"}
+	if !reflect.DeepEqual(h.ops, want) {
+		t.Errorf("got %v, want %v", h.ops, want)
+	}
+}
+
+func TestBatchReprInvalid(t *testing.T) {
+	var b Batch
+	b.Set([]byte("roses"), []byte("red"))
+	b.Set([]byte("grass"), []byte("green"))
+	data := b.Repr()
+	// 第二个操作从头部(12字节) + kind(1) + len(1) + "roses" + len(1) + "red" 之后开始
+	secondOp := batchHeaderLen + 1 + 1 + len("roses") + 1 + len("red")
+	countMismatch := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(countMismatch[8:12], 3)
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		index  uint32
+		offset int
+	}{
+		{"short header", data[:8], 0, 0},
+		{"truncated value", data[:len(data)-1], 1, secondOp},
+		{"unknown kind", append(append([]byte(nil), data[:secondOp]...), 0x7f), 1, secondOp},
+		{"count mismatch", countMismatch, 2, len(data)},
+	}
+	for _, tc := range testCases {
+		var decoded Batch
+		err := decoded.SetRepr(tc.data)
+		batchErr, ok := err.(*errors.BatchError)
+		if !ok {
+			t.Fatalf("%s: got %v, want *errors.BatchError", tc.name, err)
+		}
+		if batchErr.Index != tc.index || batchErr.Offset != tc.offset {
+			t.Errorf("%s: got op %d at offset %d, want op %d at offset %d",
+				tc.name, batchErr.Index, batchErr.Offset, tc.index, tc.offset)
+		}
+		if decoded.Size() != 0 {
+			t.Errorf("%s: batch modified after invalid SetRepr", tc.name)
+		}
+	}
+}
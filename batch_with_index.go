@@ -0,0 +1,337 @@
+package yldb
+
+import (
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/ikey"
+	"github.com/Cauchy-NY/yldb/memdb"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+// BatchWithIndex 在Batch之外维护一个按key有序的索引，
+// 使得在提交之前就能读取（或与数据库合并后读取）batch中尚未提交的写入
+type BatchWithIndex struct {
+	batch Batch
+	// 索引中InternalKey的序列号为操作在batch中的次序，同一key后写入的操作排在前面
+	index *memdb.MemTable
+	count uint64
+	cmp   utils.Comparator
+}
+
+// 索引按opts中的比较器排序，需要与batch将要写入的列族使用相同的比较器，opts为nil时使用DefaultComparator
+func NewBatchWithIndex(opts *utils.ColumnFamilyOptions) *BatchWithIndex {
+	cmp := opts.GetComparator()
+	return &BatchWithIndex{
+		index: memdb.NewMemTable(cmp),
+		cmp:   cmp,
+	}
+}
+
+func (b *BatchWithIndex) Set(key, value []byte) {
+	b.batch.Set(key, value)
+	b.count++
+	_ = b.index.Set(ikey.MakeInternalKey(nil, key, ikey.InternalKeyKindSet, b.count), copyBytes(value))
+}
+
+func (b *BatchWithIndex) Delete(key []byte) {
+	b.batch.Delete(key)
+	b.count++
+	_ = b.index.Set(ikey.MakeInternalKey(nil, key, ikey.InternalKeyKindDelete, b.count), nil)
+}
+
+// 返回底层的Batch，可直接通过YLDB.Apply提交
+func (b *BatchWithIndex) Batch() Batch {
+	return b.batch
+}
+
+// 只在batch中查找key，batch中没有该key或最后一次操作为删除时返回ErrDBNotFound
+func (b *BatchWithIndex) Get(key []byte) ([]byte, error) {
+	kind, value, found := b.lookup(key)
+	if !found || kind == ikey.InternalKeyKindDelete {
+		return nil, errors.ErrDBNotFound
+	}
+	return value, nil
+}
+
+// 在batch覆盖于数据库（或opts指定的快照）之上的视图中查找key
+func (b *BatchWithIndex) GetFromDB(db *YLDB, key []byte, opts *utils.ReadOptions) ([]byte, error) {
+	kind, value, found := b.lookup(key)
+	if !found {
+		return db.Get(key, opts)
+	}
+	if kind == ikey.InternalKeyKindDelete {
+		return nil, errors.ErrDBNotFound
+	}
+	return value, nil
+}
+
+// 返回只遍历batch的迭代器，被删除的key不可见
+func (b *BatchWithIndex) NewIterator() Iterator {
+	return newDBIterator(b.cmp, b.index.Iterator(), ikey.InternalKeySeqNumMax)
+}
+
+// 返回将batch覆盖在base之上的迭代器，base通常为YLDB.NewIterator的返回值
+// 同一key以batch中的最后一次操作为准，batch中删除的key不可见
+func (b *BatchWithIndex) NewIteratorWithBase(base Iterator) Iterator {
+	return &baseDeltaIterator{
+		cmp:     b.cmp,
+		base:    base,
+		delta:   &batchIndexIterator{cmp: b.cmp, iter: b.index.Iterator()},
+		forward: true,
+	}
+}
+
+// 返回key在batch中的最后一次操作
+func (b *BatchWithIndex) lookup(key []byte) (ikey.InternalKeyKind, []byte, bool) {
+	internalKey, value, err := b.index.Find(key, ikey.InternalKeySeqNumMax)
+	if err != nil {
+		return 0, nil, false
+	}
+	return internalKey.Kind(), value, true
+}
+
+//-------------------------------batchIndexIterator---------------------------------
+
+// 遍历batch索引，同一user_key只停留在最后一次操作上（包括删除）
+type batchIndexIterator struct {
+	cmp  utils.Comparator
+	iter *memdb.MemIterator
+}
+
+func (it *batchIndexIterator) Valid() bool {
+	return it.iter.Valid()
+}
+
+func (it *batchIndexIterator) InternalKey() ikey.InternalKey {
+	return it.iter.InternalKey()
+}
+
+func (it *batchIndexIterator) UserKey() []byte {
+	return it.iter.UserKey()
+}
+
+func (it *batchIndexIterator) Value() []byte {
+	return it.iter.Value()
+}
+
+func (it *batchIndexIterator) Next() {
+	cur := it.iter.UserKey()
+	for it.iter.Next(); it.iter.Valid() && it.cmp.Compare(it.iter.UserKey(), cur) == 0; it.iter.Next() {
+	}
+}
+
+func (it *batchIndexIterator) Prev() {
+	it.iter.Prev()
+	it.toNewest()
+}
+
+func (it *batchIndexIterator) Seek(target []byte) {
+	it.iter.Seek(target)
+}
+
+func (it *batchIndexIterator) SeekToFirst() {
+	it.iter.SeekToFirst()
+}
+
+func (it *batchIndexIterator) SeekToLast() {
+	it.iter.SeekToLast()
+	it.toNewest()
+}
+
+// 迭代器位于某个user_key的最旧操作上时，后退到该user_key的最新操作
+func (it *batchIndexIterator) toNewest() {
+	if !it.iter.Valid() {
+		return
+	}
+	cur := it.iter.UserKey()
+	for {
+		it.iter.Prev()
+		if !it.iter.Valid() {
+			it.iter.SeekToFirst()
+			return
+		}
+		if it.cmp.Compare(it.iter.UserKey(), cur) != 0 {
+			it.iter.Next()
+			return
+		}
+	}
+}
+
+//-------------------------------batchIndexIterator---------------------------------
+
+//--------------------------------baseDeltaIterator---------------------------------
+
+// 将batch索引（delta）合并到base迭代器之上
+// key相同时以delta为准，delta为删除操作时两边都跳过
+type baseDeltaIterator struct {
+	cmp   utils.Comparator
+	base  Iterator
+	delta *batchIndexIterator
+	// forward为false时表示反向迭代
+	forward       bool
+	currentAtBase bool
+	equalKeys     bool
+}
+
+// 同时关闭base迭代器
+func (it *baseDeltaIterator) Close() {
+	it.base.Close()
+}
+
+func (it *baseDeltaIterator) Valid() bool {
+	if it.currentAtBase {
+		return it.base.Valid()
+	}
+	return it.delta.Valid()
+}
+
+func (it *baseDeltaIterator) InternalKey() ikey.InternalKey {
+	if it.currentAtBase {
+		return it.base.InternalKey()
+	}
+	return it.delta.InternalKey()
+}
+
+func (it *baseDeltaIterator) UserKey() []byte {
+	if it.currentAtBase {
+		return it.base.UserKey()
+	}
+	return it.delta.UserKey()
+}
+
+func (it *baseDeltaIterator) Value() []byte {
+	if it.currentAtBase {
+		return it.base.Value()
+	}
+	return it.delta.Value()
+}
+
+func (it *baseDeltaIterator) Next() {
+	if !it.forward {
+		// 由反向改为正向：较小一侧的迭代器需要越过当前key
+		it.forward = true
+		it.equalKeys = false
+		if !it.base.Valid() {
+			it.base.SeekToFirst()
+		} else if !it.delta.Valid() {
+			it.delta.SeekToFirst()
+		} else if it.currentAtBase {
+			it.advanceDelta()
+		} else {
+			it.advanceBase()
+		}
+		if it.base.Valid() && it.delta.Valid() && it.cmp.Compare(it.delta.UserKey(), it.base.UserKey()) == 0 {
+			it.equalKeys = true
+		}
+	}
+	it.advance()
+}
+
+func (it *baseDeltaIterator) Prev() {
+	if it.forward {
+		// 由正向改为反向：较大一侧的迭代器需要退回到当前key之前
+		it.forward = false
+		it.equalKeys = false
+		if !it.base.Valid() {
+			it.base.SeekToLast()
+		} else if !it.delta.Valid() {
+			it.delta.SeekToLast()
+		} else if it.currentAtBase {
+			it.advanceDelta()
+		} else {
+			it.advanceBase()
+		}
+		if it.base.Valid() && it.delta.Valid() && it.cmp.Compare(it.delta.UserKey(), it.base.UserKey()) == 0 {
+			it.equalKeys = true
+		}
+	}
+	it.advance()
+}
+
+func (it *baseDeltaIterator) Seek(target []byte) {
+	it.forward = true
+	it.base.Seek(target)
+	it.delta.Seek(target)
+	it.updateCurrent()
+}
+
+func (it *baseDeltaIterator) SeekToFirst() {
+	it.forward = true
+	it.base.SeekToFirst()
+	it.delta.SeekToFirst()
+	it.updateCurrent()
+}
+
+func (it *baseDeltaIterator) SeekToLast() {
+	it.forward = false
+	it.base.SeekToLast()
+	it.delta.SeekToLast()
+	it.updateCurrent()
+}
+
+func (it *baseDeltaIterator) advance() {
+	if it.equalKeys {
+		it.advanceBase()
+		it.advanceDelta()
+	} else if it.currentAtBase {
+		it.advanceBase()
+	} else {
+		it.advanceDelta()
+	}
+	it.updateCurrent()
+}
+
+func (it *baseDeltaIterator) advanceBase() {
+	if it.forward {
+		it.base.Next()
+	} else {
+		it.base.Prev()
+	}
+}
+
+func (it *baseDeltaIterator) advanceDelta() {
+	if it.forward {
+		it.delta.Next()
+	} else {
+		it.delta.Prev()
+	}
+}
+
+// 在base和delta中选出当前位置，跳过delta中的删除操作
+func (it *baseDeltaIterator) updateCurrent() {
+	for {
+		it.equalKeys = false
+		if !it.delta.Valid() {
+			it.currentAtBase = true
+			return
+		}
+		deltaDeleted := it.delta.InternalKey().Kind() == ikey.InternalKeyKindDelete
+		if !it.base.Valid() {
+			if deltaDeleted {
+				it.advanceDelta()
+				continue
+			}
+			it.currentAtBase = false
+			return
+		}
+
+		c := it.cmp.Compare(it.delta.UserKey(), it.base.UserKey())
+		if !it.forward {
+			c = -c
+		}
+		if c > 0 {
+			it.currentAtBase = true
+			return
+		}
+		it.equalKeys = c == 0
+		if !deltaDeleted {
+			it.currentAtBase = false
+			return
+		}
+		it.advanceDelta()
+		if it.equalKeys {
+			it.advanceBase()
+		}
+	}
+}
+
+//--------------------------------baseDeltaIterator---------------------------------
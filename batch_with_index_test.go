@@ -0,0 +1,139 @@
+package yldb
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var batchWithIndexPath = "./test_data/test_batch_with_index"
+
+func collect(it Iterator, reverse bool) []string {
+	defer it.Close()
+	var result []string
+	if reverse {
+		for it.SeekToLast(); it.Valid(); it.Prev() {
+			result = append(result, string(it.UserKey())+"="+string(it.Value()))
+		}
+	} else {
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			result = append(result, string(it.UserKey())+"="+string(it.Value()))
+		}
+	}
+	return result
+}
+
+func TestBatchWithIndexGet(t *testing.T) {
+	b := NewBatchWithIndex(nil)
+	b.Set([]byte("apple"), []byte("red"))
+	b.Set([]byte("apple"), []byte("green"))
+	b.Set([]byte("peach"), []byte("yellow"))
+	b.Delete([]byte("peach"))
+
+	if val, err := b.Get([]byte("apple")); err != nil || string(val) != "green" {
+		t.Fatalf("get apple: got (%q, %v), want (%q, nil)", val, err, "green")
+	}
+	if _, err := b.Get([]byte("peach")); err != errors.ErrDBNotFound {
+		t.Fatalf("get peach: got %v, want %v", err, errors.ErrDBNotFound)
+	}
+	if got, want := collect(b.NewIterator(), false), []string{"apple=green"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("iterate: got %v, want %v", got, want)
+	}
+}
+
+func TestBatchWithIndexOnDB(t *testing.T) {
+	_ = os.RemoveAll(batchWithIndexPath)
+	db, err := Open(batchWithIndexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, k := range []string{"b", "d", "f", "h"} {
+		_ = db.Set([]byte(k), []byte("db"), nil)
+	}
+	snapshot := db.GetSnapshot()
+	defer db.ReleaseSnapshot(snapshot)
+	_ = db.Set([]byte("j"), []byte("db"), nil)
+
+	b := NewBatchWithIndex(nil)
+	b.Set([]byte("a"), []byte("batch"))
+	b.Set([]byte("d"), []byte("batch"))
+	b.Delete([]byte("f"))
+	b.Delete([]byte("z"))
+	b.Set([]byte("i"), []byte("batch"))
+
+	if val, _ := b.GetFromDB(db, []byte("d"), nil); string(val) != "batch" {
+		t.Fatalf("get d: got %q, want %q", val, "batch")
+	}
+	if val, _ := b.GetFromDB(db, []byte("b"), nil); string(val) != "db" {
+		t.Fatalf("get b: got %q, want %q", val, "db")
+	}
+	if _, err := b.GetFromDB(db, []byte("f"), nil); err != errors.ErrDBNotFound {
+		t.Fatalf("get f: got %v, want %v", err, errors.ErrDBNotFound)
+	}
+
+	want := []string{"a=batch", "b=db", "d=batch", "h=db", "i=batch", "j=db"}
+	if got := collect(b.NewIteratorWithBase(db.NewIterator(nil)), false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("forward: got %v, want %v", got, want)
+	}
+	reversed := []string{"j=db", "i=batch", "h=db", "d=batch", "b=db", "a=batch"}
+	if got := collect(b.NewIteratorWithBase(db.NewIterator(nil)), true); !reflect.DeepEqual(got, reversed) {
+		t.Fatalf("reverse: got %v, want %v", got, reversed)
+	}
+
+	// 基于快照时看不到快照之后写入的j
+	opts := &utils.ReadOptions{Snapshot: snapshot}
+	want = []string{"a=batch", "b=db", "d=batch", "h=db", "i=batch"}
+	if got := collect(b.NewIteratorWithBase(db.NewIterator(opts)), false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshot: got %v, want %v", got, want)
+	}
+
+	// 方向切换
+	it := b.NewIteratorWithBase(db.NewIterator(nil))
+	defer it.Close()
+	it.Seek([]byte("e"))
+	if !it.Valid() || string(it.UserKey()) != "h" {
+		t.Fatalf("seek e: got %q, want %q", it.UserKey(), "h")
+	}
+	it.Prev()
+	if !it.Valid() || string(it.UserKey()) != "d" {
+		t.Fatalf("prev: got %q, want %q", it.UserKey(), "d")
+	}
+	it.Next()
+	if !it.Valid() || string(it.UserKey()) != "h" {
+		t.Fatalf("next: got %q, want %q", it.UserKey(), "h")
+	}
+
+	if err := db.Apply(b.Batch(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(db.NewIterator(nil), false); !reflect.DeepEqual(got, []string{"a=batch", "b=db", "d=batch", "h=db", "i=batch", "j=db"}) {
+		t.Fatalf("after apply: got %v", got)
+	}
+}
+
+// 索引使用与列族相同的比较器，与列族的迭代器按同一顺序合并
+func TestBatchWithIndexComparator(t *testing.T) {
+	_ = os.RemoveAll(batchWithIndexPath)
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{Comparator: reverseComparator{}}}
+	db, err := OpenWithOptions(batchWithIndexPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, k := range []string{"b", "d"} {
+		_ = db.Set([]byte(k), []byte("db"), nil)
+	}
+
+	b := NewBatchWithIndex(&opts.ColumnFamilyOptions)
+	b.Set([]byte("a"), []byte("batch"))
+	b.Set([]byte("c"), []byte("batch"))
+	b.Delete([]byte("d"))
+	want := []string{"c=batch", "b=db", "a=batch"}
+	if got := collect(b.NewIteratorWithBase(db.NewIterator(nil)), false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("forward: got %v, want %v", got, want)
+	}
+}
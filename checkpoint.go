@@ -0,0 +1,105 @@
+package yldb
+
+import (
+	"io"
+	"os"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+// 在dir目录下创建数据库当前状态的一致性副本，dir不能已经存在，创建完成后可以直接用Open打开
+// 先将MemTable写入SST文件，再将所有SST文件硬链接到dir中（不支持硬链接时复制），
+// flush期间新写入的数据仍在WAL中，因此同时复制尚未持久化的WAL，最后写入新的MANIFEST和CURRENT
+func (db *YLDB) Checkpoint(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return errors.ErrCheckpointDirExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if err := db.flush(); err != nil {
+		return err
+	}
+	if err := db.writeCheckpoint(dir); err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+// 调用方需持有db.mutex，后台任务只在持有db.mutex时修改Version和删除文件，因此期间引用的SST文件都存在
+func (db *YLDB) writeCheckpoint(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, cf := range db.columnFamilies() {
+		target := dir
+		if cf.id != defaultColumnFamilyID {
+			target = utils.ColumnFamilyDirName(dir, cf.id)
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		}
+		for _, number := range cf.current.LiveFiles() {
+			if err := linkOrCopyFile(utils.TableFileName(cf.dir, number), utils.TableFileName(target, number)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return db.writeDescriptor(dir)
+}
+
+// 复制尚未持久化的WAL，并在dir中写入描述当前状态的MANIFEST和CURRENT，调用方需持有db.mutex
+func (db *YLDB) writeDescriptor(dir string) error {
+	// WAL仍在追加写入，只能复制
+	numbers, err := db.liveLogNumbers()
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		if err := copyFile(utils.LogFileName(db.name, number), utils.LogFileName(dir, number)); err != nil {
+			return err
+		}
+	}
+
+	const manifestNumber = 1
+	if err := db.writeManifest(dir, manifestNumber); err != nil {
+		return err
+	}
+	return setCurrentFile(dir, manifestNumber)
+}
+
+// SST文件写入后不再修改，可以安全地共享同一份数据
+func linkOrCopyFile(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,79 @@
+package yldb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var (
+	checkpointDBPath = "./test_data/test_checkpoint_db"
+	checkpointPath   = "./test_data/test_checkpoint"
+)
+
+func TestCheckpoint(t *testing.T) {
+	_ = os.RemoveAll(checkpointDBPath)
+	_ = os.RemoveAll(checkpointPath)
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10}}
+	db, err := OpenWithOptions(checkpointDBPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cf, err := db.CreateColumnFamily("cf", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = db.Set(key, key, nil)
+	}
+	_ = db.SetCF(cf, []byte("cf-key"), []byte("cf-value"), nil)
+
+	if err := db.Checkpoint(checkpointPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Checkpoint(checkpointPath); err != errors.ErrCheckpointDirExists {
+		t.Fatalf("expected ErrCheckpointDirExists, got %v", err)
+	}
+	// checkpoint之后的写入不影响副本
+	_ = db.Set([]byte("key00000"), []byte("changed"), nil)
+	_ = db.Set([]byte("after"), []byte("checkpoint"), nil)
+
+	// SST文件通过硬链接共享
+	for _, number := range db.DefaultColumnFamily().current.LiveFiles() {
+		src, err1 := os.Stat(utils.TableFileName(checkpointDBPath, number))
+		dst, err2 := os.Stat(utils.TableFileName(checkpointPath, number))
+		if err1 == nil && err2 == nil && !os.SameFile(src, dst) {
+			t.Fatalf("table %d was copied instead of linked", number)
+		}
+	}
+
+	copyDB, err := Open(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copyDB.Close()
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if value, err := copyDB.Get(key, nil); err != nil || string(value) != string(key) {
+			t.Fatalf("Get(%s) = %q, %v", key, value, err)
+		}
+	}
+	if _, err := copyDB.Get([]byte("after"), nil); err != errors.ErrDBNotFound {
+		t.Fatalf("expected ErrDBNotFound, got %v", err)
+	}
+	copyCF := copyDB.GetColumnFamily("cf")
+	if copyCF == nil {
+		t.Fatal("column family missing in checkpoint")
+	}
+	if value, err := copyDB.GetCF(copyCF, []byte("cf-key"), nil); err != nil || string(value) != "cf-value" {
+		t.Fatalf("GetCF(cf-key) = %q, %v", value, err)
+	}
+	if value, err := db.Get([]byte("key00000"), nil); err != nil || string(value) != "changed" {
+		t.Fatalf("Get(key00000) = %q, %v", value, err)
+	}
+}
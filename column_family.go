@@ -0,0 +1,349 @@
+package yldb
+
+import (
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/ikey"
+	"github.com/Cauchy-NY/yldb/memdb"
+	"github.com/Cauchy-NY/yldb/utils"
+	"github.com/Cauchy-NY/yldb/version"
+)
+
+const defaultColumnFamilyID uint32 = 0
+
+// ColumnFamily 是数据库中一个独立的keyspace，拥有各自的MemTable、Level结构、配置和比较器
+// 所有列族共享同一个WAL和MANIFEST，因此一个Batch可以原子地写入多个列族
+// 默认列族的SST文件位于数据库目录下，其他列族的SST文件位于各自的子目录中
+type ColumnFamily struct {
+	id      uint32
+	name    string
+	dir     string
+	opts    *utils.ColumnFamilyOptions
+	cmp     utils.Comparator
+	mem     *memdb.MemTable
+	imm     *memdb.MemTable
+	current *version.Version
+	dropped bool
+	// 正在进行的后台Compaction，以及手动Compaction和导入占用的范围
+	compactions []*version.Compaction
+	// 正在进行的后台任务开始时的文件编号，任务写入的SST文件编号都不小于该值
+	pendingOutputs []uint64
+}
+
+func newColumnFamily(dbName string, id uint32, name string, opts *utils.ColumnFamilyOptions) *ColumnFamily {
+	dir := dbName
+	if id != defaultColumnFamilyID {
+		dir = utils.ColumnFamilyDirName(dbName, id)
+	}
+	cmp := opts.GetComparator()
+	return &ColumnFamily{
+		id:      id,
+		name:    name,
+		dir:     dir,
+		opts:    opts,
+		cmp:     cmp,
+		mem:     memdb.NewMemTable(cmp),
+		current: version.NewVersion(dir, opts),
+	}
+}
+
+// 记录一个后台任务开始写入SST文件，返回值需传给removePendingOutputs，调用方需持有db.mutex
+func (cf *ColumnFamily) addPendingOutputs() uint64 {
+	number := cf.current.NextFileNumber()
+	cf.pendingOutputs = append(cf.pendingOutputs, number)
+	return number
+}
+
+func (cf *ColumnFamily) removePendingOutputs(number uint64) {
+	for i, pending := range cf.pendingOutputs {
+		if pending == number {
+			cf.pendingOutputs = append(cf.pendingOutputs[:i], cf.pendingOutputs[i+1:]...)
+			return
+		}
+	}
+}
+
+// 返回正在进行的后台任务可能写入的最小文件编号，没有后台任务时返回math.MaxUint64
+func (cf *ColumnFamily) minPendingOutput() uint64 {
+	min := uint64(math.MaxUint64)
+	for _, number := range cf.pendingOutputs {
+		if number < min {
+			min = number
+		}
+	}
+	return min
+}
+
+func (cf *ColumnFamily) removeCompaction(compaction *version.Compaction) {
+	for i, running := range cf.compactions {
+		if running == compaction {
+			cf.compactions = append(cf.compactions[:i], cf.compactions[i+1:]...)
+			return
+		}
+	}
+}
+
+func (cf *ColumnFamily) ID() uint32 {
+	return cf.id
+}
+
+func (cf *ColumnFamily) Name() string {
+	return cf.name
+}
+
+// 查找user_key在序列号seq及之前的最新一条记录（包括删除记录），调用方需持有db.mutex
+func (cf *ColumnFamily) find(key []byte, seq uint64) (ikey.InternalKey, []byte, error) {
+	if cf.mem != nil { // 1.先查内存中的MemTable
+		if internalKey, value, err := cf.mem.Find(key, seq); err != errors.ErrMemTableNotFound {
+			return internalKey, value, err
+		}
+	}
+
+	if cf.imm != nil { // 2.再查内存中的ImmTable
+		if internalKey, value, err := cf.imm.Find(key, seq); err != errors.ErrMemTableNotFound {
+			return internalKey, value, err
+		}
+	}
+
+	if cf.current != nil { // 3.最后对磁盘上的数据按Level由新到旧依次查询
+		if internalKey, value, err := cf.current.Find(key, seq); err != errors.ErrVersionNotFound {
+			return internalKey, value, err
+		}
+	}
+
+	return nil, nil, errors.ErrDBNotFound
+}
+
+func (db *YLDB) DefaultColumnFamily() *ColumnFamily {
+	return db.defaultCF
+}
+
+// 按名称返回列族，不存在时返回nil
+func (db *YLDB) GetColumnFamily(name string) *ColumnFamily {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for _, cf := range db.cfs {
+		if cf.name == name {
+			return cf
+		}
+	}
+	return nil
+}
+
+// 返回所有列族，按ID排序
+func (db *YLDB) ColumnFamilies() []*ColumnFamily {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.columnFamilies()
+}
+
+// 调用方需持有db.mutex
+func (db *YLDB) columnFamilies() []*ColumnFamily {
+	cfs := make([]*ColumnFamily, 0, len(db.cfs))
+	for _, cf := range db.cfs {
+		cfs = append(cfs, cf)
+	}
+	sort.Slice(cfs, func(i, j int) bool {
+		return cfs[i].id < cfs[j].id
+	})
+	return cfs
+}
+
+func (db *YLDB) CreateColumnFamily(name string, opts *utils.ColumnFamilyOptions) (*ColumnFamily, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.readOnly {
+		return nil, errors.ErrDBReadOnly
+	}
+	for _, cf := range db.cfs {
+		if cf.name == name {
+			return nil, errors.ErrColumnFamilyExists
+		}
+	}
+
+	cf := newColumnFamily(db.name, db.nextCFID, name, opts)
+	cf.current.SetRateLimiter(db.opts.GetRateLimiter())
+	cf.current.SetTableCache(db.tableCache)
+	if err := os.MkdirAll(cf.dir, 0755); err != nil {
+		return nil, err
+	}
+	db.nextCFID++
+	db.cfs[cf.id] = cf
+	if err := db.saveManifest(); err != nil {
+		delete(db.cfs, cf.id)
+		return nil, err
+	}
+	db.maybeScheduleTTLCheck()
+	return cf, nil
+}
+
+// 删除列族及其所有数据，默认列族不能删除，删除后cf不可再使用
+func (db *YLDB) DropColumnFamily(cf *ColumnFamily) error {
+	if cf.id == defaultColumnFamilyID {
+		return errors.ErrDropDefaultColumnFamily
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.readOnly {
+		return errors.ErrDBReadOnly
+	}
+	if cf.dropped {
+		return errors.ErrColumnFamilyDropped
+	}
+	delete(db.cfs, cf.id)
+	if err := db.saveManifest(); err != nil {
+		db.cfs[cf.id] = cf
+		return err
+	}
+	cf.dropped = true
+
+	// 等待正在进行的后台任务结束、所有LiveFiles释放后再删除该列族的文件
+	for db.backgroundBusy() || db.deletionHolds > 0 {
+		db.cond.Wait()
+	}
+	db.tableCache.EvictDir(cf.dir)
+	return os.RemoveAll(cf.dir)
+}
+
+func (db *YLDB) GetCF(cf *ColumnFamily, key []byte, opts *utils.ReadOptions) ([]byte, error) {
+	// todo 增加LastSeq&VersionSet机制，实现MVCC，取消全局锁
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if cf.dropped {
+		return nil, errors.ErrColumnFamilyDropped
+	}
+	internalKey, value, err := cf.find(key, db.readSeq(opts))
+	if cf.current.NeedsSeekCompaction() && db.compactions < db.opts.GetMaxBackgroundCompactions() {
+		db.maybeScheduleCompaction()
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, live := ikey.LiveValue(internalKey.Kind(), value, time.Now().UnixNano())
+	if !live {
+		return nil, errors.ErrDBNotFound
+	}
+	return value, nil
+}
+
+func (db *YLDB) SetCF(cf *ColumnFamily, key, value []byte, opts *utils.WriteOptions) error {
+	var batch Batch
+	batch.SetCF(cf, key, value)
+	return db.Apply(batch, opts)
+}
+
+func (db *YLDB) DeleteCF(cf *ColumnFamily, key []byte, opts *utils.WriteOptions) error {
+	var batch Batch
+	batch.DeleteCF(cf, key)
+	return db.Apply(batch, opts)
+}
+
+// 任一列族的MemTable写满时返回true，调用方需持有db.mutex
+func (db *YLDB) memTableFull() bool {
+	for _, cf := range db.cfs {
+		if cf.mem.ApproximateMemoryUsage() > cf.opts.GetWriteBufferSize() {
+			return true
+		}
+	}
+	return false
+}
+
+// 调用方需持有db.mutex
+func (db *YLDB) hasImm() bool {
+	for _, cf := range db.cfs {
+		if cf.imm != nil {
+			return true
+		}
+	}
+	return false
+}
+
+//--------------------------------memTableInserter----------------------------------
+
+// 将Batch中的操作依次分配序列号并写入对应列族的MemTable
+type memTableInserter struct {
+	db *YLDB
+	// 下一个操作的序列号
+	seq uint64
+	// 回放WAL时忽略已被删除的列族
+	ignoreMissing bool
+}
+
+func (m *memTableInserter) Set(key, value []byte) error {
+	return m.add(defaultColumnFamilyID, ikey.InternalKeyKindSet, key, value)
+}
+
+func (m *memTableInserter) Delete(key []byte) error {
+	return m.add(defaultColumnFamilyID, ikey.InternalKeyKindDelete, key, nil)
+}
+
+func (m *memTableInserter) SetCF(cfID uint32, key, value []byte) error {
+	return m.add(cfID, ikey.InternalKeyKindSet, key, value)
+}
+
+func (m *memTableInserter) DeleteCF(cfID uint32, key []byte) error {
+	return m.add(cfID, ikey.InternalKeyKindDelete, key, nil)
+}
+
+func (m *memTableInserter) SetWithTTL(cfID uint32, key, value []byte, expiration int64) error {
+	return m.add(cfID, ikey.InternalKeyKindSetWithTTL, key, ikey.EncodeTTLValue(value, expiration))
+}
+
+func (m *memTableInserter) add(cfID uint32, kind ikey.InternalKeyKind, key, value []byte) error {
+	seqNum := m.seq
+	m.seq++
+	cf, exist := m.db.cfs[cfID]
+	if !exist {
+		if m.ignoreMissing {
+			return nil
+		}
+		return errors.ErrColumnFamilyNotFound
+	}
+	internalKey := ikey.MakeInternalKey(nil, key, kind, seqNum)
+	// 暂时不考虑MemTable在batch set操作中出现的error
+	_ = cf.mem.Set(internalKey, value)
+	return nil
+}
+
+//--------------------------------memTableInserter----------------------------------
+
+// 写入前检查Batch涉及的列族都存在，避免Batch只被写入一部分
+type columnFamilyChecker struct {
+	db *YLDB
+}
+
+func (c columnFamilyChecker) Set(key, value []byte) error {
+	return nil
+}
+
+func (c columnFamilyChecker) Delete(key []byte) error {
+	return nil
+}
+
+func (c columnFamilyChecker) SetCF(cfID uint32, key, value []byte) error {
+	return c.check(cfID)
+}
+
+func (c columnFamilyChecker) DeleteCF(cfID uint32, key []byte) error {
+	return c.check(cfID)
+}
+
+func (c columnFamilyChecker) SetWithTTL(cfID uint32, key, value []byte, expiration int64) error {
+	return c.check(cfID)
+}
+
+func (c columnFamilyChecker) check(cfID uint32) error {
+	if _, exist := c.db.cfs[cfID]; !exist {
+		return errors.ErrColumnFamilyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package yldb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var cfPath = "./test_data/test_column_family"
+
+// 按字节逆序比较的比较器
+type reverseComparator struct{}
+
+func (reverseComparator) Compare(a, b []byte) int {
+	return bytes.Compare(b, a)
+}
+
+func (reverseComparator) Name() string {
+	return "yldb.test.ReverseComparator"
+}
+
+func TestColumnFamily(t *testing.T) {
+	_ = os.RemoveAll(cfPath)
+	db, err := Open(cfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfOpts := &utils.ColumnFamilyOptions{Comparator: reverseComparator{}}
+	cf, err := db.CreateColumnFamily("reverse", cfOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateColumnFamily("reverse", nil); err != errors.ErrColumnFamilyExists {
+		t.Fatalf("expected ErrColumnFamilyExists, got %v", err)
+	}
+
+	// 一个Batch原子地写入两个列族
+	var batch Batch
+	batch.Set([]byte("a"), []byte("default-a"))
+	batch.SetCF(cf, []byte("a"), []byte("cf-a"))
+	batch.SetCF(cf, []byte("b"), []byte("cf-b"))
+	if err := db.Apply(batch, nil); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Set([]byte("b"), []byte("default-b"), nil)
+	_ = db.DeleteCF(cf, []byte("b"), nil)
+
+	if value, err := db.Get([]byte("a"), nil); err != nil || string(value) != "default-a" {
+		t.Fatalf("Get(a) = %q, %v", value, err)
+	}
+	if value, err := db.GetCF(cf, []byte("a"), nil); err != nil || string(value) != "cf-a" {
+		t.Fatalf("GetCF(a) = %q, %v", value, err)
+	}
+	if _, err := db.GetCF(cf, []byte("b"), nil); err != errors.ErrDBNotFound {
+		t.Fatalf("expected ErrDBNotFound, got %v", err)
+	}
+
+	_ = db.SetCF(cf, []byte("c"), []byte("cf-c"), nil)
+	if got := strings.Join(collect(db.NewIteratorCF(cf, nil), false), ","); got != "c=cf-c,a=cf-a" {
+		t.Fatalf("iterate cf: %s", got)
+	}
+	db.Close()
+
+	// 重新打开后从WAL中恢复两个列族的数据
+	if _, err := OpenWithOptions(cfPath, nil); err != errors.ErrComparatorMismatch {
+		t.Fatalf("expected ErrComparatorMismatch, got %v", err)
+	}
+	opts := &utils.Options{ColumnFamilies: map[string]*utils.ColumnFamilyOptions{"reverse": cfOpts}}
+	db, err = OpenWithOptions(cfPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf = db.GetColumnFamily("reverse")
+	if cf == nil {
+		t.Fatal("column family not recovered")
+	}
+	if value, err := db.Get([]byte("b"), nil); err != nil || string(value) != "default-b" {
+		t.Fatalf("Get(b) = %q, %v", value, err)
+	}
+	if got := strings.Join(collect(db.NewIteratorCF(cf, nil), false), ","); got != "c=cf-c,a=cf-a" {
+		t.Fatalf("iterate recovered cf: %s", got)
+	}
+
+	if err := db.DropColumnFamily(db.DefaultColumnFamily()); err != errors.ErrDropDefaultColumnFamily {
+		t.Fatalf("expected ErrDropDefaultColumnFamily, got %v", err)
+	}
+	if err := db.DropColumnFamily(cf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetCF(cf, []byte("a"), nil); err != errors.ErrColumnFamilyDropped {
+		t.Fatalf("expected ErrColumnFamilyDropped, got %v", err)
+	}
+	if err := db.SetCF(cf, []byte("a"), []byte("x"), nil); err != errors.ErrColumnFamilyNotFound {
+		t.Fatalf("expected ErrColumnFamilyNotFound, got %v", err)
+	}
+	db.Close()
+
+	db, err = Open(cfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.GetColumnFamily("reverse") != nil {
+		t.Fatal("dropped column family recovered")
+	}
+	if value, err := db.Get([]byte("a"), nil); err != nil || string(value) != "default-a" {
+		t.Fatalf("Get(a) = %q, %v", value, err)
+	}
+}
+
+func TestColumnFamilyFlush(t *testing.T) {
+	_ = os.RemoveAll(cfPath)
+	opts := &utils.Options{
+		ColumnFamilyOptions: utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10},
+		ColumnFamilies:      map[string]*utils.ColumnFamilyOptions{"small": {WriteBufferSize: 4 << 10}},
+	}
+	db, err := OpenWithOptions(cfPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := db.CreateColumnFamily("small", opts.ColumnFamilies["small"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		var batch Batch
+		batch.Set(key, key)
+		batch.SetCF(cf, key, append([]byte("cf-"), key...))
+		if err := db.Apply(batch, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	db, err = OpenWithOptions(cfPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cf = db.GetColumnFamily("small")
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if value, err := db.Get(key, nil); err != nil || !bytes.Equal(value, key) {
+			t.Fatalf("Get(%s) = %q, %v", key, value, err)
+		}
+		if value, err := db.GetCF(cf, key, nil); err != nil || string(value) != "cf-"+string(key) {
+			t.Fatalf("GetCF(%s) = %q, %v", key, value, err)
+		}
+	}
+}
+
+// 返回目录中SST文件的数量
+func numTables(dir string) int {
+	infos, _ := ioutil.ReadDir(dir)
+	n := 0
+	for _, info := range infos {
+		if fileType, _, ok := utils.ParseFileName(info.Name()); ok && fileType == utils.TableFile {
+			n++
+		}
+	}
+	return n
+}
+
+// 自定义比较器的列族flush之后，SST文件的查找和遍历也按该比较器的顺序进行
+func TestColumnFamilyComparatorFlush(t *testing.T) {
+	_ = os.RemoveAll(cfPath)
+	cfOpts := &utils.ColumnFamilyOptions{Comparator: reverseComparator{}, WriteBufferSize: 4 << 10}
+	opts := &utils.Options{ColumnFamilies: map[string]*utils.ColumnFamilyOptions{"reverse": cfOpts}}
+	db, err := OpenWithOptions(cfPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := db.CreateColumnFamily("reverse", cfOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if err := db.SetCF(cf, key, key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	db, err = OpenWithOptions(cfPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cf = db.GetColumnFamily("reverse")
+	if numTables(cf.dir) == 0 {
+		t.Fatal("expected the column family to be flushed")
+	}
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if value, err := db.GetCF(cf, key, nil); err != nil || !bytes.Equal(value, key) {
+			t.Fatalf("GetCF(%s) = %q, %v", key, value, err)
+		}
+	}
+	got := collect(db.NewIteratorCF(cf, nil), false)
+	if len(got) != 2000 || got[0] != "key01999=key01999" || got[1999] != "key00000=key00000" {
+		t.Fatalf("iterated %d keys, first %v", len(got), got[:1])
+	}
+}
+
+// flush失败时ImmTable中的数据仍可读取，且重试成功之前不会删除WAL
+func TestFlushFailure(t *testing.T) {
+	_ = os.RemoveAll(cfPath)
+	opts := &utils.Options{
+		ColumnFamilyOptions: utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10},
+		ColumnFamilies:      map[string]*utils.ColumnFamilyOptions{"broken": {WriteBufferSize: 4 << 10}},
+	}
+	db, err := OpenWithOptions(cfPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := db.CreateColumnFamily("broken", opts.ColumnFamilies["broken"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(i int) {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		var batch Batch
+		batch.Set(key, key)
+		batch.SetCF(cf, key, append([]byte("cf-"), key...))
+		if err := db.Apply(batch, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(db *YLDB, cf *ColumnFamily, n int) {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%05d", i))
+			if value, err := db.Get(key, nil); err != nil || !bytes.Equal(value, key) {
+				t.Fatalf("Get(%s) = %q, %v", key, value, err)
+			}
+			if value, err := db.GetCF(cf, key, nil); err != nil || string(value) != "cf-"+string(key) {
+				t.Fatalf("GetCF(%s) = %q, %v", key, value, err)
+			}
+		}
+	}
+
+	// 列族目录被替换为普通文件，该列族的flush失败
+	dir := cf.dir
+	_ = os.RemoveAll(dir)
+	if err := ioutil.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 写满MemTable触发一次flush
+	n := 0
+	for {
+		write(n)
+		n++
+		db.mutex.Lock()
+		rotated := cf.imm != nil
+		db.mutex.Unlock()
+		if rotated {
+			break
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	db.mutex.Lock()
+	if cf.imm == nil {
+		t.Fatal("expected the failed ImmTable to be kept")
+	}
+	// 后台错误返回给需要等待flush的调用
+	if err := db.flush(); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	db.mutex.Unlock()
+	for i := 0; i < 10; i++ {
+		write(n)
+		n++
+	}
+	check(db, cf, n)
+
+	// 恢复之后重试的flush成功
+	_ = os.Remove(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	db.mutex.Lock()
+	for cf.imm != nil {
+		db.cond.Wait()
+	}
+	err = db.flush()
+	if err == nil && db.flushError != nil {
+		err = db.flushError
+	}
+	db.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numTables(cf.dir) == 0 {
+		t.Fatal("expected the column family to be flushed")
+	}
+	check(db, cf, n)
+	db.Close()
+
+	db, err = OpenWithOptions(cfPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db, db.GetColumnFamily("broken"), n)
+}
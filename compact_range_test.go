@@ -0,0 +1,321 @@
+package yldb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/config"
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var compactRangePath = "./test_data/test_compact_range"
+
+func openCompactRangeDB(t *testing.T) *YLDB {
+	_ = os.RemoveAll(compactRangePath)
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10}}
+	db, err := OpenWithOptions(compactRangePath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func numTableFiles(cf *ColumnFamily) int {
+	n := 0
+	for level := 0; level < config.NumLevels; level++ {
+		n += cf.current.NumLevelFiles(level)
+	}
+	return n
+}
+
+// 目录中的SST文件与Version引用的文件一致
+func checkNoObsoleteTables(t *testing.T, cf *ColumnFamily) {
+	infos, _ := ioutil.ReadDir(cf.dir)
+	n := 0
+	for _, info := range infos {
+		if fileType, _, ok := utils.ParseFileName(info.Name()); ok && fileType == utils.TableFile {
+			n++
+		}
+	}
+	if n != numTableFiles(cf) {
+		t.Fatalf("%d table files on disk, %d in version", n, numTableFiles(cf))
+	}
+}
+
+func TestCompactRange(t *testing.T) {
+	db := openCompactRangeDB(t)
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = db.Set(key, key, nil)
+	}
+	for i := 0; i < 1000; i++ {
+		_ = db.Delete([]byte(fmt.Sprintf("key%05d", i)), nil)
+	}
+	_ = db.Set([]byte("key01000"), []byte("new"), nil)
+
+	if err := db.CompactRange(nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.defaultCF.current.NumLevelFiles(0); n != 0 {
+		t.Fatalf("expected empty level0, got %d files", n)
+	}
+	checkNoObsoleteTables(t, db.defaultCF)
+
+	check := func() {
+		for i := 0; i < 2000; i++ {
+			key := []byte(fmt.Sprintf("key%05d", i))
+			value, err := db.Get(key, nil)
+			switch {
+			case i < 1000:
+				if err != errors.ErrDBNotFound {
+					t.Fatalf("Get(%s) = (%q, %v), want ErrDBNotFound", key, value, err)
+				}
+			case i == 1000:
+				if err != nil || string(value) != "new" {
+					t.Fatalf("Get(%s) = (%q, %v), want new", key, value, err)
+				}
+			default:
+				if err != nil || string(value) != string(key) {
+					t.Fatalf("Get(%s) = (%q, %v)", key, value, err)
+				}
+			}
+		}
+		if got := collect(db.NewIterator(nil), false); len(got) != 1000 || got[0] != "key01000=new" {
+			t.Fatalf("iterated %d keys, first %v", len(got), got[:1])
+		}
+	}
+	check()
+
+	db.Close()
+	db, err := Open(compactRangePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check()
+}
+
+// 启用后比较到blockKey时阻塞，直到release被关闭，用于让手动Compaction停在合并过程中
+type blockingComparator struct {
+	armed   int32
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+var blockKey = []byte("block")
+
+func (c *blockingComparator) Compare(a, b []byte) int {
+	if atomic.LoadInt32(&c.armed) == 1 && (bytes.Equal(a, blockKey) || bytes.Equal(b, blockKey)) {
+		c.once.Do(func() { close(c.entered) })
+		<-c.release
+	}
+	return bytes.Compare(a, b)
+}
+
+func (c *blockingComparator) Name() string {
+	return "yldb.test.BlockingComparator"
+}
+
+// 手动Compaction期间写满的MemTable可以flush，写入不会等待手动Compaction结束
+func TestCompactRangeWithFlush(t *testing.T) {
+	db := openCompactRangeDB(t)
+	cmp := &blockingComparator{entered: make(chan struct{}), release: make(chan struct{})}
+	cf, err := db.CreateColumnFamily("blocking", &utils.ColumnFamilyOptions{Comparator: cmp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 两个L0文件中都有blockKey，它不是文件的边界，只有合并时才会被比较
+	for _, value := range []string{"v1", "v2"} {
+		for _, key := range []string{"a", "block", "z"} {
+			_ = db.SetCF(cf, []byte(key), []byte(value), nil)
+		}
+		db.mutex.Lock()
+		err = db.flush()
+		db.mutex.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	atomic.StoreInt32(&cmp.armed, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- db.CompactRangeCF(cf, nil, nil, nil)
+	}()
+	select {
+	case <-cmp.entered:
+	case err := <-done:
+		t.Fatalf("manual compaction finished without merging: %v", err)
+	}
+
+	// 写满多个MemTable，每次切换都需要等待上一个ImmTable flush完成
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i := 0; i < 2000; i++ {
+			key := []byte(fmt.Sprintf("key%05d", i))
+			_ = db.Set(key, key, nil)
+		}
+	}()
+	select {
+	case <-written:
+	case <-time.After(10 * time.Second):
+		t.Fatal("writes blocked by the manual compaction")
+	}
+	db.mutex.Lock()
+	files := numTableFiles(db.defaultCF)
+	db.mutex.Unlock()
+	if files == 0 {
+		t.Fatal("expected memtables to be flushed during the manual compaction")
+	}
+
+	close(cmp.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "block", "z"} {
+		if value, err := db.GetCF(cf, []byte(key), nil); err != nil || string(value) != "v2" {
+			t.Fatalf("GetCF(%s) = (%q, %v)", key, value, err)
+		}
+	}
+	if n := cf.current.NumLevelFiles(0); n != 0 {
+		t.Fatalf("expected empty level0, got %d files", n)
+	}
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if value, err := db.Get(key, nil); err != nil || string(value) != string(key) {
+			t.Fatalf("Get(%s) = (%q, %v)", key, value, err)
+		}
+	}
+	db.Close()
+	checkNoObsoleteTables(t, db.defaultCF)
+	checkNoObsoleteTables(t, cf)
+}
+
+func TestCompactRangeSnapshot(t *testing.T) {
+	db := openCompactRangeDB(t)
+	defer db.Close()
+
+	_ = db.Set([]byte("a"), []byte("v1"), nil)
+	_ = db.Set([]byte("b"), []byte("v1"), nil)
+	snapshot := db.GetSnapshot()
+	_ = db.Set([]byte("a"), []byte("v2"), nil)
+	_ = db.Delete([]byte("b"), nil)
+
+	opts := &utils.CompactRangeOptions{ForceBottommost: true}
+	if err := db.CompactRange(nil, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	// 被快照引用的旧数据不会被清除
+	read := &utils.ReadOptions{Snapshot: snapshot}
+	if value, err := db.Get([]byte("a"), read); err != nil || string(value) != "v1" {
+		t.Fatalf("Get(a) at snapshot = (%q, %v)", value, err)
+	}
+	if value, err := db.Get([]byte("b"), read); err != nil || string(value) != "v1" {
+		t.Fatalf("Get(b) at snapshot = (%q, %v)", value, err)
+	}
+	if value, err := db.Get([]byte("a"), nil); err != nil || string(value) != "v2" {
+		t.Fatalf("Get(a) = (%q, %v)", value, err)
+	}
+	if _, err := db.Get([]byte("b"), nil); err != errors.ErrDBNotFound {
+		t.Fatalf("expected ErrDBNotFound, got %v", err)
+	}
+
+	// 快照释放后旧数据和删除记录都被清除
+	db.ReleaseSnapshot(snapshot)
+	if err := db.CompactRange(nil, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(collect(db.NewIterator(nil), false), ","); got != "a=v2" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestCompactRangeBottommost(t *testing.T) {
+	db := openCompactRangeDB(t)
+	defer db.Close()
+	_ = os.MkdirAll(ingestExtPath, 0755)
+
+	// 导入到最底层的文件中只有删除记录
+	path := filepath.Join(ingestExtPath, "deletes.sst")
+	w, _ := NewSSTWriter(path, nil)
+	for i := 0; i < 100; i++ {
+		_ = w.Delete([]byte(fmt.Sprintf("key%05d", i)))
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.IngestExternalFiles([]string{path}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CompactRange(nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := numTableFiles(db.defaultCF); n != 1 {
+		t.Fatalf("expected the bottommost file to be kept, got %d files", n)
+	}
+	// 范围之外的文件不会被重写
+	if err := db.CompactRange([]byte("x"), nil, &utils.CompactRangeOptions{ForceBottommost: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := numTableFiles(db.defaultCF); n != 1 {
+		t.Fatalf("expected 1 file, got %d", n)
+	}
+	if err := db.CompactRange([]byte("key00050"), []byte("key00050"), &utils.CompactRangeOptions{ForceBottommost: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := numTableFiles(db.defaultCF); n != 0 {
+		t.Fatalf("expected all tombstones to be dropped, got %d files", n)
+	}
+	checkNoObsoleteTables(t, db.defaultCF)
+}
+
+func TestCompactRangeColumnFamily(t *testing.T) {
+	db := openCompactRangeDB(t)
+	defer db.Close()
+	cf, err := db.CreateColumnFamily("reverse", &utils.ColumnFamilyOptions{
+		Comparator:      reverseComparator{},
+		WriteBufferSize: 4 << 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = db.SetCF(cf, key, key, nil)
+	}
+	for i := 0; i < 1000; i += 2 {
+		_ = db.DeleteCF(cf, []byte(fmt.Sprintf("key%05d", i)), nil)
+	}
+
+	// 反序比较器下start大于end
+	if err := db.CompactRangeCF(cf, []byte("key00999"), []byte("key00000"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := cf.current.NumLevelFiles(0); n != 0 {
+		t.Fatalf("expected empty level0, got %d files", n)
+	}
+	checkNoObsoleteTables(t, cf)
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		value, err := db.GetCF(cf, key, nil)
+		if i%2 == 0 && err != errors.ErrDBNotFound || i%2 == 1 && (err != nil || string(value) != string(key)) {
+			t.Fatalf("GetCF(%s) = (%q, %v)", key, value, err)
+		}
+	}
+	got := collect(db.NewIteratorCF(cf, nil), false)
+	if len(got) != 500 || got[0] != "key00999=key00999" || got[499] != "key00001=key00001" {
+		t.Fatalf("iterated %d keys: %v ... %v", len(got), got[0], got[len(got)-1])
+	}
+}
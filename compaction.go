@@ -6,66 +6,357 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 
+	"github.com/Cauchy-NY/yldb/config"
 	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/memdb"
 	"github.com/Cauchy-NY/yldb/utils"
+	"github.com/Cauchy-NY/yldb/version"
 )
 
+// 调度后台任务，调用方需持有db.mutex
+// flush由单独的goroutine执行且不占用major compaction的并发数，因此总是优先执行；
+// 每个任务结束后都会重新调度，直到所有Level的score都不超过1.0；失败的任务在等待一段时间之后才会再次调度
 func (db *YLDB) maybeScheduleCompaction() {
-	if db.compacting || db.closed {
+	if db.closed || db.readOnly {
 		return
 	}
-	if db.imm == nil {
+	if !db.flushing && db.hasImm() && !time.Now().Before(db.nextFlushRetry) {
+		db.flushing = true
+		go db.backgroundFlush()
+	}
+	if time.Now().Before(db.nextCompactionRetry) {
 		return
 	}
-	db.compacting = true
-	go db.compact()
+	for db.compactions < db.opts.GetMaxBackgroundCompactions() {
+		cf, compaction := db.pickCompaction()
+		if compaction == nil {
+			return
+		}
+		db.compactions++
+		go db.backgroundCompaction(cf, compaction)
+	}
+}
+
+// 有正在进行的后台任务、导入或手动Compaction时返回true，调用方需持有db.mutex
+func (db *YLDB) backgroundBusy() bool {
+	return db.flushing || db.compactions > 0 || db.manualJobs > 0
 }
 
-func (db *YLDB) compact() {
+// 依次在各列族中选取一个与正在进行的Compaction不冲突的Compaction，调用方需持有db.mutex
+func (db *YLDB) pickCompaction() (*ColumnFamily, *version.Compaction) {
+	smallestSnapshot, largestSnapshot := db.smallestSnapshot(), db.largestSnapshot()
+	for _, cf := range db.columnFamilies() {
+		if compaction := cf.current.PickCompaction(cf.compactions, smallestSnapshot, largestSnapshot); compaction != nil {
+			cf.compactions = append(cf.compactions, compaction)
+			return cf, compaction
+		}
+	}
+	return nil, nil
+}
+
+// 在后台执行一次major compaction，合并时不持有db.mutex，完成后将结果应用到列族最新的Version上
+func (db *YLDB) backgroundCompaction(cf *ColumnFamily, compaction *version.Compaction) {
 	db.mutex.Lock()
-	defer db.mutex.Unlock()
+	pending := cf.addPendingOutputs()
+	db.mutex.Unlock()
 
-	db.backgroundCompact()
+	err := compaction.Run(db.opts.GetMaxSubcompactions())
 
-	db.compacting = false
-	// 之前一次的compaction可能在某一层产生过多的文件，所以可能还需要一次compaction（暂时不这么做）
-	// db.maybeScheduleCompaction()
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if err != nil {
+		log.Printf("Error: %v, Caused by: %v", errors.ErrMajorCompactionError, err)
+	} else if !cf.dropped {
+		old := cf.current
+		cf.current = old.Apply(compaction)
+		if err = db.saveManifest(); err != nil {
+			log.Printf("Error: %v, Caused by: %v", errors.ErrMajorCompactionError, err)
+			cf.current = old
+		}
+	}
+	compaction.Release()
+	cf.removeCompaction(compaction)
+	cf.removePendingOutputs(pending)
+	if !cf.dropped {
+		db.removeObsoleteTables(cf)
+	}
+	db.setCompactionError(err)
+	db.compactions--
+	db.maybeScheduleCompaction()
 	db.cond.Broadcast()
 }
 
-// compaction主要逻辑
-func (db *YLDB) backgroundCompact() {
-	imm := db.imm
-	version := db.current.Copy()
+type flushJob struct {
+	cf      *ColumnFamily
+	imm     *memdb.MemTable
+	version *version.Version
+	meta    *version.FileMetaData
+	pending uint64
+}
+
+// 在后台将所有列族的ImmTable写入SST文件，写入时不持有db.mutex
+func (db *YLDB) backgroundFlush() {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var jobs []*flushJob
+	for _, cf := range db.columnFamilies() {
+		if cf.imm != nil {
+			jobs = append(jobs, &flushJob{cf: cf, imm: cf.imm, version: cf.current, pending: cf.addPendingOutputs()})
+		}
+	}
+	// 所有ImmTable都来自正在写入的WAL之前的日志，flush完成后这些日志都不再需要
+	logNumber := db.logFileNumber
 	db.mutex.Unlock()
 
-	// minor compaction
-	if imm != nil {
-		err := version.WriteLevel0Table(imm)
+	var flushErr error
+	for _, job := range jobs {
+		meta, err := job.version.BuildLevel0Table(job.imm)
+		if err != nil {
+			log.Printf("Error: %v, Caused by: %v", errors.ErrMinorCompactionError, err)
+			flushErr = err
+			continue
+		}
+		job.meta = meta
+	}
+
+	db.mutex.Lock()
+	for _, job := range jobs {
+		job.cf.removePendingOutputs(job.pending)
+		if job.meta == nil && !job.cf.dropped {
+			// 写入失败的ImmTable保留在内存中，对应的WAL也不会删除，稍后重试
+			continue
+		}
+		job.cf.imm = nil
+		if job.meta != nil && !job.cf.dropped {
+			// Compaction可能在flush期间修改了Version，需要加入最新的Version
+			current := job.cf.current.Copy()
+			current.AddLevel0Table(job.meta, job.cf.compactions)
+			job.cf.current = current
+		}
+	}
+	if flushErr == nil {
+		db.logNumber = logNumber
+	}
+	if err := db.saveManifest(); err != nil {
 		log.Printf("Error: %v, Caused by: %v", errors.ErrMinorCompactionError, err)
+		flushErr = err
+	} else {
+		for _, job := range jobs {
+			if !job.cf.dropped {
+				db.removeObsoleteTables(job.cf)
+			}
+		}
+		db.deleteObsoleteLogs()
+	}
+	db.setFlushError(flushErr)
+	db.flushing = false
+	db.maybeScheduleCompaction()
+	db.cond.Broadcast()
+}
+
+// 记录后台flush的结果，失败时一段时间内不再调度flush，成功时清除之前的错误，调用方需持有db.mutex
+func (db *YLDB) setFlushError(err error) {
+	db.flushError = err
+	if err != nil {
+		db.nextFlushRetry = db.scheduleRetry()
+	}
+}
+
+// 记录后台major compaction的结果，失败时一段时间内不再调度major compaction，成功时清除之前的错误，调用方需持有db.mutex
+func (db *YLDB) setCompactionError(err error) {
+	db.compactionError = err
+	if err != nil {
+		db.nextCompactionRetry = db.scheduleRetry()
+	}
+}
+
+// 返回失败的后台任务可以重试的时间，并在该时间重新调度后台任务，避免持续失败时（如磁盘已满）反复重试
+func (db *YLDB) scheduleRetry() time.Time {
+	retry := time.Now().Add(config.BackgroundErrorRetryInterval)
+	time.AfterFunc(config.BackgroundErrorRetryInterval, func() {
+		db.mutex.Lock()
+		defer db.mutex.Unlock()
+		db.maybeScheduleCompaction()
+	})
+	return retry
+}
+
+// 存在设置了TTL的FIFO列族时定期调度后台任务，使没有写入时过期的文件也能被删除，调用方需持有db.mutex
+func (db *YLDB) maybeScheduleTTLCheck() {
+	if db.ttlTimer != nil || db.closed || db.readOnly {
+		return
+	}
+	for _, cf := range db.cfs {
+		if cf.opts.GetCompactionStyle() == utils.CompactionStyleFIFO && cf.opts.GetFIFOCompactionOptions().GetTTL() > 0 {
+			db.ttlTimer = time.AfterFunc(config.FIFOTTLCheckInterval, func() {
+				db.mutex.Lock()
+				defer db.mutex.Unlock()
+				db.ttlTimer = nil
+				db.maybeScheduleCompaction()
+				db.maybeScheduleTTLCheck()
+			})
+			return
+		}
 	}
+}
 
-	// major compaction
-	for version.DoCompactionWork() {
-		version.Log()
+// 返回最近一次失败的后台任务的错误，调用方需持有db.mutex
+func (db *YLDB) backgroundError() error {
+	if db.flushError != nil {
+		return db.flushError
 	}
+	return db.compactionError
+}
+
+// 手动合并默认列族中与[start, end]重叠的数据，start/end为nil表示不限
+func (db *YLDB) CompactRange(start, end []byte, opts *utils.CompactRangeOptions) error {
+	return db.CompactRangeCF(db.defaultCF, start, end, opts)
+}
 
-	descriptorNumber, _ := version.Save()
-	db.SetCurrentFile(descriptorNumber)
+// 先flush所有MemTable，再将列族中与[start, end]重叠的数据逐层合并到含有该范围数据的最底层
+// 合并时清除被覆盖的旧数据和已经没有意义的删除记录，被快照引用的数据会保留
+// 每一层的合并与后台Compaction一样不持有db.mutex，期间flush和不冲突的后台Compaction可以继续进行
+func (db *YLDB) CompactRangeCF(cf *ColumnFamily, start, end []byte, opts *utils.CompactRangeOptions) error {
 	db.mutex.Lock()
-	db.imm = nil
-	db.current = version
+	defer db.mutex.Unlock()
+
+	if cf.dropped {
+		return errors.ErrColumnFamilyDropped
+	}
+	if err := db.flush(); err != nil {
+		return err
+	}
+	if cf.opts.GetCompactionStyle() == utils.CompactionStyleFIFO {
+		// FIFO Compaction从不合并数据
+		return nil
+	}
+	db.manualJobs++
+	defer func() {
+		db.manualJobs--
+		db.cond.Broadcast()
+	}()
+
+	maxLevel := cf.current.CompactRangeMaxLevel(start, end)
+	for level := 0; level < maxLevel; level++ {
+		if err := db.runManualCompaction(cf, level, level+1, start, end); err != nil {
+			return err
+		}
+	}
+	if opts.GetForceBottommost() {
+		return db.runManualCompaction(cf, maxLevel, maxLevel, start, end)
+	}
+	return nil
+}
+
+// 将level中与[start, end]重叠的文件合并到outputLevel，与正在进行的Compaction冲突时等待其结束，调用方需持有db.mutex
+func (db *YLDB) runManualCompaction(cf *ColumnFamily, level, outputLevel int, start, end []byte) error {
+	var compaction *version.Compaction
+	for {
+		if db.closed {
+			return errors.ErrDBClosed
+		}
+		if cf.dropped {
+			return errors.ErrColumnFamilyDropped
+		}
+		var busy bool
+		compaction, busy = cf.current.PickManualCompaction(level, outputLevel, start, end, cf.compactions,
+			db.smallestSnapshot(), db.largestSnapshot())
+		if !busy {
+			break
+		}
+		db.cond.Wait()
+	}
+	if compaction == nil {
+		return nil
+	}
+	cf.compactions = append(cf.compactions, compaction)
+	pending := cf.addPendingOutputs()
+	db.mutex.Unlock()
+
+	err := compaction.Run(db.opts.GetMaxSubcompactions())
+
+	db.mutex.Lock()
+	if err == nil && !cf.dropped {
+		old := cf.current
+		cf.current = old.Apply(compaction)
+		if err = db.saveManifest(); err != nil {
+			cf.current = old
+		}
+	}
+	compaction.Release()
+	cf.removeCompaction(compaction)
+	cf.removePendingOutputs(pending)
+	if !cf.dropped {
+		db.removeObsoleteTables(cf)
+	}
+	db.maybeScheduleCompaction()
+	db.cond.Broadcast()
+	return err
+}
+
+// 返回最旧的快照的序列号，没有快照时返回最新的序列号，调用方需持有db.mutex
+func (db *YLDB) smallestSnapshot() uint64 {
+	seq := db.seq
+	for _, snapshot := range db.snapshots {
+		if snapshot.Seq() < seq {
+			seq = snapshot.Seq()
+		}
+	}
+	return seq
+}
+
+// 返回最新的快照的序列号，没有快照时返回0，调用方需持有db.mutex
+func (db *YLDB) largestSnapshot() uint64 {
+	var seq uint64
+	for _, snapshot := range db.snapshots {
+		if snapshot.Seq() > seq {
+			seq = snapshot.Seq()
+		}
+	}
+	return seq
+}
+
+// 删除列族目录中不再被当前Version引用的SST文件，主库和从库共用，调用方需持有db.mutex
+// 编号不小于minPendingOutput的文件可能正由后台任务写入，不会被删除；存在未释放的LiveFiles时不删除任何文件
+func (db *YLDB) removeObsoleteTables(cf *ColumnFamily) {
+	if cf.dropped || db.deletionHolds > 0 {
+		return
+	}
+	minPending := cf.minPendingOutput()
+	live := make(map[uint64]bool)
+	for _, number := range cf.current.LiveFiles() {
+		live[number] = true
+	}
+	infos, err := ioutil.ReadDir(cf.dir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		fileType, number, ok := utils.ParseFileName(info.Name())
+		if ok && fileType == utils.TableFile && !live[number] && number < minPending {
+			cf.current.EvictTable(number)
+			_ = os.Remove(utils.TableFileName(cf.dir, number))
+		}
+	}
 }
 
 func (db *YLDB) SetCurrentFile(number uint64) {
-	tmp := utils.TempFileName(db.name, number)
-	_ = ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d", number)), 0600)
-	_ = os.Rename(tmp, utils.CurrentFileName(db.name))
+	_ = setCurrentFile(db.name, number)
+}
+
+func setCurrentFile(dir string, number uint64) error {
+	tmp := utils.TempFileName(dir, number)
+	if err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d", number)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, utils.CurrentFileName(dir))
 }
 
 func (db *YLDB) ReadCurrentFile() uint64 {
-	b, err := ioutil.ReadFile(utils.CurrentFileName(db.name))
+	b, err := ioutil.ReadFile(utils.CurrentFileName(db.primaryDir()))
 	if err != nil {
 		return 0
 	}
@@ -0,0 +1,113 @@
+package yldb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var compactionFilterPath = "./test_data/test_compaction_filter"
+
+// value的格式为"过期时间:数据"，过期的记录被删除，以"upper"开头的key的数据转为大写
+type expirationFilter struct {
+	mutex    sync.Mutex
+	now      int64
+	contexts []utils.CompactionFilterContext
+}
+
+func (f *expirationFilter) Filter(ctx *utils.CompactionFilterContext, key, existingValue []byte) (utils.CompactionFilterDecision, []byte) {
+	f.mutex.Lock()
+	f.contexts = append(f.contexts, *ctx)
+	f.mutex.Unlock()
+	i := bytes.IndexByte(existingValue, ':')
+	expiration, err := strconv.ParseInt(string(existingValue[:i]), 10, 64)
+	if err == nil && expiration < f.now {
+		return utils.CompactionFilterRemove, nil
+	}
+	if bytes.HasPrefix(key, []byte("upper")) {
+		return utils.CompactionFilterChangeValue, bytes.ToUpper(existingValue)
+	}
+	return utils.CompactionFilterKeep, nil
+}
+
+func (f *expirationFilter) Name() string {
+	return "yldb.test.ExpirationFilter"
+}
+
+func TestCompactionFilter(t *testing.T) {
+	_ = os.RemoveAll(compactionFilterPath)
+	now := time.Now().Unix()
+	filter := &expirationFilter{now: now}
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{
+		WriteBufferSize:  4 << 10,
+		CompactionFilter: filter,
+	}}
+	db, err := OpenWithOptions(compactionFilterPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 快照能读到的记录不会被过滤
+	expired := []byte(fmt.Sprintf("%d:snapshot", now-3600))
+	_ = db.Set([]byte("snapshot"), expired, nil)
+	snapshot := db.GetSnapshot()
+
+	const n = 1000
+	for i := 0; i < n; i++ {
+		expiration := now + 3600
+		if i%2 == 0 {
+			expiration = now - 3600
+		}
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = db.Set(key, []byte(fmt.Sprintf("%d:%s", expiration, key)), nil)
+	}
+	_ = db.Set([]byte("upper"), []byte(fmt.Sprintf("%d:value", now+3600)), nil)
+
+	if err := db.CompactRange(nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_, err := db.Get(key, nil)
+		if i%2 == 0 && err != errors.ErrDBNotFound || i%2 == 1 && err != nil {
+			t.Fatalf("Get(%s): %v", key, err)
+		}
+	}
+	if value, _ := db.Get([]byte("upper"), nil); string(value) != fmt.Sprintf("%d:VALUE", now+3600) {
+		t.Fatalf("unexpected value %q", value)
+	}
+	for _, readOpts := range []*utils.ReadOptions{nil, {Snapshot: snapshot}} {
+		if value, err := db.Get([]byte("snapshot"), readOpts); err != nil || !bytes.Equal(value, expired) {
+			t.Fatalf("Get(snapshot) = (%q, %v)", value, err)
+		}
+	}
+
+	filter.mutex.Lock()
+	if len(filter.contexts) == 0 {
+		t.Fatal("expected the filter to be called")
+	}
+	for _, ctx := range filter.contexts {
+		if !ctx.IsManualCompaction || !ctx.IsBottommostLevel || ctx.OutputLevel == 0 {
+			t.Fatalf("unexpected filter context %+v", ctx)
+		}
+	}
+	filter.mutex.Unlock()
+
+	// 释放快照之后记录可以被过滤
+	db.ReleaseSnapshot(snapshot)
+	if err := db.CompactRange(nil, nil, &utils.CompactRangeOptions{ForceBottommost: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get([]byte("snapshot"), nil); err != errors.ErrDBNotFound {
+		t.Fatalf("expected the expired record to be removed, got %v", err)
+	}
+	checkNoObsoleteTables(t, db.defaultCF)
+}
@@ -12,16 +12,60 @@ const (
 	MaxOpenFiles          = 1000
 	NumNonTableCacheFiles = 10
 
-	// 调节写入速度
-	L0SlowdownWritesTrigger = 8
-	SlowdownSleepTime       = time.Duration(1000) * time.Microsecond
+	// 调节写入速度：Level0文件数量或待合并的数据量达到slowdown阈值时延迟写入，达到stop阈值时停止写入直到Compaction完成
+	L0SlowdownWritesTrigger         = 8
+	L0StopWritesTrigger             = 12
+	SoftPendingCompactionBytesLimit = 64 << 30
+	HardPendingCompactionBytesLimit = 256 << 30
+	// 延迟写入时的初始写入速度（字节/秒）及下限
+	DelayedWriteRate    = 16 << 20
+	MinDelayedWriteRate = 16 << 10
+	// 延迟期间待合并的数据量或Level0文件数量增加时写入速度乘以该系数，减少时除以该系数
+	DelayedWriteRateDecay = 0.8
 
 	// Compaction相关
 	L0CompactionTrigger = 4
 	WriteBufferSize     = 4 << 20
 	MaxMemCompactLevel  = 2
 	L1FileMaxBytes      = 10.0 * 1048576.0
+	// 相邻Level目标大小的倍数
+	LevelSizeMultiplier = 10
 	MaxFileSize         = 2 << 20
+	// Compaction的一个输出文件与输出Level之下一层（grandparent）重叠的数据量上限，
+	// 避免之后合并该文件时涉及过多的数据；超过上限的文件也不能直接移动到下一层
+	MaxGrandParentOverlapBytes = 10 * MaxFileSize
+	// 扩大Compaction上层输入文件时，扩大后所有输入文件的总大小上限
+	ExpandedCompactionByteSizeLimit = 25 * MaxFileSize
+	// 同时进行的后台major compaction数量上限，flush不占用该数量
+	MaxBackgroundCompactions = 1
+	// 一次Compaction最多划分为多少个key范围并行合并
+	MaxSubcompactions = 1
+
+	// Universal Compaction相关：sorted run的数量达到L0CompactionTrigger时触发
+	UniversalSizeRatio                   = 1
+	UniversalMinMergeWidth               = 2
+	UniversalMaxSizeAmplificationPercent = 200
+
+	// FIFO Compaction相关：所有文件的总大小上限
+	FIFOMaxTableFilesSize = 1 << 30
+	// 设置了TTL的FIFO列族每隔该时间检查一次过期的文件，没有写入时过期的文件也会被删除
+	FIFOTTLCheckInterval = time.Second
+
+	// 后台flush或Compaction失败后等待该时间再重试
+	BackgroundErrorRetryInterval = time.Second
+
+	// 查找触发的Compaction：一次无效查找的代价约等于Compaction处理BytesPerSeek字节的数据
+	// 文件的allowSeeks为其大小除以BytesPerSeek，至少为MinAllowSeeks
+	BytesPerSeek  = 16 * 1024
+	MinAllowSeeks = 100
 
 	MaxBlockSize = 4 * 1024
+	// 写入SST文件时每写入该字节数同步一次，避免写完时一次性刷盘造成的写入尖峰，为0时只在写完时同步
+	BytesPerSync = 1 << 20
+
+	// 写入限速器补充令牌的周期，令牌最多积累一个周期的数量
+	RateLimiterRefillPeriod = 100 * time.Millisecond
+
+	// 事务相关
+	TxnLockTimeout = time.Duration(1000) * time.Millisecond
 )
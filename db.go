@@ -15,6 +15,14 @@ type DB interface {
 }
 
 type Iterator interface {
+	internalIterator
+
+	// 释放迭代器引用的SST文件，使用完毕后必须调用
+	Close()
+}
+
+// 数据库内部归并使用的迭代器，不持有需要释放的资源
+type internalIterator interface {
 	// 返回迭代器所在节点是否合法
 	Valid() bool
 
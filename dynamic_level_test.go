@@ -0,0 +1,58 @@
+package yldb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/config"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var dynamicLevelPath = "./test_data/test_dynamic_level"
+
+func TestDynamicLevelBytes(t *testing.T) {
+	_ = os.RemoveAll(dynamicLevelPath)
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{
+		WriteBufferSize:                  4 << 10,
+		LevelCompactionDynamicLevelBytes: true,
+	}}
+	db, err := OpenWithOptions(dynamicLevelPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const n = 5000
+	for round := 0; round < 2; round++ {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%05d", i))
+			_ = db.Set(key, []byte(fmt.Sprintf("%s-%d", key, round)), nil)
+		}
+	}
+	db.mutex.Lock()
+	err = db.flush()
+	db.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForCompactions(db)
+
+	// 数据量很小，L0之外的数据都在最底层
+	current := db.defaultCF.current
+	for level := 1; level < config.NumLevels-1; level++ {
+		if files := current.NumLevelFiles(level); files != 0 {
+			t.Fatalf("expected empty level %d, got %d files", level, files)
+		}
+	}
+	if current.NumLevelFiles(config.NumLevels-1) == 0 {
+		t.Fatal("expected files in the last level")
+	}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if value, err := db.Get(key, nil); err != nil || string(value) != fmt.Sprintf("%s-1", key) {
+			t.Fatalf("Get(%s) = (%q, %v)", key, value, err)
+		}
+	}
+	checkNoObsoleteTables(t, db.defaultCF)
+}
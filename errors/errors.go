@@ -1,6 +1,9 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// MemTable errors
@@ -29,8 +32,68 @@ var (
 	ErrMajorCompactionError = errors.New("YLDB.Error.Compaction.MajorCompactionError")
 
 	// Batch errors
-	ErrBatchInvalid = errors.New("YLDB.Error.Batch.Invalid")
+	ErrBatchInvalid         = errors.New("YLDB.Error.Batch.Invalid")
+	ErrBatchUnsupportedKind = errors.New("YLDB.Error.Batch.UnsupportedKind")
+
+	// WAL errors
+	ErrWALTruncated        = errors.New("YLDB.Error.WAL.Truncated")
+	ErrWALChecksumMismatch = errors.New("YLDB.Error.WAL.ChecksumMismatch")
+	ErrWALNotRetained      = errors.New("YLDB.Error.WAL.NotRetained")
+
+	// Manifest errors
+	ErrManifestDecodeError = errors.New("YLDB.Error.Manifest.DecodeError")
+	ErrComparatorMismatch  = errors.New("YLDB.Error.Manifest.ComparatorMismatch")
+
+	// ColumnFamily errors
+	ErrColumnFamilyExists      = errors.New("YLDB.Error.ColumnFamily.AlreadyExists")
+	ErrColumnFamilyNotFound    = errors.New("YLDB.Error.ColumnFamily.NotFound")
+	ErrColumnFamilyDropped     = errors.New("YLDB.Error.ColumnFamily.Dropped")
+	ErrDropDefaultColumnFamily = errors.New("YLDB.Error.ColumnFamily.DropDefault")
 
 	// DB errors
-	ErrDBNotFound = errors.New("YLDB.Error.DB.NotFound")
+	ErrDBNotFound   = errors.New("YLDB.Error.DB.NotFound")
+	ErrDBClosed     = errors.New("YLDB.Error.DB.Closed")
+	ErrDBReadOnly   = errors.New("YLDB.Error.DB.ReadOnly")
+	ErrNotSecondary = errors.New("YLDB.Error.DB.NotSecondary")
+	ErrWriteStall   = errors.New("YLDB.Error.DB.WriteStall")
+
+	// Transaction errors
+	ErrTxnConflict    = errors.New("YLDB.Error.Transaction.Conflict")
+	ErrTxnDeadlock    = errors.New("YLDB.Error.Transaction.Deadlock")
+	ErrTxnLockTimeout = errors.New("YLDB.Error.Transaction.LockTimeout")
+	ErrTxnDone        = errors.New("YLDB.Error.Transaction.AlreadyDone")
+
+	// Checkpoint errors
+	ErrCheckpointDirExists = errors.New("YLDB.Error.Checkpoint.DirAlreadyExists")
+
+	// Replication errors
+	ErrReplicationSeqMismatch = errors.New("YLDB.Error.Replication.SequenceMismatch")
+	ErrReplicationProtocol    = errors.New("YLDB.Error.Replication.ProtocolError")
+	ErrReplicationRemote      = errors.New("YLDB.Error.Replication.RemoteError")
+
+	// Ingest errors
+	ErrIngestKeyOutOfOrder = errors.New("YLDB.Error.Ingest.KeyOutOfOrder")
+	ErrIngestWriterClosed  = errors.New("YLDB.Error.Ingest.WriterClosed")
+	ErrIngestEmptyFile     = errors.New("YLDB.Error.Ingest.EmptyFile")
+	ErrIngestInvalidFile   = errors.New("YLDB.Error.Ingest.InvalidFile")
+	ErrIngestFilesOverlap  = errors.New("YLDB.Error.Ingest.FilesOverlap")
+
+	// Backup errors
+	ErrBackupNotFound      = errors.New("YLDB.Error.Backup.NotFound")
+	ErrBackupCorrupted     = errors.New("YLDB.Error.Backup.Corrupted")
+	ErrBackupMetaCorrupted = errors.New("YLDB.Error.Backup.MetaCorrupted")
+	ErrRestoreDirExists    = errors.New("YLDB.Error.Backup.RestoreDirAlreadyExists")
 )
+
+// BatchError 描述Batch编码中出错的位置
+type BatchError struct {
+	// 出错操作的序号（从0开始）
+	Index uint32
+	// 出错操作在Batch编码中的字节偏移
+	Offset int
+	Reason string
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%v: op %d at offset %d: %s", ErrBatchInvalid, e.Index, e.Offset, e.Reason)
+}
@@ -0,0 +1,88 @@
+package yldb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/config"
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var fifoCompactionPath = "./test_data/test_fifo_compaction"
+
+func TestFIFOCompaction(t *testing.T) {
+	_ = os.RemoveAll(fifoCompactionPath)
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{
+		WriteBufferSize:       4 << 10,
+		CompactionStyle:       utils.CompactionStyleFIFO,
+		FIFOCompactionOptions: &utils.FIFOCompactionOptions{MaxTableFilesSize: 64 << 10},
+	}}
+	db, err := OpenWithOptions(fifoCompactionPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 按时间顺序写入，总数据量远超上限
+	const n = 5000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = db.Set(key, key, nil)
+	}
+	db.mutex.Lock()
+	err = db.flush()
+	db.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForCompactions(db)
+
+	current := db.defaultCF.current
+	if n := numTableFiles(db.defaultCF); n == 0 || n != current.NumLevelFiles(0) {
+		t.Fatalf("expected all %d files in level0", n)
+	}
+	checkNoObsoleteTables(t, db.defaultCF)
+
+	// 最旧的数据被删除，最新的数据仍然存在
+	if _, err := db.Get([]byte("key00000"), nil); err != errors.ErrDBNotFound {
+		t.Fatalf("expected the oldest key to be dropped, got %v", err)
+	}
+	key := []byte(fmt.Sprintf("key%05d", n-1))
+	if value, err := db.Get(key, nil); err != nil || string(value) != string(key) {
+		t.Fatalf("Get(%s) = (%q, %v)", key, value, err)
+	}
+
+	// 文件的创建时间写入MANIFEST，重启后即使没有写入，过期的文件也会被定期检查删除
+	db.Close()
+	opts.FIFOCompactionOptions = &utils.FIFOCompactionOptions{TTL: 3 * time.Second}
+	db, err = OpenWithOptions(fifoCompactionPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		db.mutex.Lock()
+		remaining := db.defaultCF.current.NumLevelFiles(0)
+		db.mutex.Unlock()
+		if remaining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected expired files to be dropped, %d files remaining", remaining)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	waitForCompactions(db)
+	if _, err := db.Get(key, nil); err != errors.ErrDBNotFound {
+		t.Fatalf("expected expired key to be dropped, got %v", err)
+	}
+	for level := 1; level < config.NumLevels; level++ {
+		if n := db.defaultCF.current.NumLevelFiles(level); n != 0 {
+			t.Fatalf("expected empty level %d, got %d files", level, n)
+		}
+	}
+	checkNoObsoleteTables(t, db.defaultCF)
+}
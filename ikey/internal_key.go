@@ -13,10 +13,12 @@ import (
 const (
 	InternalKeyKindDelete InternalKeyKind = 0
 	InternalKeyKindSet    InternalKeyKind = 1
+	// 带过期时间的写入，value的格式见EncodeTTLValue
+	InternalKeyKindSetWithTTL InternalKeyKind = 2
 
-	InternalKeyKindMax InternalKeyKind = 1
+	InternalKeyKindMax InternalKeyKind = 2
 
-	//InternalKeySeqNumMax = uint64(1<<56 - 1)
+	InternalKeySeqNumMax = uint64(1<<56 - 1)
 )
 
 type InternalKey []byte
@@ -48,8 +50,9 @@ func MakeInternalKey(dst InternalKey, userKey []byte, kind InternalKeyKind, seqN
 	return ikey
 }
 
+// 查找用的internal_key使用最大的kind，排在同一序列号的所有记录之前
 func MakeLookUpKey(key []byte) InternalKey {
-	return MakeInternalKey(nil, key, InternalKeyKindSet, math.MaxUint64)
+	return MakeInternalKey(nil, key, InternalKeyKindMax, math.MaxUint64)
 }
 
 // 判断internal_key是否合法
@@ -0,0 +1,39 @@
+package ikey
+
+import (
+	"encoding/binary"
+)
+
+const ttlValueHeaderLen = 8
+
+// 生成带过期时间的写入记录的value，格式为
+// expiration(8 bytes，小端模式的UnixNano) | value
+func EncodeTTLValue(value []byte, expiration int64) []byte {
+	buf := make([]byte, ttlValueHeaderLen+len(value))
+	binary.LittleEndian.PutUint64(buf, uint64(expiration))
+	copy(buf[ttlValueHeaderLen:], value)
+	return buf
+}
+
+// 解析带过期时间的写入记录的value，格式不合法时返回false
+func DecodeTTLValue(value []byte) (expiration int64, userValue []byte, ok bool) {
+	if len(value) < ttlValueHeaderLen {
+		return 0, nil, false
+	}
+	return int64(binary.LittleEndian.Uint64(value)), value[ttlValueHeaderLen:], true
+}
+
+// 返回记录在now（UnixNano）时刻对读取可见的value，删除记录和已过期的记录返回false
+func LiveValue(kind InternalKeyKind, value []byte, now int64) ([]byte, bool) {
+	switch kind {
+	case InternalKeyKindSet:
+		return value, true
+	case InternalKeyKindSetWithTTL:
+		expiration, userValue, ok := DecodeTTLValue(value)
+		if !ok || expiration <= now {
+			return nil, false
+		}
+		return userValue, true
+	}
+	return nil, false
+}
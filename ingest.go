@@ -0,0 +1,242 @@
+package yldb
+
+import (
+	"log"
+	"os"
+	"sort"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/ikey"
+	"github.com/Cauchy-NY/yldb/memdb"
+	"github.com/Cauchy-NY/yldb/sstable"
+	"github.com/Cauchy-NY/yldb/utils"
+	"github.com/Cauchy-NY/yldb/version"
+)
+
+// 待导入的外部SST文件
+type ingestFile struct {
+	path  string
+	table *sstable.SSTable
+	// 文件中的user_key范围
+	smallestKey []byte
+	largestKey  []byte
+	// 导入后在数据库中的文件编号、大小和internal_key范围
+	number   uint64
+	size     uint64
+	smallest ikey.InternalKey
+	largest  ikey.InternalKey
+}
+
+func (db *YLDB) IngestExternalFiles(paths []string) error {
+	return db.IngestExternalFilesCF(db.defaultCF, paths)
+}
+
+// 将SSTWriter生成的文件导入列族cf，所有文件一起原子地加入Version
+// 导入的数据使用同一个新分配的序列号，比导入之前的所有写入都新，各文件之间的key范围不能重叠
+// 文件在重写到数据库目录的同时写入新的序列号并校验key的顺序，原文件保持不变
+// 导入的数据不经过WAL，GetUpdatesSince无法跨过导入所用的序列号，从库需要重新Bootstrap（见replication.OpenFollower）
+func (db *YLDB) IngestExternalFilesCF(cf *ColumnFamily, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	files, err := openIngestFiles(paths, cf.cmp)
+	defer func() {
+		for _, file := range files {
+			_ = file.table.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	reservation, err := db.prepareIngest(cf, files)
+	db.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// 重写文件时不持有db.mutex，期间flush和不冲突的Compaction可以继续修改Version
+	for _, file := range files {
+		if err = file.rewrite(cf.dir, cf.cmp, reservation.seq); err != nil {
+			break
+		}
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if err == nil {
+		err = db.installIngestedFiles(cf, files)
+	}
+	if err != nil {
+		for _, file := range files {
+			if file.number != 0 {
+				_ = os.Remove(utils.TableFileName(cf.dir, file.number))
+			}
+		}
+	}
+	db.releaseIngest(cf, reservation)
+	return err
+}
+
+// 导入期间占用的key范围和输出文件编号
+type ingestReservation struct {
+	seq        uint64
+	compaction *version.Compaction
+	pending    uint64
+}
+
+// 打开所有文件并按key范围排序，检查文件之间没有重叠
+func openIngestFiles(paths []string, cmp utils.Comparator) ([]*ingestFile, error) {
+	var files []*ingestFile
+	for _, path := range paths {
+		table, err := sstable.Open(path, cmp)
+		if err != nil {
+			return files, err
+		}
+		file := &ingestFile{path: path, table: table}
+		files = append(files, file)
+
+		it := table.Iterator()
+		if it.SeekToFirst(); !it.Valid() {
+			return files, errors.ErrIngestEmptyFile
+		}
+		if !it.InternalKey().Valid() {
+			return files, errors.ErrIngestInvalidFile
+		}
+		file.smallestKey = it.UserKey()
+		it.SeekToLast()
+		if !it.InternalKey().Valid() {
+			return files, errors.ErrIngestInvalidFile
+		}
+		file.largestKey = it.UserKey()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return cmp.Compare(files[i].smallestKey, files[j].smallestKey) < 0
+	})
+	for i := 1; i < len(files); i++ {
+		if cmp.Compare(files[i].smallestKey, files[i-1].largestKey) <= 0 {
+			return files, errors.ErrIngestFilesOverlap
+		}
+	}
+	return files, nil
+}
+
+// 占用导入文件的key范围，分配序列号和文件编号，调用方需持有db.mutex
+// MemTable中与导入文件重叠的数据比导入的数据旧，但查找时会先被读到，因此需要先flush；
+// 占用之后与该范围冲突的Compaction不会被选取，之后flush的重叠数据比导入的数据新，只会放在L0
+func (db *YLDB) prepareIngest(cf *ColumnFamily, files []*ingestFile) (*ingestReservation, error) {
+	for {
+		if db.readOnly {
+			return nil, errors.ErrDBReadOnly
+		}
+		if db.closed {
+			return nil, errors.ErrDBClosed
+		}
+		if cf.dropped {
+			return nil, errors.ErrColumnFamilyDropped
+		}
+		if !memTablesOverlap(cf, files) {
+			break
+		}
+		if err := db.flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	db.manualJobs++
+	reservation := &ingestReservation{
+		compaction: cf.current.ReserveRange(files[0].smallestKey, files[len(files)-1].largestKey),
+		pending:    cf.addPendingOutputs(),
+	}
+	cf.compactions = append(cf.compactions, reservation.compaction)
+	db.seq++
+	reservation.seq = db.seq
+	for _, file := range files {
+		file.number = cf.current.NewFileNumber()
+	}
+	return reservation, nil
+}
+
+// 释放导入期间占用的key范围，重新调度被推迟的Compaction，调用方需持有db.mutex
+func (db *YLDB) releaseIngest(cf *ColumnFamily, reservation *ingestReservation) {
+	cf.removeCompaction(reservation.compaction)
+	cf.removePendingOutputs(reservation.pending)
+	db.manualJobs--
+	db.cond.Broadcast()
+	db.maybeScheduleCompaction()
+}
+
+func memTablesOverlap(cf *ColumnFamily, files []*ingestFile) bool {
+	for _, mem := range []*memdb.MemTable{cf.mem, cf.imm} {
+		if mem == nil {
+			continue
+		}
+		for _, file := range files {
+			it := mem.Iterator()
+			it.Seek(file.smallestKey)
+			if it.Valid() && cf.cmp.Compare(it.UserKey(), file.largestKey) <= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 将文件重写到dir目录中，所有记录使用序列号seq
+func (file *ingestFile) rewrite(dir string, cmp utils.Comparator, seq uint64) error {
+	builder, err := sstable.NewTableBuilder(utils.TableFileName(dir, file.number))
+	if err != nil {
+		return err
+	}
+	var lastKey []byte
+	it := file.table.Iterator()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		key := it.InternalKey()
+		if !key.Valid() || key.SeqNum() != 0 {
+			err = errors.ErrIngestInvalidFile
+			break
+		}
+		if lastKey != nil && cmp.Compare(key.UserKey(), lastKey) <= 0 {
+			err = errors.ErrIngestKeyOutOfOrder
+			break
+		}
+		lastKey = key.UserKey()
+
+		internalKey := ikey.MakeInternalKey(nil, key.UserKey(), key.Kind(), seq)
+		if file.smallest == nil {
+			file.smallest = internalKey
+		}
+		file.largest = internalKey
+		builder.Add(internalKey, it.Value())
+	}
+	builder.Finish()
+	if err != nil {
+		return err
+	}
+	if err := builder.Err(); err != nil {
+		return err
+	}
+	file.size = uint64(builder.FileSize())
+	return nil
+}
+
+// 将重写后的文件加入新的Version并写入MANIFEST，调用方需持有db.mutex
+func (db *YLDB) installIngestedFiles(cf *ColumnFamily, files []*ingestFile) error {
+	if cf.dropped {
+		return errors.ErrColumnFamilyDropped
+	}
+	old := cf.current
+	current := old.Copy()
+	for _, file := range files {
+		level := current.IngestFile(file.number, file.size, file.smallest, file.largest)
+		log.Printf("IngestFile, Path:%s, Level:%d, Num:%d", file.path, level, file.number)
+	}
+	cf.current = current
+	if err := db.saveManifest(); err != nil {
+		cf.current = old
+		return err
+	}
+	return nil
+}
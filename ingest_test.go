@@ -0,0 +1,167 @@
+package yldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/config"
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var (
+	ingestPath    = "./test_data/test_ingest"
+	ingestExtPath = "./test_data/test_ingest_external"
+)
+
+// 生成包含[from, to)中所有key的外部文件，deleted中的key写入删除记录
+func writeExternalFile(t *testing.T, name string, from, to int, deleted map[int]bool) string {
+	path := filepath.Join(ingestExtPath, name)
+	w, err := NewSSTWriter(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := from; i < to; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if deleted[i] {
+			err = w.Delete(key)
+		} else {
+			err = w.Set(key, []byte("ext-"+string(key)))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIngestExternalFiles(t *testing.T) {
+	_ = os.RemoveAll(ingestPath)
+	_ = os.RemoveAll(ingestExtPath)
+	_ = os.MkdirAll(ingestExtPath, 0755)
+	a := writeExternalFile(t, "a.sst", 0, 500, nil)
+	b := writeExternalFile(t, "b.sst", 500, 1000, map[int]bool{600: true})
+
+	db, err := Open(ingestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Set([]byte("key00100"), []byte("old"), nil)
+	_ = db.Set([]byte("key00600"), []byte("old"), nil)
+	_ = db.Set([]byte("zzz"), []byte("old"), nil)
+	snapshot := db.GetSnapshot()
+
+	if err := db.IngestExternalFiles([]string{b, a}); err != nil {
+		t.Fatal(err)
+	}
+	check := func(key, want string, wantErr error) {
+		value, err := db.Get([]byte(key), nil)
+		if err != wantErr || string(value) != want {
+			t.Fatalf("Get(%s) = (%q, %v), want (%q, %v)", key, value, err, want, wantErr)
+		}
+	}
+	check("key00000", "ext-key00000", nil)
+	check("key00100", "ext-key00100", nil)
+	check("key00600", "", errors.ErrDBNotFound)
+	check("key00999", "ext-key00999", nil)
+	check("zzz", "old", nil)
+
+	// 导入之前的快照看不到导入的数据
+	value, err := db.Get([]byte("key00100"), &utils.ReadOptions{Snapshot: snapshot})
+	if err != nil || string(value) != "old" {
+		t.Fatalf("Get(key00100) at snapshot = (%q, %v)", value, err)
+	}
+	if _, err := db.Get([]byte("key00000"), &utils.ReadOptions{Snapshot: snapshot}); err != errors.ErrDBNotFound {
+		t.Fatalf("expected ErrDBNotFound at snapshot, got %v", err)
+	}
+	db.ReleaseSnapshot(snapshot)
+
+	// 导入之后的写入覆盖导入的数据，重启后导入的文件仍然存在
+	_ = db.Set([]byte("key00001"), []byte("new"), nil)
+	db.Close()
+	db, err = Open(ingestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check("key00001", "new", nil)
+	check("key00002", "ext-key00002", nil)
+	check("key00600", "", errors.ErrDBNotFound)
+
+	// 原文件保持不变，可以重复导入
+	if err := db.IngestExternalFiles([]string{a}); err != nil {
+		t.Fatal(err)
+	}
+	check("key00001", "ext-key00001", nil)
+}
+
+func TestIngestLevel(t *testing.T) {
+	_ = os.RemoveAll(ingestPath)
+	_ = os.RemoveAll(ingestExtPath)
+	_ = os.MkdirAll(ingestExtPath, 0755)
+	db, err := Open(ingestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 不与任何文件重叠时放在最后一层
+	if err := db.IngestExternalFiles([]string{writeExternalFile(t, "a.sst", 0, 100, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.defaultCF.current.NumLevelFiles(config.NumLevels - 1); n != 1 {
+		t.Fatalf("expected 1 file in the last level, got %d", n)
+	}
+	// 与最后一层重叠时放在上一层
+	if err := db.IngestExternalFiles([]string{writeExternalFile(t, "b.sst", 50, 150, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.defaultCF.current.NumLevelFiles(config.NumLevels - 2); n != 1 {
+		t.Fatalf("expected 1 file in level %d, got %d", config.NumLevels-2, n)
+	}
+}
+
+func TestIngestErrors(t *testing.T) {
+	_ = os.RemoveAll(ingestPath)
+	_ = os.RemoveAll(ingestExtPath)
+	_ = os.MkdirAll(ingestExtPath, 0755)
+
+	w, err := NewSSTWriter(filepath.Join(ingestExtPath, "bad.sst"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Set([]byte("b"), nil)
+	if err := w.Set([]byte("a"), nil); err != errors.ErrIngestKeyOutOfOrder {
+		t.Fatalf("expected ErrIngestKeyOutOfOrder, got %v", err)
+	}
+	if err := w.Set([]byte("b"), nil); err != errors.ErrIngestKeyOutOfOrder {
+		t.Fatalf("expected ErrIngestKeyOutOfOrder for duplicate key, got %v", err)
+	}
+	_ = w.Finish()
+	if err := w.Set([]byte("c"), nil); err != errors.ErrIngestWriterClosed {
+		t.Fatalf("expected ErrIngestWriterClosed, got %v", err)
+	}
+	w, _ = NewSSTWriter(filepath.Join(ingestExtPath, "empty.sst"), nil)
+	if err := w.Finish(); err != errors.ErrIngestEmptyFile {
+		t.Fatalf("expected ErrIngestEmptyFile, got %v", err)
+	}
+
+	db, err := Open(ingestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	a := writeExternalFile(t, "a.sst", 0, 100, nil)
+	b := writeExternalFile(t, "b.sst", 99, 200, nil)
+	if err := db.IngestExternalFiles([]string{a, b}); err != errors.ErrIngestFilesOverlap {
+		t.Fatalf("expected ErrIngestFilesOverlap, got %v", err)
+	}
+	if _, err := db.Get([]byte("key00000"), nil); err != errors.ErrDBNotFound {
+		t.Fatalf("failed ingestion should not be visible, got %v", err)
+	}
+}
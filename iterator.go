@@ -0,0 +1,371 @@
+package yldb
+
+import (
+	"time"
+
+	"github.com/Cauchy-NY/yldb/ikey"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+type direction int
+
+const (
+	forward direction = iota
+	reverse
+)
+
+// 返回遍历默认列族的迭代器，opts中指定快照时只能看到快照之前的写入
+// 迭代器创建后需要先调用Seek/SeekToFirst/SeekToLast定位
+// 迭代器会阻止其引用的SST文件被关闭，使用完毕后需要调用Close
+func (db *YLDB) NewIterator(opts *utils.ReadOptions) Iterator {
+	return db.NewIteratorCF(db.defaultCF, opts)
+}
+
+func (db *YLDB) NewIteratorCF(cf *ColumnFamily, opts *utils.ReadOptions) Iterator {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var children []internalIterator
+	if cf.mem != nil {
+		children = append(children, cf.mem.Iterator())
+	}
+	if cf.imm != nil {
+		children = append(children, cf.imm.Iterator())
+	}
+	iterators, release := cf.current.Iterators()
+	for _, it := range iterators {
+		children = append(children, it)
+	}
+	dbIter := newDBIterator(cf.cmp, newMergingIterator(cf.cmp, children), db.readSeq(opts))
+	dbIter.release = release
+	return dbIter
+}
+
+//----------------------------------mergingIterator----------------------------------
+
+// 将多个有序的内部迭代器按InternalKey顺序归并，不做去重
+type mergingIterator struct {
+	cmp       ikey.InternalKeyComparator
+	children  []internalIterator
+	current   internalIterator
+	direction direction
+}
+
+func newMergingIterator(userCmp utils.Comparator, children []internalIterator) *mergingIterator {
+	return &mergingIterator{
+		cmp:      ikey.NewInternalKeyComparator(userCmp),
+		children: children,
+	}
+}
+
+func (it *mergingIterator) Valid() bool {
+	return it.current != nil && it.current.Valid()
+}
+
+func (it *mergingIterator) InternalKey() ikey.InternalKey {
+	return it.current.InternalKey()
+}
+
+func (it *mergingIterator) UserKey() []byte {
+	return it.current.InternalKey().UserKey()
+}
+
+func (it *mergingIterator) Value() []byte {
+	return it.current.Value()
+}
+
+func (it *mergingIterator) Next() {
+	// 由反向改为正向时，其他子迭代器都位于当前key之前，需要把它们定位到当前key之后
+	if it.direction != forward {
+		key := it.current.InternalKey()
+		for _, child := range it.children {
+			if child == it.current {
+				continue
+			}
+			child.Seek(key.UserKey())
+			for child.Valid() && it.cmp.Compare(child.InternalKey(), key) <= 0 {
+				child.Next()
+			}
+		}
+		it.direction = forward
+	}
+	it.current.Next()
+	it.findSmallest()
+}
+
+func (it *mergingIterator) Prev() {
+	// 由正向改为反向时，其他子迭代器都位于当前key之后，需要把它们定位到当前key之前
+	if it.direction != reverse {
+		key := it.current.InternalKey()
+		for _, child := range it.children {
+			if child == it.current {
+				continue
+			}
+			child.Seek(key.UserKey())
+			for child.Valid() && it.cmp.Compare(child.InternalKey(), key) < 0 {
+				child.Next()
+			}
+			if child.Valid() {
+				child.Prev()
+			} else {
+				child.SeekToLast()
+			}
+		}
+		it.direction = reverse
+	}
+	it.current.Prev()
+	it.findLargest()
+}
+
+func (it *mergingIterator) Seek(target []byte) {
+	for _, child := range it.children {
+		child.Seek(target)
+	}
+	it.findSmallest()
+	it.direction = forward
+}
+
+func (it *mergingIterator) SeekToFirst() {
+	for _, child := range it.children {
+		child.SeekToFirst()
+	}
+	it.findSmallest()
+	it.direction = forward
+}
+
+func (it *mergingIterator) SeekToLast() {
+	for _, child := range it.children {
+		child.SeekToLast()
+	}
+	it.findLargest()
+	it.direction = reverse
+}
+
+func (it *mergingIterator) findSmallest() {
+	var smallest internalIterator
+	for _, child := range it.children {
+		if child.Valid() && (smallest == nil || it.cmp.Compare(child.InternalKey(), smallest.InternalKey()) < 0) {
+			smallest = child
+		}
+	}
+	it.current = smallest
+}
+
+func (it *mergingIterator) findLargest() {
+	var largest internalIterator
+	for _, child := range it.children {
+		if child.Valid() && (largest == nil || it.cmp.Compare(child.InternalKey(), largest.InternalKey()) > 0) {
+			largest = child
+		}
+	}
+	it.current = largest
+}
+
+//----------------------------------mergingIterator----------------------------------
+
+//------------------------------------dbIterator-------------------------------------
+
+// 对归并后的内部迭代器进行过滤，对外只暴露用户可见的数据：
+// - 跳过序列号大于seq的记录
+// - 同一user_key只保留最新的一条记录
+// - 最新记录为删除记录或已过期时跳过该user_key
+type dbIterator struct {
+	userCmp   utils.Comparator
+	iter      internalIterator
+	seq       uint64
+	direction direction
+	valid     bool
+	// 创建迭代器的时刻（UnixNano），此时已过期的记录不可见
+	now int64
+	// 正向迭代时，savedKey记录需要跳过的user_key
+	// 反向迭代时，iter位于当前记录之前，当前记录的key/value保存在savedKey/savedValue中
+	savedKey   ikey.InternalKey
+	savedValue []byte
+	// 释放迭代器引用的SST文件
+	release func()
+}
+
+func newDBIterator(userCmp utils.Comparator, iter internalIterator, seq uint64) *dbIterator {
+	return &dbIterator{
+		userCmp: userCmp,
+		iter:    iter,
+		seq:     seq,
+		now:     time.Now().UnixNano(),
+	}
+}
+
+func (it *dbIterator) Close() {
+	if it.release != nil {
+		it.release()
+		it.release = nil
+	}
+}
+
+func (it *dbIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *dbIterator) InternalKey() ikey.InternalKey {
+	if it.direction == forward {
+		return it.iter.InternalKey()
+	}
+	return it.savedKey
+}
+
+func (it *dbIterator) UserKey() []byte {
+	return it.InternalKey().UserKey()
+}
+
+func (it *dbIterator) Value() []byte {
+	if it.direction == forward {
+		value, _ := ikey.LiveValue(it.iter.InternalKey().Kind(), it.iter.Value(), it.now)
+		return value
+	}
+	return it.savedValue
+}
+
+func (it *dbIterator) Next() {
+	if it.direction == reverse {
+		// iter位于当前user_key的所有记录之前，前进到这些记录上，由下面的逻辑跳过它们
+		it.direction = forward
+		if !it.iter.Valid() {
+			it.iter.SeekToFirst()
+		} else {
+			it.iter.Next()
+		}
+		if !it.iter.Valid() {
+			it.valid = false
+			it.savedKey = nil
+			return
+		}
+	} else {
+		it.savedKey = copyBytes(it.iter.InternalKey())
+		it.iter.Next()
+		if !it.iter.Valid() {
+			it.valid = false
+			it.savedKey = nil
+			return
+		}
+	}
+	it.findNextUserEntry(true)
+}
+
+func (it *dbIterator) Prev() {
+	if it.direction == forward {
+		// iter位于当前记录上，先后退到上一个user_key的位置，再使用反向查找逻辑
+		it.savedKey = copyBytes(it.iter.InternalKey())
+		for {
+			it.iter.Prev()
+			if !it.iter.Valid() {
+				it.valid = false
+				it.savedKey = nil
+				it.savedValue = nil
+				return
+			}
+			if it.userCmp.Compare(it.iter.UserKey(), it.savedKey.UserKey()) < 0 {
+				break
+			}
+		}
+		it.direction = reverse
+	}
+	it.findPrevUserEntry()
+}
+
+func (it *dbIterator) Seek(target []byte) {
+	it.direction = forward
+	it.savedKey = nil
+	it.savedValue = nil
+	it.iter.Seek(target)
+	if it.iter.Valid() {
+		it.findNextUserEntry(false)
+	} else {
+		it.valid = false
+	}
+}
+
+func (it *dbIterator) SeekToFirst() {
+	it.direction = forward
+	it.savedKey = nil
+	it.savedValue = nil
+	it.iter.SeekToFirst()
+	if it.iter.Valid() {
+		it.findNextUserEntry(false)
+	} else {
+		it.valid = false
+	}
+}
+
+func (it *dbIterator) SeekToLast() {
+	it.direction = reverse
+	it.savedKey = nil
+	it.savedValue = nil
+	it.iter.SeekToLast()
+	it.findPrevUserEntry()
+}
+
+// 正向查找下一条可见记录，skipping为true时跳过所有user_key<=savedKey的记录
+func (it *dbIterator) findNextUserEntry(skipping bool) {
+	for ; it.iter.Valid(); it.iter.Next() {
+		internalKey := it.iter.InternalKey()
+		if internalKey.SeqNum() > it.seq {
+			continue
+		}
+		if _, live := ikey.LiveValue(internalKey.Kind(), it.iter.Value(), it.now); !live {
+			// 该user_key更旧的记录都被删除记录或过期的记录覆盖
+			it.savedKey = copyBytes(internalKey)
+			skipping = true
+		} else if skipping && it.userCmp.Compare(internalKey.UserKey(), it.savedKey.UserKey()) <= 0 {
+			// 被更新的记录覆盖
+		} else {
+			it.valid = true
+			it.savedKey = nil
+			return
+		}
+	}
+	it.savedKey = nil
+	it.valid = false
+}
+
+// 反向查找上一条可见记录，结果保存在savedKey/savedValue中，iter停在该user_key的所有记录之前
+func (it *dbIterator) findPrevUserEntry() {
+	deleted := true
+	for ; it.iter.Valid(); it.iter.Prev() {
+		internalKey := it.iter.InternalKey()
+		if internalKey.SeqNum() > it.seq {
+			continue
+		}
+		if !deleted && it.userCmp.Compare(internalKey.UserKey(), it.savedKey.UserKey()) < 0 {
+			// 已经找到上一个user_key的最新记录
+			break
+		}
+		value, live := ikey.LiveValue(internalKey.Kind(), it.iter.Value(), it.now)
+		deleted = !live
+		if deleted {
+			it.savedKey = nil
+			it.savedValue = nil
+		} else {
+			it.savedKey = copyBytes(internalKey)
+			it.savedValue = copyBytes(value)
+		}
+	}
+
+	if deleted {
+		it.valid = false
+		it.savedKey = nil
+		it.savedValue = nil
+		it.direction = forward
+	} else {
+		it.valid = true
+	}
+}
+
+//------------------------------------dbIterator-------------------------------------
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,99 @@
+package yldb
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/ikey"
+	"github.com/Cauchy-NY/yldb/memdb"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var iteratorPath = "./test_data/test_iterator"
+
+func TestMergingIterator(t *testing.T) {
+	var children []internalIterator
+	for i, keys := range [][]string{{"a", "d", "g"}, {"b", "d", "h"}, {"c"}} {
+		mem := memdb.NewMemTable(nil)
+		for _, k := range keys {
+			_ = mem.Set(ikey.MakeInternalKey(nil, []byte(k), ikey.InternalKeyKindSet, uint64(i+1)), []byte(k))
+		}
+		children = append(children, mem.Iterator())
+	}
+	it := newMergingIterator(utils.NewDefaultComparator(), children)
+
+	var got []string
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		got = append(got, string(it.UserKey()))
+	}
+	if want := []string{"a", "b", "c", "d", "d", "g", "h"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("forward: got %v, want %v", got, want)
+	}
+
+	// 同一user_key序列号大的排在前面
+	it.Seek([]byte("d"))
+	if !it.Valid() || it.InternalKey().SeqNum() != 2 {
+		t.Fatalf("seek d: got %q", it.InternalKey())
+	}
+	it.Prev()
+	if !it.Valid() || string(it.UserKey()) != "c" {
+		t.Fatalf("prev: got %q, want %q", it.UserKey(), "c")
+	}
+	it.Next()
+	it.Next()
+	if !it.Valid() || string(it.UserKey()) != "d" || it.InternalKey().SeqNum() != 1 {
+		t.Fatalf("next: got %q", it.InternalKey())
+	}
+}
+
+func TestDBIterator(t *testing.T) {
+	_ = os.RemoveAll(iteratorPath)
+	db, err := Open(iteratorPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_ = db.Set([]byte("apple"), []byte("red"), nil)
+	_ = db.Set([]byte("grape"), []byte("purple"), nil)
+	_ = db.Set([]byte("peach"), []byte("yellow"), nil)
+	snapshot := db.GetSnapshot()
+	defer db.ReleaseSnapshot(snapshot)
+	_ = db.Set([]byte("apple"), []byte("green"), nil)
+	_ = db.Delete([]byte("grape"), nil)
+	_ = db.Set([]byte("plum"), []byte("purple"), nil)
+
+	want := []string{"apple=green", "peach=yellow", "plum=purple"}
+	if got := collect(db.NewIterator(nil), false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("forward: got %v, want %v", got, want)
+	}
+	want = []string{"plum=purple", "peach=yellow", "apple=green"}
+	if got := collect(db.NewIterator(nil), true); !reflect.DeepEqual(got, want) {
+		t.Fatalf("reverse: got %v, want %v", got, want)
+	}
+
+	opts := &utils.ReadOptions{Snapshot: snapshot}
+	want = []string{"apple=red", "grape=purple", "peach=yellow"}
+	if got := collect(db.NewIterator(opts), false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshot: got %v, want %v", got, want)
+	}
+	if val, _ := db.Get([]byte("apple"), opts); string(val) != "red" {
+		t.Fatalf("snapshot get: got %q, want %q", val, "red")
+	}
+
+	it := db.NewIterator(nil)
+	defer it.Close()
+	it.Seek([]byte("b"))
+	if !it.Valid() || string(it.UserKey()) != "peach" {
+		t.Fatalf("seek: got %q, want %q", it.UserKey(), "peach")
+	}
+	it.Prev()
+	if !it.Valid() || string(it.UserKey()) != "apple" {
+		t.Fatalf("prev: got %q, want %q", it.UserKey(), "apple")
+	}
+	it.Next()
+	if !it.Valid() || string(it.UserKey()) != "peach" {
+		t.Fatalf("next: got %q, want %q", it.UserKey(), "peach")
+	}
+}
@@ -0,0 +1,84 @@
+package yldb
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+// LiveFiles 是数据库某一时刻的一致性状态，用于增量备份
+// SST文件写入后不再修改，由调用方直接从数据库目录读取；MANIFEST、CURRENT和WAL在创建时复制到私有目录中
+// Release之前数据库不会删除任何SST文件
+type LiveFiles struct {
+	db *YLDB
+	// 数据库目录，Tables中的路径相对于该目录
+	Dir string
+	// 当前Version引用的所有SST文件
+	Tables   []LiveTable
+	released bool
+}
+
+// LiveTable 描述一个SST文件
+type LiveTable struct {
+	// 文件在数据库目录下的相对路径
+	Path   string
+	Number uint64
+	Size   int64
+}
+
+// 先将MemTable写入SST文件，再将MANIFEST、CURRENT和尚未持久化的WAL写入privateDir，
+// 返回此时所有列族引用的SST文件，privateDir与这些SST文件一起构成一个可以直接用Open打开的数据库
+// 返回的LiveFiles使用完毕后需要调用Release，之前被Compaction淘汰的SST文件在此之后才会删除
+func (db *YLDB) GetLiveFiles(privateDir string) (*LiveFiles, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if err := db.flush(); err != nil {
+		return nil, err
+	}
+	live := &LiveFiles{db: db, Dir: db.name}
+	for _, cf := range db.columnFamilies() {
+		for _, number := range cf.current.LiveFiles() {
+			table := LiveTable{Number: number}
+			path := utils.TableFileName(cf.dir, number)
+			stat, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			table.Size = stat.Size()
+			if table.Path, err = filepath.Rel(db.name, path); err != nil {
+				return nil, err
+			}
+			live.Tables = append(live.Tables, table)
+		}
+	}
+	if err := os.MkdirAll(privateDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := db.writeDescriptor(privateDir); err != nil {
+		return nil, err
+	}
+	db.deletionHolds++
+	return live, nil
+}
+
+// 解除对SST文件的保护并删除期间被淘汰的文件，可以重复调用
+func (live *LiveFiles) Release() {
+	db := live.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if live.released {
+		return
+	}
+	live.released = true
+	db.deletionHolds--
+	if db.deletionHolds == 0 {
+		for _, cf := range db.cfs {
+			db.removeObsoleteTables(cf)
+		}
+		// 唤醒等待删除列族文件的DropColumnFamily
+		db.cond.Broadcast()
+	}
+}
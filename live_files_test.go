@@ -0,0 +1,71 @@
+package yldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var (
+	liveFilesDBPath = "./test_data/test_live_files_db"
+	liveFilesPath   = "./test_data/test_live_files"
+)
+
+func TestLiveFilesHoldDeletion(t *testing.T) {
+	_ = os.RemoveAll(liveFilesDBPath)
+	_ = os.RemoveAll(liveFilesPath)
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10}}
+	db, err := OpenWithOptions(liveFilesDBPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = db.Set(key, key, nil)
+	}
+
+	live, err := db.GetLiveFiles(liveFilesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(live.Tables) == 0 {
+		t.Fatal("expected live tables")
+	}
+	if _, err := os.Stat(utils.CurrentFileName(liveFilesPath)); err != nil {
+		t.Fatalf("expected CURRENT in private dir: %v", err)
+	}
+
+	// 持有期间Compaction淘汰的文件不会被删除
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = db.Set(key, []byte("new"), nil)
+	}
+	if err := db.CompactRange(nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range live.Tables {
+		if stat, err := os.Stat(filepath.Join(live.Dir, table.Path)); err != nil || stat.Size() != table.Size {
+			t.Fatalf("table %s removed while held: %v", table.Path, err)
+		}
+	}
+
+	// 释放后删除被淘汰的文件
+	live.Release()
+	live.Release()
+	removed := 0
+	for _, table := range live.Tables {
+		if _, err := os.Stat(filepath.Join(live.Dir, table.Path)); os.IsNotExist(err) {
+			removed++
+		}
+	}
+	if removed == 0 {
+		t.Fatal("expected obsolete tables removed after Release")
+	}
+	if value, err := db.Get([]byte("key00001"), nil); err != nil || string(value) != "new" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+}
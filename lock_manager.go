@@ -0,0 +1,100 @@
+package yldb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+)
+
+// 悲观事务使用的行锁管理器
+// 每个key至多被一个事务持有，等待关系记录在waitFor中用于死锁检测
+type lockManager struct {
+	mutex sync.Mutex
+	locks map[string]*keyLock
+	// 事务ID -> 该事务正在等待的锁持有者事务ID
+	// 每个事务同一时刻至多等待一把锁，因此等待图中每个节点至多一条出边
+	waitFor map[uint64]uint64
+}
+
+type keyLock struct {
+	owner uint64
+	// 锁释放时close，唤醒所有等待者
+	released chan struct{}
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		locks:   make(map[string]*keyLock),
+		waitFor: make(map[uint64]uint64),
+	}
+}
+
+// 事务txnID对key加锁，已持有时直接返回
+// 若等待会形成环则返回ErrTxnDeadlock，等待超过timeout则返回ErrTxnLockTimeout
+func (lm *lockManager) lock(txnID uint64, key []byte, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		lm.mutex.Lock()
+		l, exist := lm.locks[string(key)]
+		if !exist {
+			lm.locks[string(key)] = &keyLock{
+				owner:    txnID,
+				released: make(chan struct{}),
+			}
+			lm.mutex.Unlock()
+			return nil
+		}
+		if l.owner == txnID {
+			lm.mutex.Unlock()
+			return nil
+		}
+		if lm.wouldDeadlock(txnID, l.owner) {
+			lm.mutex.Unlock()
+			return errors.ErrTxnDeadlock
+		}
+		lm.waitFor[txnID] = l.owner
+		released := l.released
+		lm.mutex.Unlock()
+
+		select {
+		case <-released:
+			lm.mutex.Lock()
+			delete(lm.waitFor, txnID)
+			lm.mutex.Unlock()
+		case <-timer.C:
+			lm.mutex.Lock()
+			delete(lm.waitFor, txnID)
+			lm.mutex.Unlock()
+			return errors.ErrTxnLockTimeout
+		}
+	}
+}
+
+// 沿等待图从holder出发，若能回到txnID则说明txnID等待holder会形成死锁
+// 调用方需持有lm.mutex
+func (lm *lockManager) wouldDeadlock(txnID, holder uint64) bool {
+	for steps := 0; steps <= len(lm.waitFor); steps++ {
+		if holder == txnID {
+			return true
+		}
+		next, waiting := lm.waitFor[holder]
+		if !waiting {
+			return false
+		}
+		holder = next
+	}
+	return false
+}
+
+// 释放事务txnID持有的key锁，未持有时忽略
+func (lm *lockManager) unlock(txnID uint64, key []byte) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	if l, exist := lm.locks[string(key)]; exist && l.owner == txnID {
+		delete(lm.locks, string(key))
+		close(l.released)
+	}
+}
@@ -0,0 +1,211 @@
+package yldb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+// MANIFEST文件记录所有列族的Version以及WAL的持久化进度，格式为：
+// - 默认列族的Version（旧版本的MANIFEST只包含这一部分）
+// - 8字节：WAL编号，编号更小的WAL中的数据都已持久化到SST文件中
+// - 4字节：下一个可分配的列族ID
+// - 默认列族的比较器名称
+// - 4字节：非默认列族的数量
+// - 每个非默认列族：4字节ID、名称、比较器名称、Version
+// - 每个列族（按上面的顺序）：Version中各文件的创建时间（旧版本的MANIFEST没有这一部分）
+// 其中字符串均以4字节长度加内容的方式编码
+
+// 将当前状态写入新的MANIFEST文件并切换CURRENT，调用方需持有db.mutex
+func (db *YLDB) saveManifest() error {
+	number := db.manifestNumber + 1
+	if err := db.writeManifest(db.name, number); err != nil {
+		return err
+	}
+
+	db.SetCurrentFile(number)
+	if db.manifestNumber > 0 {
+		_ = os.Remove(utils.DescriptorFileName(db.name, db.manifestNumber))
+	}
+	db.manifestNumber = number
+	return nil
+}
+
+// 将当前状态写入dir目录下编号为number的MANIFEST文件，调用方需持有db.mutex
+func (db *YLDB) writeManifest(dir string, number uint64) error {
+	fileName := utils.DescriptorFileName(dir, number)
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	var errs []error
+	cfs := db.columnFamilies()
+	for _, cf := range cfs {
+		cf.current.SetLastSeq(db.seq)
+	}
+	errs = append(errs, db.defaultCF.current.EncodeTo(w))
+	errs = append(errs, binary.Write(w, binary.LittleEndian, db.logNumber))
+	errs = append(errs, binary.Write(w, binary.LittleEndian, db.nextCFID))
+	errs = append(errs, writeString(w, db.defaultCF.cmp.Name()))
+	errs = append(errs, binary.Write(w, binary.LittleEndian, int32(len(cfs)-1)))
+	for _, cf := range cfs {
+		if cf.id == defaultColumnFamilyID {
+			continue
+		}
+		errs = append(errs, binary.Write(w, binary.LittleEndian, cf.id))
+		errs = append(errs, writeString(w, cf.name))
+		errs = append(errs, writeString(w, cf.cmp.Name()))
+		errs = append(errs, cf.current.EncodeTo(w))
+	}
+	errs = append(errs, db.defaultCF.current.EncodeFileTimes(w))
+	for _, cf := range cfs {
+		if cf.id != defaultColumnFamilyID {
+			errs = append(errs, cf.current.EncodeFileTimes(w))
+		}
+	}
+	errs = append(errs, w.Flush())
+	errs = append(errs, file.Sync())
+	errs = append(errs, file.Close())
+
+	for _, err := range errs {
+		if err != nil {
+			_ = os.Remove(fileName)
+			return err
+		}
+	}
+	return nil
+}
+
+// MANIFEST中记录的数据库状态
+type manifestState struct {
+	logNumber uint64
+	nextCFID  uint32
+	seq       uint64
+	// 所有列族，第一个为默认列族
+	cfs []*ColumnFamily
+}
+
+// 从MANIFEST文件中恢复所有列族，调用方需持有db.mutex
+func (db *YLDB) loadManifest(number uint64, opts *utils.Options) error {
+	state, err := decodeManifest(utils.DescriptorFileName(db.primaryDir(), number), db.name, opts)
+	if err != nil {
+		return err
+	}
+	db.manifestNumber = number
+	db.logNumber = state.logNumber
+	db.nextCFID = state.nextCFID
+	db.seq = state.seq
+	db.defaultCF = state.cfs[0]
+	for _, cf := range state.cfs {
+		db.cfs[cf.id] = cf
+	}
+	return nil
+}
+
+// 解析MANIFEST文件，列族的SST文件位于dbName目录下
+func decodeManifest(fileName, dbName string, opts *utils.Options) (*manifestState, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	def := newColumnFamily(dbName, defaultColumnFamilyID, utils.DefaultColumnFamilyName,
+		opts.GetColumnFamilyOptions(utils.DefaultColumnFamilyName))
+	if err := def.current.DecodeFrom(r); err != nil {
+		return nil, err
+	}
+	state := &manifestState{
+		nextCFID: defaultColumnFamilyID + 1,
+		seq:      def.current.LastSeq(),
+		cfs:      []*ColumnFamily{def},
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &state.logNumber); err == io.EOF {
+		// 旧版本的MANIFEST只有默认列族，也没有WAL
+		return state, nil
+	} else if err != nil {
+		return nil, errors.ErrManifestDecodeError
+	}
+
+	var errs []error
+	var numCFs int32
+	errs = append(errs, binary.Read(r, binary.LittleEndian, &state.nextCFID))
+	cmpName, err := readString(r)
+	errs = append(errs, err)
+	errs = append(errs, binary.Read(r, binary.LittleEndian, &numCFs))
+	for _, err := range errs {
+		if err != nil {
+			return nil, errors.ErrManifestDecodeError
+		}
+	}
+	if cmpName != def.cmp.Name() {
+		return nil, errors.ErrComparatorMismatch
+	}
+
+	for i := 0; i < int(numCFs); i++ {
+		var id uint32
+		if err := binary.Read(r, binary.LittleEndian, &id); err != nil {
+			return nil, errors.ErrManifestDecodeError
+		}
+		name, err := readString(r)
+		if err != nil {
+			return nil, errors.ErrManifestDecodeError
+		}
+		cmpName, err := readString(r)
+		if err != nil {
+			return nil, errors.ErrManifestDecodeError
+		}
+		cf := newColumnFamily(dbName, id, name, opts.GetColumnFamilyOptions(name))
+		if cmpName != cf.cmp.Name() {
+			return nil, errors.ErrComparatorMismatch
+		}
+		if err := cf.current.DecodeFrom(r); err != nil {
+			return nil, err
+		}
+		if seq := cf.current.LastSeq(); seq > state.seq {
+			state.seq = seq
+		}
+		state.cfs = append(state.cfs, cf)
+	}
+
+	for i, cf := range state.cfs {
+		if err := cf.current.DecodeFileTimes(r); err == io.EOF && i == 0 {
+			// 旧版本的MANIFEST没有文件的创建时间
+			break
+		} else if err != nil {
+			return nil, errors.ErrManifestDecodeError
+		}
+	}
+	return state, nil
+}
+
+func writeString(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.LittleEndian, int32(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func readString(r io.Reader) (string, error) {
+	var length int32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+	if length < 0 {
+		return "", errors.ErrManifestDecodeError
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
@@ -21,14 +21,14 @@ func NewMemTable(cmp utils.Comparator) *MemTable {
 	}
 }
 
-func (mem MemTable) Get(key []byte) (value []byte, err error) {
+func (mem *MemTable) Get(key []byte) (value []byte, err error) {
 	mem.mutex.RLock()
 	defer mem.mutex.RUnlock()
 
 	return mem.list.Get(key)
 }
 
-func (mem MemTable) Set(key, value []byte) error {
+func (mem *MemTable) Set(key, value []byte) error {
 	mem.mutex.Lock()
 	defer mem.mutex.Unlock()
 
@@ -39,6 +39,13 @@ func (mem MemTable) Set(key, value []byte) error {
 	return err
 }
 
+// 查找user_key在序列号seq及之前的最新一条记录（包括删除记录）
+func (mem *MemTable) Find(key []byte, seq uint64) (ikey.InternalKey, []byte, error) {
+	mem.mutex.RLock()
+	defer mem.mutex.RUnlock()
+	return mem.list.Find(key, seq)
+}
+
 func (mem *MemTable) Contains(key []byte) bool {
 	mem.mutex.RLock()
 	defer mem.mutex.RUnlock()
@@ -89,6 +96,10 @@ func (it *MemIterator) Prev() {
 	defer it.mem.mutex.RUnlock()
 
 	it.node = it.node.prev()
+	if it.node == it.mem.list.head {
+		// 已经越过第一个节点
+		it.node = nil
+	}
 }
 
 func (it *MemIterator) Seek(target []byte) {
@@ -111,4 +122,8 @@ func (it *MemIterator) SeekToLast() {
 	defer it.mem.mutex.RUnlock()
 
 	it.node = it.mem.list.getLastNode()
+	if it.node == it.mem.list.head {
+		// 跳表为空
+		it.node = nil
+	}
 }
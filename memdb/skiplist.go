@@ -74,6 +74,19 @@ func (s *SkipList) Get(key []byte) ([]byte, error) {
 	return nil, errors.ErrMemTableNotFound
 }
 
+// 返回user_key在序列号seq及之前的最新一条记录，调用方需自行根据kind判断是否为删除记录
+func (s *SkipList) Find(key []byte, seq uint64) (ikey.InternalKey, []byte, error) {
+	lookUpKey := ikey.MakeInternalKey(nil, key, ikey.InternalKeyKindMax, seq)
+	node, _ := s.findGreaterOrEqual(lookUpKey)
+	if node == nil || node.isDelete {
+		return nil, nil, errors.ErrMemTableNotFound
+	}
+	if s.userCmp.Compare(ikey.InternalKey(node.key).UserKey(), key) == 0 {
+		return node.key, node.val, nil
+	}
+	return nil, nil, errors.ErrMemTableNotFound
+}
+
 func (s *SkipList) Set(key, value []byte) error {
 	node, prevNodes := s.findGreaterOrEqual(key)
 
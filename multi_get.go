@@ -0,0 +1,104 @@
+package yldb
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/ikey"
+	"github.com/Cauchy-NY/yldb/memdb"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+// 在默认列族中批量查找多个key，返回的values和errs与keys一一对应
+// 不存在的key对应的error为ErrDBNotFound
+func (db *YLDB) MultiGet(keys [][]byte, opts *utils.ReadOptions) ([][]byte, []error) {
+	return db.MultiGetCF(db.defaultCF, keys, opts)
+}
+
+// 在指定列族中批量查找多个key，所有key在同一个序列号下读取，结果彼此一致
+// 与逐个调用GetCF相比只加锁一次，key排序后依次查询MemTable和ImmTable，
+// 剩余的key按SST文件分组查找，同一文件中的key共用已读取的Data Block
+func (db *YLDB) MultiGetCF(cf *ColumnFamily, keys [][]byte, opts *utils.ReadOptions) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if cf.dropped {
+		for i := range errs {
+			errs[i] = errors.ErrColumnFamilyDropped
+		}
+		return values, errs
+	}
+	seq := db.readSeq(opts)
+
+	// 按user_key排序，重复的key只查找一次
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return cf.cmp.Compare(keys[order[i]], keys[order[j]]) < 0
+	})
+	var pending []int
+	for n, i := range order {
+		if n > 0 && cf.cmp.Compare(keys[i], keys[order[n-1]]) == 0 {
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	now := time.Now().UnixNano()
+	setResult := func(i int, internalKey ikey.InternalKey, value []byte, err error) {
+		if err == nil {
+			var live bool
+			if value, live = ikey.LiveValue(internalKey.Kind(), value, now); !live {
+				err = errors.ErrDBNotFound
+			}
+		}
+		values[i], errs[i] = value, err
+	}
+
+	// 1.先查内存中的MemTable和ImmTable
+	for _, mem := range []*memdb.MemTable{cf.mem, cf.imm} {
+		if mem == nil || len(pending) == 0 {
+			continue
+		}
+		n := 0
+		for _, i := range pending {
+			internalKey, value, err := mem.Find(keys[i], seq)
+			if err == errors.ErrMemTableNotFound {
+				pending[n] = i
+				n++
+				continue
+			}
+			setResult(i, internalKey, value, err)
+		}
+		pending = pending[:n]
+	}
+
+	// 2.再按SST文件分组查询磁盘上的数据
+	if len(pending) > 0 {
+		pendingKeys := make([][]byte, len(pending))
+		for n, i := range pending {
+			pendingKeys[n] = keys[i]
+		}
+		for n, result := range cf.current.MultiFind(pendingKeys, seq) {
+			err := result.Err
+			if err == errors.ErrVersionNotFound {
+				err = errors.ErrDBNotFound
+			}
+			setResult(pending[n], result.InternalKey, result.Value, err)
+		}
+	}
+
+	// 3.重复的key复用第一次查找的结果
+	for n, i := range order {
+		if n > 0 && cf.cmp.Compare(keys[i], keys[order[n-1]]) == 0 {
+			values[i], errs[i] = values[order[n-1]], errs[order[n-1]]
+		}
+	}
+	return values, errs
+}
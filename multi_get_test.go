@@ -0,0 +1,60 @@
+package yldb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var multiGetPath = "./test_data/test_multi_get"
+
+func TestMultiGet(t *testing.T) {
+	_ = os.RemoveAll(multiGetPath)
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10}}
+	db, err := OpenWithOptions(multiGetPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 数据分布在多层SST文件、ImmTable和MemTable中
+	for i := 0; i < 3000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = db.Set(key, []byte(fmt.Sprintf("v1-%05d", i)), nil)
+	}
+	snapshot := db.GetSnapshot()
+	defer db.ReleaseSnapshot(snapshot)
+	for i := 0; i < 3000; i += 3 {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if i%2 == 0 {
+			_ = db.Delete(key, nil)
+		} else {
+			_ = db.Set(key, []byte(fmt.Sprintf("v2-%05d", i)), nil)
+		}
+	}
+
+	var keys [][]byte
+	for i := 2999; i >= 0; i -= 7 {
+		keys = append(keys, []byte(fmt.Sprintf("key%05d", i)))
+	}
+	keys = append(keys, []byte("key00007"), []byte("missing"), []byte("key00007"))
+
+	for _, readOpts := range []*utils.ReadOptions{nil, {Snapshot: snapshot}} {
+		values, errs := db.MultiGet(keys, readOpts)
+		if len(values) != len(keys) || len(errs) != len(keys) {
+			t.Fatalf("got %d values and %d errors for %d keys", len(values), len(errs), len(keys))
+		}
+		for i, key := range keys {
+			want, wantErr := db.Get(key, readOpts)
+			if errs[i] != wantErr || string(values[i]) != string(want) {
+				t.Fatalf("MultiGet(%s) = (%q, %v), want (%q, %v)", key, values[i], errs[i], want, wantErr)
+			}
+		}
+	}
+	if _, errs := db.MultiGet([][]byte{[]byte("key00000")}, nil); errs[0] != errors.ErrDBNotFound {
+		t.Fatalf("deleted key: got %v", errs[0])
+	}
+}
@@ -0,0 +1,46 @@
+package yldb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var rateLimiterPath = "./test_data/test_rate_limiter"
+
+func TestRateLimiter(t *testing.T) {
+	_ = os.RemoveAll(rateLimiterPath)
+	limiter := utils.NewRateLimiter(64 << 20)
+	opts := &utils.Options{
+		ColumnFamilyOptions: utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10},
+		RateLimiter:         limiter,
+	}
+	db, err := OpenWithOptions(rateLimiterPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = db.Set(key, key, nil)
+	}
+	if err := db.CompactRange(nil, nil, &utils.CompactRangeOptions{ForceBottommost: true}); err != nil {
+		t.Fatal(err)
+	}
+	// flush以高优先级写入，Compaction以低优先级写入
+	if limiter.GetTotalBytesThrough(utils.IOPriorityHigh) == 0 {
+		t.Fatal("expected flushes to go through the rate limiter")
+	}
+	if limiter.GetTotalBytesThrough(utils.IOPriorityLow) == 0 {
+		t.Fatal("expected compactions to go through the rate limiter")
+	}
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if value, err := db.Get(key, nil); err != nil || string(value) != string(key) {
+			t.Fatalf("Get(%s) = (%q, %v)", key, value, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package yldb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/errors"
+)
+
+var readOnlyPath = "./test_data/test_read_only"
+
+// 返回目录下所有文件的名称、大小和修改时间
+func dirState(t *testing.T, dir string) string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state []string
+	for _, info := range infos {
+		state = append(state, fmt.Sprintf("%s/%d/%v", info.Name(), info.Size(), info.ModTime()))
+	}
+	return strings.Join(state, ",")
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	_ = os.RemoveAll(readOnlyPath)
+	if _, err := OpenReadOnly(readOnlyPath, nil); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+	if _, err := os.Stat(readOnlyPath); !os.IsNotExist(err) {
+		t.Fatal("OpenReadOnly created the database directory")
+	}
+
+	db, err := Open(readOnlyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, _ := db.CreateColumnFamily("cf", nil)
+	_ = db.Set([]byte("a"), []byte("1"), nil)
+	_ = db.Set([]byte("b"), []byte("2"), nil)
+	_ = db.SetCF(cf, []byte("c"), []byte("3"), nil)
+	db.Close()
+
+	before := dirState(t, readOnlyPath)
+	db, err = OpenReadOnly(readOnlyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// WAL中的数据回放到内存中
+	if value, err := db.Get([]byte("a"), nil); err != nil || string(value) != "1" {
+		t.Fatalf("Get(a) = %q, %v", value, err)
+	}
+	cf = db.GetColumnFamily("cf")
+	if value, err := db.GetCF(cf, []byte("c"), nil); err != nil || string(value) != "3" {
+		t.Fatalf("GetCF(c) = %q, %v", value, err)
+	}
+	if got := strings.Join(collect(db.NewIterator(nil), false), ","); got != "a=1,b=2" {
+		t.Fatalf("iterate: %s", got)
+	}
+
+	if err := db.Set([]byte("a"), []byte("x"), nil); err != errors.ErrDBReadOnly {
+		t.Fatalf("Set: expected ErrDBReadOnly, got %v", err)
+	}
+	if err := db.DeleteCF(cf, []byte("c"), nil); err != errors.ErrDBReadOnly {
+		t.Fatalf("DeleteCF: expected ErrDBReadOnly, got %v", err)
+	}
+	if _, err := db.CreateColumnFamily("other", nil); err != errors.ErrDBReadOnly {
+		t.Fatalf("CreateColumnFamily: expected ErrDBReadOnly, got %v", err)
+	}
+	if err := db.DropColumnFamily(cf); err != errors.ErrDBReadOnly {
+		t.Fatalf("DropColumnFamily: expected ErrDBReadOnly, got %v", err)
+	}
+	txn := db.BeginTransaction(nil)
+	_ = txn.Set([]byte("a"), []byte("x"))
+	if err := txn.Commit(nil); err != errors.ErrDBReadOnly {
+		t.Fatalf("Commit: expected ErrDBReadOnly, got %v", err)
+	}
+	db.Close()
+
+	if after := dirState(t, readOnlyPath); after != before {
+		t.Fatalf("read-only open modified files:\nbefore: %s\nafter:  %s", before, after)
+	}
+}
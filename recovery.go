@@ -0,0 +1,132 @@
+package yldb
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+	"github.com/Cauchy-NY/yldb/wal"
+)
+
+// 返回数据库目录下编号不小于db.logNumber的WAL编号，按从小到大排序
+func (db *YLDB) liveLogNumbers() ([]uint64, error) {
+	infos, err := ioutil.ReadDir(db.primaryDir())
+	if err != nil {
+		return nil, err
+	}
+	var numbers []uint64
+	for _, info := range infos {
+		fileType, number, ok := utils.ParseFileName(info.Name())
+		if ok && fileType == utils.LogFile && number >= db.logNumber {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool {
+		return numbers[i] < numbers[j]
+	})
+	return numbers, nil
+}
+
+// 按编号顺序把尚未持久化到SST文件中的WAL回放到MemTable，调用方需持有db.mutex
+// 回放后旧的WAL仍然保留，直到下一次flush完成后才会被删除
+// 从库模式下从上次回放到的位置继续读取
+func (db *YLDB) recoverLogs() error {
+	numbers, err := db.liveLogNumbers()
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		offset, err := db.replayLog(number, db.logOffsets[number])
+		if err != nil {
+			return err
+		}
+		if db.logOffsets != nil {
+			db.logOffsets[number] = offset
+		}
+		if number >= db.nextLogNumber {
+			db.nextLogNumber = number + 1
+		}
+	}
+	return nil
+}
+
+// 从offset处开始回放WAL，返回最后一条完整记录末尾的偏移
+func (db *YLDB) replayLog(number uint64, offset int64) (int64, error) {
+	file, err := os.Open(utils.LogFileName(db.primaryDir(), number))
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	r := wal.NewReader(file)
+	for {
+		record, err := r.ReadRecord()
+		if err == io.EOF || err == errors.ErrWALTruncated {
+			// 尾部不完整的记录是崩溃时尚未写完的，该Batch没有写入成功（从库模式下也可能是主库正在写入）
+			return offset + r.Offset(), nil
+		}
+		if err != nil {
+			return offset + r.Offset(), err
+		}
+		if err := db.replayBatch(record); err != nil {
+			return offset + r.Offset(), err
+		}
+	}
+}
+
+// 将一条WAL记录中的Batch写入MemTable，调用方需持有db.mutex
+func (db *YLDB) replayBatch(record []byte) error {
+	var batch Batch
+	if err := batch.SetRepr(record); err != nil {
+		return err
+	}
+	if batch.Count() == 0 {
+		return nil
+	}
+	inserter := &memTableInserter{db: db, seq: batch.SeqNum(), ignoreMissing: true}
+	if err := batch.Iterate(inserter); err != nil {
+		return err
+	}
+	if last := inserter.seq - 1; last > db.seq {
+		db.seq = last
+	}
+	return nil
+}
+
+// 创建新的WAL，之后的写入都追加到新文件中，调用方需持有db.mutex
+func (db *YLDB) switchLog() error {
+	number := db.nextLogNumber
+	w, err := wal.Create(utils.LogFileName(db.name, number))
+	if err != nil {
+		return err
+	}
+	db.nextLogNumber++
+	if db.log != nil {
+		_ = db.log.Close()
+	}
+	db.log = w
+	db.logFileNumber = number
+	return nil
+}
+
+// 删除编号小于db.logNumber且超过保留时间的WAL，调用方需持有db.mutex
+func (db *YLDB) deleteObsoleteLogs() {
+	infos, err := ioutil.ReadDir(db.name)
+	if err != nil {
+		return
+	}
+	ttl := db.opts.GetWALTTL()
+	for _, info := range infos {
+		fileType, number, ok := utils.ParseFileName(info.Name())
+		if ok && fileType == utils.LogFile && number < db.logNumber && time.Since(info.ModTime()) >= ttl {
+			_ = os.Remove(utils.LogFileName(db.name, number))
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package replication
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Cauchy-NY/yldb"
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+// 在dir目录下创建主库的Checkpoint，之后用Open打开该目录即可作为从库开始同步
+// 从库的同步位置即为其LastSequence，不需要额外记录
+func Bootstrap(transport Transport, dir string) error {
+	return transport.FetchCheckpoint(dir)
+}
+
+// Follower 从主库拉取已提交的Batch并以相同的序列号写入从库
+// 已同步的位置就是从库的LastSequence，与数据一起通过WAL和MANIFEST持久化，重启后从该位置继续同步
+// 从库不应再接受其他写入，否则序列号将与主库不一致；主库中列族的创建和删除不会被同步
+type Follower struct {
+	transport Transport
+	opts      *utils.WriteOptions
+	// 由OpenFollower打开时为从库的目录和打开从库的配置，用于重新Bootstrap
+	dir    string
+	dbOpts *utils.Options
+
+	mutex sync.Mutex
+	db    *yldb.YLDB
+	stop  chan struct{}
+	done  chan struct{}
+	err   error
+}
+
+// opts为从库写入时使用的配置，Sync为true时每个Batch都在刷盘后才认为已同步
+// 主库不再保留所需的WAL时CatchUp返回ErrWALNotRetained，需要由调用方重新Bootstrap
+func NewFollower(db *yldb.YLDB, transport Transport, opts *utils.WriteOptions) *Follower {
+	return &Follower{db: db, transport: transport, opts: opts}
+}
+
+// 以dbOpts打开dir目录中的从库，dir不存在时先从主库Bootstrap
+// 主库不再保留所需的WAL时（如从库断开过久，或主库导入了不经过WAL的外部文件），
+// CatchUp会关闭从库，用主库新的Checkpoint替换dir目录后重新打开，之后需要通过DB获取新打开的从库
+func OpenFollower(dir string, dbOpts *utils.Options, transport Transport, opts *utils.WriteOptions) (*Follower, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := bootstrapInto(transport, dir); err != nil {
+			return nil, err
+		}
+	}
+	db, err := yldb.OpenWithOptions(dir, dbOpts)
+	if err != nil {
+		return nil, err
+	}
+	follower := NewFollower(db, transport, opts)
+	follower.dir = dir
+	follower.dbOpts = dbOpts
+	return follower, nil
+}
+
+// 先在临时目录中创建Checkpoint，完整之后再改名为dir，避免中途失败时留下不完整的从库
+func bootstrapInto(transport Transport, dir string) error {
+	tmp, err := fetchCheckpoint(transport, dir)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// 在dir旁边的临时目录中创建主库的Checkpoint，返回该临时目录
+func fetchCheckpoint(transport Transport, dir string) (string, error) {
+	tmp := dir + ".bootstrap"
+	_ = os.RemoveAll(tmp)
+	if err := Bootstrap(transport, tmp); err != nil {
+		return "", err
+	}
+	return tmp, nil
+}
+
+// 返回当前的从库，重新Bootstrap之后为新打开的从库
+func (f *Follower) DB() *yldb.YLDB {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.db
+}
+
+// 返回从库已同步到的序列号
+func (f *Follower) AppliedSeq() uint64 {
+	return f.DB().LastSequence()
+}
+
+// 拉取并写入主库中所有尚未同步的Batch，返回写入的Batch数量
+// 由OpenFollower打开时，主库不再保留所需的WAL会使从库重新Bootstrap，之后继续同步
+func (f *Follower) CatchUp() (int, error) {
+	applied := 0
+	rebootstrapped := false
+	for {
+		db := f.DB()
+		batches, err := f.transport.Fetch(db.LastSequence(), defaultMaxBatches)
+		if err == errors.ErrWALNotRetained && f.dir != "" && !rebootstrapped {
+			// 每次CatchUp至多重新Bootstrap一次，避免主库的WAL保留时间过短时反复Bootstrap
+			if err := f.rebootstrap(); err != nil {
+				return applied, err
+			}
+			rebootstrapped = true
+			continue
+		}
+		if err != nil {
+			return applied, err
+		}
+		if len(batches) == 0 {
+			return applied, nil
+		}
+		for _, batch := range batches {
+			if err := db.WriteReplicated(batch, f.opts); err != nil {
+				return applied, err
+			}
+			applied++
+		}
+	}
+}
+
+// 拉取主库新的Checkpoint替换从库的目录并重新打开，拉取期间原来的从库仍可读取
+func (f *Follower) rebootstrap() error {
+	tmp, err := fetchCheckpoint(f.transport, f.dir)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.db.Close()
+	if err := os.RemoveAll(f.dir); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.dir); err != nil {
+		return err
+	}
+	db, err := yldb.OpenWithOptions(f.dir, f.dbOpts)
+	if err != nil {
+		return err
+	}
+	f.db = db
+	return nil
+}
+
+// 在后台每隔interval同步一次，直到调用Stop或同步出错
+func (f *Follower) Start(interval time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.stop != nil {
+		return
+	}
+	f.stop = make(chan struct{})
+	f.done = make(chan struct{})
+	f.err = nil
+	go f.run(interval, f.stop, f.done)
+}
+
+func (f *Follower) run(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := f.CatchUp(); err != nil {
+			f.mutex.Lock()
+			f.err = err
+			f.mutex.Unlock()
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 停止后台同步，返回后台同步中遇到的错误
+func (f *Follower) Stop() error {
+	f.mutex.Lock()
+	stop, done := f.stop, f.done
+	f.stop, f.done = nil, nil
+	f.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.err
+}
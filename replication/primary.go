@@ -0,0 +1,74 @@
+package replication
+
+import (
+	"github.com/Cauchy-NY/yldb"
+)
+
+// 单次Fetch默认最多返回的Batch数量
+const defaultMaxBatches = 1024
+
+// Transport 是从库拉取主库数据的通道
+type Transport interface {
+	// 按序列号顺序返回主库中序列号大于seq的至多max个Batch，没有新的写入时返回空
+	// 主库已不再保留所需的WAL时返回ErrWALNotRetained，此时从库需要重新Bootstrap
+	Fetch(seq uint64, max int) ([]yldb.Batch, error)
+	// 在dir目录下创建主库的Checkpoint，dir不能已经存在
+	FetchCheckpoint(dir string) error
+	Close() error
+}
+
+// Primary 将主库已提交的Batch按序列号顺序提供给从库，数据直接读取自主库保留的WAL
+// 主库需要设置足够长的Options.WALTTL，保证从库短暂断开后仍能从WAL中继续同步
+type Primary struct {
+	db *yldb.YLDB
+}
+
+func NewPrimary(db *yldb.YLDB) *Primary {
+	return &Primary{db: db}
+}
+
+func (p *Primary) Fetch(seq uint64, max int) ([]yldb.Batch, error) {
+	if max <= 0 {
+		max = defaultMaxBatches
+	}
+	it, err := p.db.GetUpdatesSince(seq)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var batches []yldb.Batch
+	for ; it.Valid() && len(batches) < max; it.Next() {
+		batches = append(batches, it.Batch())
+	}
+	return batches, it.Err()
+}
+
+func (p *Primary) FetchCheckpoint(dir string) error {
+	return p.db.Checkpoint(dir)
+}
+
+//---------------------------------LocalTransport---------------------------------
+
+// LocalTransport 直接调用同一进程中的Primary
+type LocalTransport struct {
+	primary *Primary
+}
+
+func NewLocalTransport(primary *Primary) *LocalTransport {
+	return &LocalTransport{primary: primary}
+}
+
+func (t *LocalTransport) Fetch(seq uint64, max int) ([]yldb.Batch, error) {
+	return t.primary.Fetch(seq, max)
+}
+
+func (t *LocalTransport) FetchCheckpoint(dir string) error {
+	return t.primary.FetchCheckpoint(dir)
+}
+
+func (t *LocalTransport) Close() error {
+	return nil
+}
+
+//---------------------------------LocalTransport---------------------------------
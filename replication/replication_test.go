@@ -0,0 +1,266 @@
+package replication
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Cauchy-NY/yldb"
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var (
+	primaryPath  = "../test_data/test_replication_primary"
+	followerPath = "../test_data/test_replication_follower"
+)
+
+func openPrimary(t *testing.T, walTTL time.Duration) *yldb.YLDB {
+	_ = os.RemoveAll(primaryPath)
+	_ = os.RemoveAll(followerPath)
+	opts := &utils.Options{
+		ColumnFamilyOptions: utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10},
+		WALTTL:              walTTL,
+	}
+	db, err := yldb.OpenWithOptions(primaryPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func writeRange(db *yldb.YLDB, from, to int, prefix string) {
+	for i := from; i < to; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = db.Set(key, []byte(prefix+string(key)), nil)
+	}
+}
+
+// 校验从库与主库的数据和序列号一致
+func checkReplica(t *testing.T, primary, follower *yldb.YLDB, n int) {
+	if primary.LastSequence() != follower.LastSequence() {
+		t.Fatalf("follower seq = %d, primary seq = %d", follower.LastSequence(), primary.LastSequence())
+	}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		want, wantErr := primary.Get(key, nil)
+		got, err := follower.Get(key, nil)
+		if err != wantErr || string(got) != string(want) {
+			t.Fatalf("Get(%s) = (%q, %v), want (%q, %v)", key, got, err, want, wantErr)
+		}
+	}
+}
+
+func TestLocalReplication(t *testing.T) {
+	primary := openPrimary(t, time.Hour)
+	defer primary.Close()
+	cf, _ := primary.CreateColumnFamily("cf", nil)
+	writeRange(primary, 0, 500, "v1-")
+
+	transport := NewLocalTransport(NewPrimary(primary))
+	if err := Bootstrap(transport, followerPath); err != nil {
+		t.Fatal(err)
+	}
+	db, err := yldb.Open(followerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReplica(t, primary, db, 500)
+
+	// 主库的后续写入以相同的序列号同步到从库
+	writeRange(primary, 250, 1000, "v2-")
+	var batch yldb.Batch
+	batch.Delete([]byte("key00001"))
+	batch.SetCF(cf, []byte("a"), []byte("b"))
+	_ = primary.Apply(batch, nil)
+	follower := NewFollower(db, transport, nil)
+	if n, err := follower.CatchUp(); err != nil || n != 751 {
+		t.Fatalf("CatchUp = %d, %v", n, err)
+	}
+	checkReplica(t, primary, db, 1000)
+	if value, err := db.GetCF(db.GetColumnFamily("cf"), []byte("a"), nil); err != nil || string(value) != "b" {
+		t.Fatalf("GetCF(a) = %q, %v", value, err)
+	}
+
+	// 重启后从已持久化的位置继续同步
+	db.Close()
+	writeRange(primary, 0, 100, "v3-")
+	db, err = yldb.Open(followerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	follower = NewFollower(db, transport, &utils.WriteOptions{Sync: true})
+	if n, err := follower.CatchUp(); err != nil || n != 100 {
+		t.Fatalf("CatchUp after restart = %d, %v", n, err)
+	}
+	checkReplica(t, primary, db, 1000)
+
+	if err := db.WriteReplicated(batch, nil); err != errors.ErrReplicationSeqMismatch {
+		t.Fatalf("expected ErrReplicationSeqMismatch, got %v", err)
+	}
+}
+
+func TestTCPReplication(t *testing.T) {
+	primary := openPrimary(t, time.Hour)
+	defer primary.Close()
+	writeRange(primary, 0, 500, "v1-")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go NewPrimary(primary).Serve(listener)
+
+	transport, err := Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	if err := Bootstrap(transport, followerPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := Bootstrap(transport, followerPath); err != errors.ErrCheckpointDirExists {
+		t.Fatalf("expected ErrCheckpointDirExists, got %v", err)
+	}
+	db, err := yldb.Open(followerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkReplica(t, primary, db, 500)
+
+	follower := NewFollower(db, transport, nil)
+	follower.Start(time.Millisecond)
+	writeRange(primary, 0, 1000, "v2-")
+	deadline := time.Now().Add(5 * time.Second)
+	for follower.AppliedSeq() < primary.LastSequence() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := follower.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	checkReplica(t, primary, db, 1000)
+}
+
+func TestReplicationNotRetained(t *testing.T) {
+	primary := openPrimary(t, 0)
+	defer primary.Close()
+	writeRange(primary, 0, 1000, "")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go NewPrimary(primary).Serve(listener)
+	transport, err := Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+
+	// 主库的WAL已删除，新的从库需要先Bootstrap
+	db, err := yldb.Open(followerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := NewFollower(db, transport, nil).CatchUp(); err != errors.ErrWALNotRetained {
+		t.Fatalf("expected ErrWALNotRetained, got %v", err)
+	}
+	if _, err := NewFollower(db, NewLocalTransport(NewPrimary(primary)), nil).CatchUp(); err != errors.ErrWALNotRetained {
+		t.Fatalf("expected ErrWALNotRetained, got %v", err)
+	}
+}
+
+// 主库导入外部文件之后，由OpenFollower打开的从库重新Bootstrap并继续同步
+func TestFollowerRebootstrap(t *testing.T) {
+	primary := openPrimary(t, time.Hour)
+	defer primary.Close()
+	writeRange(primary, 0, 500, "v1-")
+	transport := NewLocalTransport(NewPrimary(primary))
+	follower, err := OpenFollower(followerPath, nil, transport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		follower.DB().Close()
+	}()
+	checkReplica(t, primary, follower.DB(), 500)
+
+	sst := primaryPath + ".sst"
+	defer os.Remove(sst)
+	w, err := yldb.NewSSTWriter(sst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 500; i < 600; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = w.Set(key, []byte("ingested-"+string(key)))
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.IngestExternalFiles([]string{sst}); err != nil {
+		t.Fatal(err)
+	}
+	writeRange(primary, 600, 700, "v2-")
+
+	// NewFollower创建的Follower不会重新Bootstrap，由调用方处理
+	old := follower.DB()
+	if _, err := NewFollower(old, transport, nil).CatchUp(); err != errors.ErrWALNotRetained {
+		t.Fatalf("expected ErrWALNotRetained, got %v", err)
+	}
+	if _, err := follower.CatchUp(); err != nil {
+		t.Fatal(err)
+	}
+	if follower.DB() == old {
+		t.Fatal("expected the follower to be bootstrapped again")
+	}
+	checkReplica(t, primary, follower.DB(), 700)
+
+}
+
+// 响应中的Batch数量超过请求的数量或响应不完整时关闭连接，之后的请求都返回错误
+func TestTCPTransportBrokenResponse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	responses := [][]byte{
+		{statusOK, 0xff, 0xff, 0xff, 0xff},
+		{statusOK, 1, 0, 0, 0, 10, 0, 0, 0, 'a', 'b'},
+	}
+	go func() {
+		for _, response := range responses {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			request := make([]byte, 13)
+			_, _ = io.ReadFull(conn, request)
+			_, _ = conn.Write(response)
+			_ = conn.Close()
+		}
+	}()
+
+	for i, want := range []error{errors.ErrReplicationProtocol, io.ErrUnexpectedEOF} {
+		transport, err := Dial(listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := transport.Fetch(0, 0); err != want {
+			t.Fatalf("%d: expected %v, got %v", i, want, err)
+		}
+		if _, err := transport.Fetch(0, 0); err != want {
+			t.Fatalf("%d: expected the broken connection to return %v, got %v", i, want, err)
+		}
+		_ = transport.Close()
+	}
+}
@@ -0,0 +1,379 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Cauchy-NY/yldb"
+	"github.com/Cauchy-NY/yldb/errors"
+)
+
+// TCP协议为请求-响应模式，一个连接上的请求依次处理，所有整数均为小端模式
+// 请求：
+// - Fetch：1字节'F'，8字节seq，4字节max
+// - FetchCheckpoint：1字节'C'
+// 响应首字节为状态，statusError之后为4字节长度加错误信息
+// - Fetch成功：4字节Batch数量，每个Batch为4字节长度加Batch编码
+// - FetchCheckpoint成功：4字节文件数量，每个文件为4字节长度加相对路径、8字节长度加文件内容
+const (
+	opFetch      byte = 'F'
+	opCheckpoint byte = 'C'
+
+	statusOK          byte = 0
+	statusError       byte = 1
+	statusNotRetained byte = 2
+
+	// 限制单个字段的长度，避免错误的数据导致分配过多内存
+	maxFieldLen = 1 << 30
+)
+
+// 在listener上为从库提供TCP服务，直到listener被关闭
+func (p *Primary) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go p.serveConn(conn)
+	}
+}
+
+func (p *Primary) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch op {
+		case opFetch:
+			var req struct {
+				Seq uint64
+				Max uint32
+			}
+			if err := binary.Read(r, binary.LittleEndian, &req); err != nil {
+				return
+			}
+			// 限制单次响应的大小，从库会继续拉取剩余的Batch
+			max := int(req.Max)
+			if max > defaultMaxBatches {
+				max = defaultMaxBatches
+			}
+			batches, err := p.Fetch(req.Seq, max)
+			err = writeFetchResponse(w, batches, err)
+			if err != nil {
+				return
+			}
+		case opCheckpoint:
+			if err := p.writeCheckpointResponse(w); err != nil {
+				return
+			}
+		default:
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeStatus(w io.Writer, err error) error {
+	if err == nil {
+		_, err := w.Write([]byte{statusOK})
+		return err
+	}
+	status := statusError
+	if err == errors.ErrWALNotRetained {
+		status = statusNotRetained
+	}
+	if _, err := w.Write([]byte{status}); err != nil {
+		return err
+	}
+	return writeBytes(w, []byte(err.Error()))
+}
+
+func writeFetchResponse(w io.Writer, batches []yldb.Batch, fetchErr error) error {
+	if fetchErr != nil {
+		return writeStatus(w, fetchErr)
+	}
+	if err := writeStatus(w, nil); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(batches))); err != nil {
+		return err
+	}
+	for i := range batches {
+		if err := writeBytes(w, batches[i].Repr()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 在临时目录中创建Checkpoint并把其中的文件依次发送给从库
+func (p *Primary) writeCheckpointResponse(w io.Writer) error {
+	tmp, err := ioutil.TempDir("", "yldb-replication-")
+	if err != nil {
+		return writeStatus(w, err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "checkpoint")
+	if err := p.FetchCheckpoint(dir); err != nil {
+		return writeStatus(w, err)
+	}
+
+	var files []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		return writeStatus(w, err)
+	}
+	if err := writeStatus(w, nil); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(files))); err != nil {
+		return err
+	}
+	for _, path := range files {
+		rel, _ := filepath.Rel(dir, path)
+		if err := writeBytes(w, []byte(filepath.ToSlash(rel))); err != nil {
+			return err
+		}
+		if err := writeFile(w, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint64(info.Size())); err != nil {
+		return err
+	}
+	_, err = io.CopyN(w, file, info.Size())
+	return err
+}
+
+func writeBytes(w io.Writer, b []byte) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readBytes(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxFieldLen {
+		return nil, errors.ErrReplicationProtocol
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//----------------------------------TCPTransport----------------------------------
+
+// TCPTransport 通过TCP连接从远程的Primary拉取数据，同一时刻只处理一个请求
+// 请求或响应读写到一半出错时连接上可能残留未读完的数据，连接被关闭，之后的请求都返回该错误
+type TCPTransport struct {
+	mutex sync.Mutex
+	conn  net.Conn
+	r     *bufio.Reader
+	w     *bufio.Writer
+	err   error
+}
+
+func Dial(addr string) (*TCPTransport, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPTransport{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+// 单次至多拉取defaultMaxBatches个Batch，主库返回的Batch数量超过请求的数量时视为协议错误
+func (t *TCPTransport) Fetch(seq uint64, max int) ([]yldb.Batch, error) {
+	if max <= 0 || max > defaultMaxBatches {
+		max = defaultMaxBatches
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.err != nil {
+		return nil, t.err
+	}
+	if err := t.w.WriteByte(opFetch); err != nil {
+		return nil, t.broken(err)
+	}
+	if err := binary.Write(t.w, binary.LittleEndian, seq); err != nil {
+		return nil, t.broken(err)
+	}
+	if err := binary.Write(t.w, binary.LittleEndian, uint32(max)); err != nil {
+		return nil, t.broken(err)
+	}
+	if err := t.w.Flush(); err != nil {
+		return nil, t.broken(err)
+	}
+	if err := t.readStatus(); err != nil {
+		return nil, err
+	}
+
+	var count uint32
+	if err := binary.Read(t.r, binary.LittleEndian, &count); err != nil {
+		return nil, t.broken(err)
+	}
+	if count > uint32(max) {
+		return nil, t.broken(errors.ErrReplicationProtocol)
+	}
+	batches := make([]yldb.Batch, 0, count)
+	for i := uint32(0); i < count; i++ {
+		data, err := readBytes(t.r)
+		if err != nil {
+			return nil, t.broken(err)
+		}
+		var batch yldb.Batch
+		if err := batch.SetRepr(data); err != nil {
+			return nil, t.broken(err)
+		}
+		batches = append(batches, batch)
+	}
+	return batches, nil
+}
+
+func (t *TCPTransport) FetchCheckpoint(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return errors.ErrCheckpointDirExists
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.err != nil {
+		return t.err
+	}
+	if err := t.w.WriteByte(opCheckpoint); err != nil {
+		return t.broken(err)
+	}
+	if err := t.w.Flush(); err != nil {
+		return t.broken(err)
+	}
+	if err := t.readStatus(); err != nil {
+		return err
+	}
+	var count uint32
+	if err := binary.Read(t.r, binary.LittleEndian, &count); err != nil {
+		return t.broken(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return t.broken(err)
+	}
+	for i := uint32(0); i < count; i++ {
+		if err := t.readFile(dir); err != nil {
+			_ = os.RemoveAll(dir)
+			return t.broken(err)
+		}
+	}
+	return nil
+}
+
+// 关闭读写到一半出错的连接，之后的请求都返回err，调用方需持有t.mutex
+func (t *TCPTransport) broken(err error) error {
+	if t.err == nil {
+		t.err = err
+		_ = t.conn.Close()
+	}
+	return err
+}
+
+func (t *TCPTransport) readFile(dir string) error {
+	name, err := readBytes(t.r)
+	if err != nil {
+		return err
+	}
+	var size uint64
+	if err := binary.Read(t.r, binary.LittleEndian, &size); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filepath.FromSlash(string(name)))
+	// 文件只能写入dir目录中
+	if rel, err := filepath.Rel(dir, path); err != nil || strings.HasPrefix(rel, "..") {
+		return errors.ErrReplicationProtocol
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(file, t.r, int64(size)); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// 读取响应的状态，主库返回的错误不影响连接的继续使用，调用方需持有t.mutex
+func (t *TCPTransport) readStatus() error {
+	status, err := t.r.ReadByte()
+	if err != nil {
+		return t.broken(err)
+	}
+	switch status {
+	case statusOK:
+		return nil
+	case statusNotRetained:
+		if _, err := readBytes(t.r); err != nil {
+			return t.broken(err)
+		}
+		return errors.ErrWALNotRetained
+	case statusError:
+		msg, err := readBytes(t.r)
+		if err != nil {
+			return t.broken(err)
+		}
+		return fmt.Errorf("%v: %s", errors.ErrReplicationRemote, msg)
+	default:
+		return t.broken(errors.ErrReplicationProtocol)
+	}
+}
+
+func (t *TCPTransport) Close() error {
+	return t.conn.Close()
+}
+
+//----------------------------------TCPTransport----------------------------------
@@ -0,0 +1,153 @@
+package yldb
+
+import (
+	"os"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/memdb"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+// 主库的文件在读取过程中被删除时的最大重试次数
+const maxCatchUpAttempts = 3
+
+// 以从库模式打开primaryDir下的主库，主库可以同时在其他进程中运行
+// 从库只读取主库的CURRENT、MANIFEST和WAL，不会修改主库目录中的任何文件，所有写操作都返回ErrDBReadOnly
+// 主库的SST文件被硬链接到secondaryDir中，这样主库Compaction删除文件后从库仍然可以读取
+// 打开之后主库的新写入不可见，需要调用TryCatchUpWithPrimary同步
+func OpenAsSecondary(primaryDir, secondaryDir string, opts *utils.Options) (*YLDB, error) {
+	if _, err := os.Stat(primaryDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(secondaryDir, 0755); err != nil {
+		return nil, err
+	}
+	db := newDB(secondaryDir, opts)
+	db.readOnly = true
+	db.primary = primaryDir
+	db.logOffsets = make(map[uint64]int64)
+	db.defaultCF = newColumnFamily(secondaryDir, defaultColumnFamilyID, utils.DefaultColumnFamilyName,
+		opts.GetColumnFamilyOptions(utils.DefaultColumnFamilyName))
+	db.defaultCF.current.SetTableCache(db.tableCache)
+	db.cfs[defaultColumnFamilyID] = db.defaultCF
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if err := db.catchUp(true); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// 从主库同步最新的MANIFEST和WAL，之后的读取可以看到主库目前为止的写入
+// 主库的MANIFEST没有变化时只回放WAL中新增的记录，否则重新加载所有列族并回放全部未持久化的WAL
+// 主库中新建的列族可以通过GetColumnFamily获取，已删除的列族被标记为dropped
+func (db *YLDB) TryCatchUpWithPrimary() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.primary == "" {
+		return errors.ErrNotSecondary
+	}
+	if db.closed {
+		return errors.ErrDBClosed
+	}
+	return db.catchUp(false)
+}
+
+// reload为true或主库的MANIFEST有变化时重新加载所有列族，否则只回放WAL中新增的记录，调用方需持有db.mutex
+func (db *YLDB) catchUp(reload bool) error {
+	var err error
+	for attempt := 0; attempt < maxCatchUpAttempts; attempt++ {
+		number := db.ReadCurrentFile()
+		if reload || number != db.manifestNumber {
+			err = db.reloadManifest(number)
+		} else {
+			err = db.recoverLogs()
+		}
+		if err == nil && db.ReadCurrentFile() != number {
+			// 读取期间主库完成了flush，旧的WAL可能已被删除
+			err = os.ErrNotExist
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		// 主库在读取期间切换了MANIFEST或删除了文件，已回放的数据可能不完整，重新加载
+		reload = true
+	}
+	return err
+}
+
+// 重新加载主库的MANIFEST，已存在的列族保持同一个对象，调用方需持有db.mutex
+func (db *YLDB) reloadManifest(number uint64) error {
+	state := &manifestState{
+		nextCFID: defaultColumnFamilyID + 1,
+		cfs: []*ColumnFamily{newColumnFamily(db.name, defaultColumnFamilyID, utils.DefaultColumnFamilyName,
+			db.opts.GetColumnFamilyOptions(utils.DefaultColumnFamilyName))},
+	}
+	if number > 0 {
+		// 主库尚未写入过MANIFEST时所有数据都在WAL中
+		var err error
+		if state, err = decodeManifest(utils.DescriptorFileName(db.primary, number), db.name, db.opts); err != nil {
+			return err
+		}
+	}
+	for _, cf := range state.cfs {
+		cf.current.SetTableCache(db.tableCache)
+		if err := db.linkTables(cf); err != nil {
+			return err
+		}
+	}
+
+	live := make(map[uint32]bool)
+	for _, cf := range state.cfs {
+		live[cf.id] = true
+		if existing, ok := db.cfs[cf.id]; ok {
+			existing.current = cf.current
+			existing.mem = memdb.NewMemTable(existing.cmp)
+			existing.imm = nil
+		} else {
+			db.cfs[cf.id] = cf
+		}
+	}
+	for id, cf := range db.cfs {
+		if !live[id] {
+			cf.dropped = true
+			delete(db.cfs, id)
+			db.tableCache.EvictDir(cf.dir)
+			_ = os.RemoveAll(cf.dir)
+		}
+	}
+	for _, cf := range db.cfs {
+		db.removeObsoleteTables(cf)
+	}
+
+	db.manifestNumber = number
+	db.logNumber = state.logNumber
+	db.nextCFID = state.nextCFID
+	db.seq = state.seq
+	db.logOffsets = make(map[uint64]int64)
+	return db.recoverLogs()
+}
+
+// 将列族当前Version引用的SST文件从主库硬链接到从库目录中
+func (db *YLDB) linkTables(cf *ColumnFamily) error {
+	src := db.primary
+	if cf.id != defaultColumnFamilyID {
+		src = utils.ColumnFamilyDirName(db.primary, cf.id)
+	}
+	if err := os.MkdirAll(cf.dir, 0755); err != nil {
+		return err
+	}
+	for _, number := range cf.current.LiveFiles() {
+		dst := utils.TableFileName(cf.dir, number)
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := linkOrCopyFile(utils.TableFileName(src, number), dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package yldb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var (
+	primaryPath   = "./test_data/test_primary"
+	secondaryPath = "./test_data/test_secondary"
+)
+
+func TestSecondary(t *testing.T) {
+	_ = os.RemoveAll(primaryPath)
+	_ = os.RemoveAll(secondaryPath)
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10}}
+	primary, err := OpenWithOptions(primaryPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	if err := primary.TryCatchUpWithPrimary(); err != errors.ErrNotSecondary {
+		t.Fatalf("expected ErrNotSecondary, got %v", err)
+	}
+
+	// 主库尚未写入MANIFEST，数据只在WAL中
+	_ = primary.Set([]byte("a"), []byte("1"), nil)
+	secondary, err := OpenAsSecondary(primaryPath, secondaryPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondary.Close()
+	if value, err := secondary.Get([]byte("a"), nil); err != nil || string(value) != "1" {
+		t.Fatalf("Get(a) = %q, %v", value, err)
+	}
+	if err := secondary.Set([]byte("a"), []byte("2"), nil); err != errors.ErrDBReadOnly {
+		t.Fatalf("expected ErrDBReadOnly, got %v", err)
+	}
+
+	// 同步之前看不到主库的新写入
+	_ = primary.Set([]byte("a"), []byte("2"), nil)
+	if value, _ := secondary.Get([]byte("a"), nil); string(value) != "1" {
+		t.Fatalf("Get(a) before catch up = %q", value)
+	}
+	if err := secondary.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := secondary.Get([]byte("a"), nil); string(value) != "2" {
+		t.Fatalf("Get(a) after catch up = %q", value)
+	}
+
+	// 主库flush和compaction之后从库仍然可以读到所有数据
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = primary.Set(key, key, nil)
+	}
+	cf, _ := primary.CreateColumnFamily("cf", nil)
+	_ = primary.SetCF(cf, []byte("b"), []byte("3"), nil)
+	if err := secondary.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if value, err := secondary.Get(key, nil); err != nil || string(value) != string(key) {
+			t.Fatalf("Get(%s) = %q, %v", key, value, err)
+		}
+	}
+	secondaryCF := secondary.GetColumnFamily("cf")
+	if secondaryCF == nil {
+		t.Fatal("column family not visible after catch up")
+	}
+	if value, err := secondary.GetCF(secondaryCF, []byte("b"), nil); err != nil || string(value) != "3" {
+		t.Fatalf("GetCF(b) = %q, %v", value, err)
+	}
+
+	_ = primary.DropColumnFamily(cf)
+	if err := secondary.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secondary.GetCF(secondaryCF, []byte("b"), nil); err != errors.ErrColumnFamilyDropped {
+		t.Fatalf("expected ErrColumnFamilyDropped, got %v", err)
+	}
+}
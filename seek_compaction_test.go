@@ -0,0 +1,68 @@
+package yldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/config"
+)
+
+var seekCompactionPath = "./test_data/test_seek_compaction"
+
+func TestSeekCompaction(t *testing.T) {
+	_ = os.RemoveAll(seekCompactionPath)
+	_ = os.RemoveAll(ingestExtPath)
+	_ = os.MkdirAll(ingestExtPath, 0755)
+	db, err := Open(seekCompactionPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 最底层有所有key，其上一层只有奇数key，两个文件的key范围重叠
+	if err := db.IngestExternalFiles([]string{writeExternalFile(t, "all.sst", 0, 1000, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(ingestExtPath, "odd.sst")
+	w, _ := NewSSTWriter(path, nil)
+	for i := 1; i < 1000; i += 2 {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = w.Set(key, []byte("new-"+string(key)))
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.IngestExternalFiles([]string{path}); err != nil {
+		t.Fatal(err)
+	}
+	upper := config.NumLevels - 2
+	if n := db.defaultCF.current.NumLevelFiles(upper); n != 1 {
+		t.Fatalf("expected 1 file in level %d, got %d", upper, n)
+	}
+
+	// 查找偶数key会先读上层文件再读下层文件，上层文件的allowSeeks逐渐减为0
+	for i := 0; i < config.MinAllowSeeks; i++ {
+		_, _ = db.Get([]byte(fmt.Sprintf("key%05d", 2*(i+1))), nil)
+	}
+	db.mutex.Lock()
+	for db.backgroundBusy() || db.defaultCF.current.NeedsSeekCompaction() {
+		db.cond.Wait()
+	}
+	db.mutex.Unlock()
+
+	if n := db.defaultCF.current.NumLevelFiles(upper); n != 0 {
+		t.Fatalf("expected level %d to be compacted, got %d files", upper, n)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		want := "ext-" + string(key)
+		if i%2 == 1 {
+			want = "new-" + string(key)
+		}
+		if value, err := db.Get(key, nil); err != nil || string(value) != want {
+			t.Fatalf("Get(%s) = (%q, %v), want %q", key, value, err, want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package yldb
+
+import "github.com/Cauchy-NY/yldb/utils"
+
+// 获取数据库当前状态的快照，不再使用时需调用ReleaseSnapshot释放
+func (db *YLDB) GetSnapshot() *utils.Snapshot {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	snapshot := utils.NewSnapshot(db.seq)
+	db.snapshots = append(db.snapshots, snapshot)
+	return snapshot
+}
+
+func (db *YLDB) ReleaseSnapshot(snapshot *utils.Snapshot) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for i, s := range db.snapshots {
+		if s == snapshot {
+			db.snapshots = append(db.snapshots[:i], db.snapshots[i+1:]...)
+			return
+		}
+	}
+}
+
+// 返回本次读取可见的最大序列号，调用方需持有db.mutex
+func (db *YLDB) readSeq(opts *utils.ReadOptions) uint64 {
+	if snapshot := opts.GetSnapshot(); snapshot != nil {
+		return snapshot.Seq()
+	}
+	return db.seq
+}
@@ -0,0 +1,75 @@
+package yldb
+
+import (
+	"os"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/ikey"
+	"github.com/Cauchy-NY/yldb/sstable"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+// SSTWriter 在数据库之外生成SST文件，之后可以通过IngestExternalFiles导入数据库
+// key必须按opts中的比较器严格升序写入，文件中所有记录的序列号均为0，导入时再统一分配
+type SSTWriter struct {
+	fileName string
+	builder  *sstable.TableBuilder
+	cmp      utils.Comparator
+	lastKey  []byte
+	count    int
+	closed   bool
+}
+
+// opts中的比较器需要与导入的列族一致
+func NewSSTWriter(fileName string, opts *utils.ColumnFamilyOptions) (*SSTWriter, error) {
+	builder, err := sstable.NewTableBuilder(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &SSTWriter{fileName: fileName, builder: builder, cmp: opts.GetComparator()}, nil
+}
+
+func (w *SSTWriter) Set(key, value []byte) error {
+	return w.add(ikey.InternalKeyKindSet, key, value)
+}
+
+// 写入删除记录，导入后会覆盖数据库中该key更旧的值
+func (w *SSTWriter) Delete(key []byte) error {
+	return w.add(ikey.InternalKeyKindDelete, key, nil)
+}
+
+func (w *SSTWriter) add(kind ikey.InternalKeyKind, key, value []byte) error {
+	if w.closed {
+		return errors.ErrIngestWriterClosed
+	}
+	if w.lastKey != nil && w.cmp.Compare(key, w.lastKey) <= 0 {
+		return errors.ErrIngestKeyOutOfOrder
+	}
+	internalKey := ikey.MakeInternalKey(nil, key, kind, 0)
+	w.lastKey = internalKey.UserKey()
+	w.builder.Add(internalKey, value)
+	w.count++
+	return w.builder.Err()
+}
+
+// 返回已写入的记录数
+func (w *SSTWriter) Count() int {
+	return w.count
+}
+
+// 完成并关闭文件，没有写入任何记录时删除文件并返回ErrIngestEmptyFile
+func (w *SSTWriter) Finish() error {
+	if w.closed {
+		return errors.ErrIngestWriterClosed
+	}
+	w.closed = true
+	w.builder.Finish()
+	if err := w.builder.Err(); err != nil {
+		return err
+	}
+	if w.count == 0 {
+		_ = os.Remove(w.fileName)
+		return errors.ErrIngestEmptyFile
+	}
+	return nil
+}
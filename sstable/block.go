@@ -30,11 +30,11 @@ func newBlock(buf []byte) *block {
 	return &block
 }
 
-func (b *block) iterator() *BlockIterator {
+func (b *block) iterator(cmp utils.Comparator) *BlockIterator {
 	return &BlockIterator{
 		block: b,
 		index: 0,
-		cmp:   utils.NewDefaultComparator(),
+		cmp:   cmp,
 	}
 }
 
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/Cauchy-NY/yldb/ikey"
+	"github.com/Cauchy-NY/yldb/utils"
 )
 
 func TestBlock(t *testing.T) {
@@ -18,7 +19,7 @@ func TestBlock(t *testing.T) {
 	p := builder.finish()
 
 	block := newBlock(p)
-	it := block.iterator()
+	it := block.iterator(utils.NewDefaultComparator())
 
 	it.Seek([]byte("apple"))
 	if it.Valid() {
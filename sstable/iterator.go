@@ -124,7 +124,7 @@ func (it *TableIterator) initDataBlock() {
 		if it.dataIter != nil && it.dataBlockHandle == tmpBlockHandle {
 			// 如果同一个迭代器已经被构建，什么都不需要处理
 		} else {
-			it.dataIter = it.table.readBlock(tmpBlockHandle).iterator()
+			it.dataIter = it.table.readBlock(tmpBlockHandle).iterator(it.cmp)
 			it.dataBlockHandle = tmpBlockHandle
 		}
 	}
@@ -175,12 +175,10 @@ func (it *BlockIterator) Seek(target []byte) {
 			right = mid
 		}
 	}
-	//if left == len(it.block.entrys)-1 {
-	//	if it.cmp.Compare(it.block.entrys[left].Ikey().UserKey(), target) < 0 {
-	//		// not found
-	//		left++
-	//	}
-	//}
+	if right >= 0 && it.cmp.Compare(it.block.entrys[right].Ikey().UserKey(), target) < 0 {
+		// block中所有key都小于target，迭代器置为非法
+		right++
+	}
 	it.index = right
 }
 
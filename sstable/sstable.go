@@ -13,11 +13,18 @@ type SSTable struct {
 	index  *block
 	footer Footer
 	file   *os.File
+	// 文件中user_key的比较器，需要与写入时的顺序一致
+	cmp utils.Comparator
 }
 
-func Open(fileName string) (*SSTable, error) {
+// cmp为nil时使用默认比较器
+func Open(fileName string, cmp utils.Comparator) (*SSTable, error) {
 	var table SSTable
 	var err error
+	table.cmp = cmp
+	if cmp == nil {
+		table.cmp = utils.NewDefaultComparator()
+	}
 	if table.file, err = os.Open(fileName); err != nil {
 		return nil, err
 	}
@@ -56,11 +63,62 @@ func (table *SSTable) Get(key []byte) ([]byte, error) {
 	return nil, errors.ErrSSTableNotFound
 }
 
+// 查找user_key在序列号seq及之前的最新一条记录（包括删除记录）
+func (table *SSTable) Find(key []byte, seq uint64) (ikey.InternalKey, []byte, error) {
+	it := table.Iterator()
+	for it.Seek(key); it.Valid(); it.Next() {
+		internalKey := it.InternalKey()
+		if it.cmp.Compare(key, internalKey.UserKey()) != 0 {
+			break
+		}
+		if internalKey.SeqNum() <= seq {
+			return internalKey, it.Value(), nil
+		}
+	}
+	return nil, nil, errors.ErrSSTableNotFound
+}
+
+// 批量查找多个user_key，keys需按升序排列
+// 所有key共用同一个迭代器，相邻的key落在同一个Data Block时不会重复读取该Block
+// 对每个找到的key调用found，i为key在keys中的下标
+func (table *SSTable) MultiFind(keys [][]byte, seq uint64, found func(i int, internalKey ikey.InternalKey, value []byte)) {
+	it := table.Iterator()
+	for i, key := range keys {
+		for it.Seek(key); it.Valid(); it.Next() {
+			internalKey := it.InternalKey()
+			if it.cmp.Compare(key, internalKey.UserKey()) != 0 {
+				break
+			}
+			if internalKey.SeqNum() <= seq {
+				found(i, internalKey, it.Value())
+				break
+			}
+		}
+	}
+}
+
+// 依次对每个Data Block调用fn，lastKey为该Block中最后一条记录的internal_key，size为Block的大小
+// 只读取内存中的Index Block，可以用于估计某个key范围内的数据量
+func (table *SSTable) DataBlocks(fn func(lastKey ikey.InternalKey, size uint64)) {
+	if table.index == nil {
+		return
+	}
+	it := table.index.iterator(table.cmp)
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		var index indexBlockHandle
+		fn(it.InternalKey(), uint64(index.GetBlockHandle(it.Value()).Size))
+	}
+}
+
+func (table *SSTable) Close() error {
+	return table.file.Close()
+}
+
 func (table *SSTable) Iterator() *TableIterator {
 	return &TableIterator{
 		table:     table,
-		indexIter: table.index.iterator(),
-		cmp:       utils.NewDefaultComparator(),
+		indexIter: table.index.iterator(table.cmp),
+		cmp:       table.cmp,
 	}
 }
 
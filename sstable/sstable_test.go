@@ -39,7 +39,7 @@ func TestSSTable(t *testing.T) {
 
 	var table *SSTable
 	var err error
-	if table, err = Open(fileName); err != nil {
+	if table, err = Open(fileName, nil); err != nil {
 		fmt.Println(err)
 	}
 	fmt.Println(table.footer.IndexHandle.Offset)
@@ -4,6 +4,7 @@ import (
 	"os"
 
 	"github.com/Cauchy-NY/yldb/config"
+	"github.com/Cauchy-NY/yldb/utils"
 )
 
 type TableBuilder struct {
@@ -15,6 +16,11 @@ type TableBuilder struct {
 	pendingIndexEntry  bool
 	pendingIndexHandle indexBlockHandle
 	errs               []error
+	// 上一次同步之后写入的字节数
+	unsynced uint32
+	// 写入每个Block之前向rateLimiter请求，为nil时不限速
+	rateLimiter *utils.RateLimiter
+	priority    utils.IOPriority
 }
 
 func NewTableBuilder(fileName string) (*TableBuilder, error) {
@@ -28,10 +34,24 @@ func NewTableBuilder(fileName string) (*TableBuilder, error) {
 	return &builder, nil
 }
 
+// 设置写入使用的限速器及请求的优先级
+func (builder *TableBuilder) SetRateLimiter(rateLimiter *utils.RateLimiter, priority utils.IOPriority) {
+	builder.rateLimiter = rateLimiter
+	builder.priority = priority
+}
+
 func (builder *TableBuilder) FileSize() uint32 {
 	return builder.offset
 }
 
+// 返回写入过程中遇到的第一个错误
+func (builder *TableBuilder) Err() error {
+	if len(builder.errs) != 0 {
+		return builder.errs[0]
+	}
+	return nil
+}
+
 func (builder *TableBuilder) Add(key, val []byte) {
 	if len(builder.errs) != 0 {
 		return
@@ -69,6 +89,7 @@ func (builder *TableBuilder) Finish() {
 	footer.IndexHandle = builder.writeBlock(&builder.indexBlockBuilder)
 	_ = footer.encodeTo(builder.file)
 
+	builder.sync()
 	if err := builder.file.Close(); err != nil {
 		builder.errs = append(builder.errs, err)
 	}
@@ -97,17 +118,26 @@ func (builder *TableBuilder) writeBlock(blockBuilder *BlockBuilder) BlockHandle
 	}
 	builder.offset += uint32(len(content))
 
+	builder.rateLimiter.Request(len(content), builder.priority)
 	if _, err := builder.file.Write(content); err != nil {
 		builder.errs = append(builder.errs, err)
 	}
-	if err := builder.file.Sync(); err != nil {
-		builder.errs = append(builder.errs, err)
+	builder.unsynced += uint32(len(content))
+	if config.BytesPerSync > 0 && builder.unsynced >= config.BytesPerSync {
+		builder.sync()
 	}
 
 	blockBuilder.Reset()
 	return blockHandle
 }
 
+func (builder *TableBuilder) sync() {
+	if err := builder.file.Sync(); err != nil {
+		builder.errs = append(builder.errs, err)
+	}
+	builder.unsynced = 0
+}
+
 func (builder *TableBuilder) fileSize() uint32 {
 	return builder.offset
 }
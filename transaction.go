@@ -0,0 +1,204 @@
+package yldb
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/ikey"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+// Transaction 将写入缓存在Batch中，提交时原子地写入数据库
+// - 乐观模式：记录读写过的key及其当时的最新序列号，提交时校验这些key未被其他写入修改
+// - 悲观模式：写入（及GetForUpdate）前对key加锁，提交或回滚时释放
+type Transaction struct {
+	db   *YLDB
+	id   uint64
+	opts *utils.TransactionOptions
+	// 事务内尚未提交的写入，带索引以保证事务能读到自身的写入
+	batch *BatchWithIndex
+	// 乐观模式下跟踪的key及其首次访问时的最新序列号，0表示当时key不存在
+	tracked map[string]uint64
+	// 悲观模式下已持有的行锁
+	locked [][]byte
+	done   bool
+}
+
+func (db *YLDB) BeginTransaction(opts *utils.TransactionOptions) *Transaction {
+	return &Transaction{
+		db:      db,
+		id:      atomic.AddUint64(&db.nextTxnID, 1),
+		opts:    opts,
+		batch:   NewBatchWithIndex(db.defaultCF.opts),
+		tracked: make(map[string]uint64),
+	}
+}
+
+func (txn *Transaction) Get(key []byte, opts *utils.ReadOptions) ([]byte, error) {
+	if txn.done {
+		return nil, errors.ErrTxnDone
+	}
+	if kind, value, found := txn.batch.lookup(key); found {
+		if kind == ikey.InternalKeyKindDelete {
+			return nil, errors.ErrDBNotFound
+		}
+		return value, nil
+	}
+
+	db := txn.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	internalKey, value, err := db.find(key, db.readSeq(opts))
+	if err != nil && err != errors.ErrDBNotFound {
+		return nil, err
+	}
+	if !txn.opts.GetPessimistic() {
+		txn.track(key, internalKey)
+	}
+	if internalKey == nil {
+		return nil, errors.ErrDBNotFound
+	}
+	value, live := ikey.LiveValue(internalKey.Kind(), value, time.Now().UnixNano())
+	if !live {
+		return nil, errors.ErrDBNotFound
+	}
+	return value, nil
+}
+
+// 读取key并声明事务将会修改它
+// 悲观模式下会先对key加锁，乐观模式下与Get相同
+func (txn *Transaction) GetForUpdate(key []byte, opts *utils.ReadOptions) ([]byte, error) {
+	if txn.done {
+		return nil, errors.ErrTxnDone
+	}
+	if err := txn.lock(key); err != nil {
+		return nil, err
+	}
+	return txn.Get(key, opts)
+}
+
+func (txn *Transaction) Set(key, value []byte) error {
+	if err := txn.prepareWrite(key); err != nil {
+		return err
+	}
+	txn.batch.Set(key, value)
+	return nil
+}
+
+func (txn *Transaction) Delete(key []byte) error {
+	if err := txn.prepareWrite(key); err != nil {
+		return err
+	}
+	txn.batch.Delete(key)
+	return nil
+}
+
+func (txn *Transaction) Commit(opts *utils.WriteOptions) error {
+	if txn.done {
+		return errors.ErrTxnDone
+	}
+	txn.done = true
+	defer txn.release()
+
+	batch := txn.batch.Batch()
+	n := len(batch.data)
+	if n != 0 && batch.Count() == invalidBatchCount {
+		return errors.ErrBatchInvalid
+	}
+
+	db := txn.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if n != 0 {
+		if err := db.makeRoomForWrite(n, opts); err != nil {
+			return err
+		}
+	}
+
+	// 校验与写入在同一次持锁内完成，期间不会有其他写入插入
+	if !txn.opts.GetPessimistic() {
+		for key, seq := range txn.tracked {
+			if txn.latestSeq([]byte(key)) != seq {
+				return errors.ErrTxnConflict
+			}
+		}
+	}
+
+	if n == 0 {
+		return nil
+	}
+	return db.writeBatch(batch, opts)
+}
+
+// 返回将事务内未提交的写入覆盖在数据库之上的迭代器
+// 通过迭代器读取的key不会被乐观模式跟踪
+func (txn *Transaction) NewIterator(opts *utils.ReadOptions) Iterator {
+	return txn.batch.NewIteratorWithBase(txn.db.NewIterator(opts))
+}
+
+func (txn *Transaction) Rollback() error {
+	if txn.done {
+		return errors.ErrTxnDone
+	}
+	txn.done = true
+	txn.release()
+	return nil
+}
+
+func (txn *Transaction) prepareWrite(key []byte) error {
+	if txn.done {
+		return errors.ErrTxnDone
+	}
+	if txn.opts.GetPessimistic() {
+		return txn.lock(key)
+	}
+	if _, exist := txn.tracked[string(key)]; !exist {
+		db := txn.db
+		db.mutex.Lock()
+		txn.tracked[string(key)] = txn.latestSeq(key)
+		db.mutex.Unlock()
+	}
+	return nil
+}
+
+func (txn *Transaction) lock(key []byte) error {
+	if !txn.opts.GetPessimistic() {
+		return nil
+	}
+	if err := txn.db.locks.lock(txn.id, key, txn.opts.GetLockTimeout()); err != nil {
+		return err
+	}
+	txn.locked = append(txn.locked, copyBytes(key))
+	return nil
+}
+
+func (txn *Transaction) release() {
+	for _, key := range txn.locked {
+		txn.db.locks.unlock(txn.id, key)
+	}
+	txn.locked = nil
+}
+
+// 只记录key第一次被访问时的序列号
+func (txn *Transaction) track(key []byte, internalKey ikey.InternalKey) {
+	if _, exist := txn.tracked[string(key)]; exist {
+		return
+	}
+	var seq uint64
+	if internalKey != nil {
+		seq = internalKey.SeqNum()
+	}
+	txn.tracked[string(key)] = seq
+}
+
+// 返回key当前最新一条记录的序列号，key不存在时返回0，调用方需持有db.mutex
+func (txn *Transaction) latestSeq(key []byte) uint64 {
+	internalKey, _, err := txn.db.find(key, txn.db.seq)
+	if err != nil {
+		return 0
+	}
+	return internalKey.SeqNum()
+}
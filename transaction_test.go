@@ -0,0 +1,191 @@
+package yldb
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var txnPath = "./test_data/test_transaction"
+
+func openTxnDB(t *testing.T) *YLDB {
+	_ = os.RemoveAll(txnPath)
+	db, err := Open(txnPath)
+	if db == nil || err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func transfer(txn *Transaction, from, to string, amount int) error {
+	fromVal, err := txn.GetForUpdate([]byte(from), nil)
+	if err != nil {
+		return err
+	}
+	toVal, err := txn.GetForUpdate([]byte(to), nil)
+	if err != nil {
+		return err
+	}
+	fromBalance, _ := strconv.Atoi(string(fromVal))
+	toBalance, _ := strconv.Atoi(string(toVal))
+	if err := txn.Set([]byte(from), []byte(strconv.Itoa(fromBalance-amount))); err != nil {
+		return err
+	}
+	return txn.Set([]byte(to), []byte(strconv.Itoa(toBalance+amount)))
+}
+
+func TestOptimisticTransaction(t *testing.T) {
+	db := openTxnDB(t)
+	defer db.Close()
+	_ = db.Set([]byte("alice"), []byte("100"), nil)
+	_ = db.Set([]byte("bob"), []byte("100"), nil)
+
+	txn1 := db.BeginTransaction(nil)
+	txn2 := db.BeginTransaction(nil)
+	if err := transfer(txn1, "alice", "bob", 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := transfer(txn2, "alice", "bob", 50); err != nil {
+		t.Fatal(err)
+	}
+
+	// 事务能读到自身未提交的写入，但其他事务和数据库读不到
+	if val, _ := txn1.Get([]byte("alice"), nil); string(val) != "70" {
+		t.Fatalf("txn1 get: got %q, want %q", val, "70")
+	}
+	if val, _ := db.Get([]byte("alice"), nil); string(val) != "100" {
+		t.Fatalf("db get: got %q, want %q", val, "100")
+	}
+
+	if err := txn1.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn2.Commit(nil); err != errors.ErrTxnConflict {
+		t.Fatalf("txn2 commit: got %v, want %v", err, errors.ErrTxnConflict)
+	}
+	if err := txn2.Commit(nil); err != errors.ErrTxnDone {
+		t.Fatalf("txn2 recommit: got %v, want %v", err, errors.ErrTxnDone)
+	}
+
+	if val, _ := db.Get([]byte("alice"), nil); string(val) != "70" {
+		t.Fatalf("alice: got %q, want %q", val, "70")
+	}
+	if val, _ := db.Get([]byte("bob"), nil); string(val) != "130" {
+		t.Fatalf("bob: got %q, want %q", val, "130")
+	}
+}
+
+func TestOptimisticTransactionWriteConflict(t *testing.T) {
+	db := openTxnDB(t)
+	defer db.Close()
+
+	// 写入一个原本不存在的key时，也需要检测与其他写入的冲突
+	txn := db.BeginTransaction(nil)
+	_ = txn.Set([]byte("carol"), []byte("1"))
+	_ = db.Set([]byte("carol"), []byte("2"), nil)
+	if err := txn.Commit(nil); err != errors.ErrTxnConflict {
+		t.Fatalf("commit: got %v, want %v", err, errors.ErrTxnConflict)
+	}
+
+	txn = db.BeginTransaction(nil)
+	_ = txn.Delete([]byte("carol"))
+	if _, err := txn.Get([]byte("carol"), nil); err != errors.ErrDBNotFound {
+		t.Fatalf("get deleted: got %v, want %v", err, errors.ErrDBNotFound)
+	}
+	if err := txn.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get([]byte("carol"), nil); err != errors.ErrDBNotFound {
+		t.Fatalf("db get deleted: got %v, want %v", err, errors.ErrDBNotFound)
+	}
+}
+
+func TestPessimisticTransaction(t *testing.T) {
+	db := openTxnDB(t)
+	defer db.Close()
+	_ = db.Set([]byte("alice"), []byte("100"), nil)
+	_ = db.Set([]byte("bob"), []byte("100"), nil)
+
+	opts := &utils.TransactionOptions{Pessimistic: true, LockTimeout: 50 * time.Millisecond}
+	txn1 := db.BeginTransaction(opts)
+	if err := transfer(txn1, "alice", "bob", 30); err != nil {
+		t.Fatal(err)
+	}
+
+	// txn1持有alice的锁，txn2等待超时
+	txn2 := db.BeginTransaction(opts)
+	if _, err := txn2.GetForUpdate([]byte("alice"), nil); err != errors.ErrTxnLockTimeout {
+		t.Fatalf("lock: got %v, want %v", err, errors.ErrTxnLockTimeout)
+	}
+
+	// txn1提交后释放锁，txn2可以继续
+	done := make(chan error)
+	go func() {
+		done <- transfer(txn2, "alice", "bob", 50)
+	}()
+	if err := txn1.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := txn2.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if val, _ := db.Get([]byte("alice"), nil); string(val) != "20" {
+		t.Fatalf("alice: got %q, want %q", val, "20")
+	}
+	if val, _ := db.Get([]byte("bob"), nil); string(val) != "180" {
+		t.Fatalf("bob: got %q, want %q", val, "180")
+	}
+}
+
+func TestPessimisticTransactionDeadlock(t *testing.T) {
+	db := openTxnDB(t)
+	defer db.Close()
+
+	opts := &utils.TransactionOptions{Pessimistic: true, LockTimeout: time.Second}
+	txn1 := db.BeginTransaction(opts)
+	txn2 := db.BeginTransaction(opts)
+	if err := txn1.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn2.Set([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// txn1等待txn2持有的b
+	done := make(chan error)
+	go func() {
+		done <- txn1.Set([]byte("b"), []byte("1"))
+	}()
+	for {
+		db.locks.mutex.Lock()
+		_, waiting := db.locks.waitFor[txn1.id]
+		db.locks.mutex.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// txn2再等待txn1持有的a会形成环
+	if err := txn2.Set([]byte("a"), []byte("2")); err != errors.ErrTxnDeadlock {
+		t.Fatalf("deadlock: got %v, want %v", err, errors.ErrTxnDeadlock)
+	}
+	_ = txn2.Rollback()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := txn1.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := db.Get([]byte("b"), nil); string(val) != "1" {
+		t.Fatalf("b: got %q, want %q", val, "1")
+	}
+}
@@ -0,0 +1,85 @@
+package yldb
+
+import (
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+// 写入在ttl之后过期的key，过期之后读不到该key，并在之后的Compaction中被清除
+func (db *YLDB) SetWithTTL(key, value []byte, ttl time.Duration, opts *utils.WriteOptions) error {
+	var batch Batch
+	batch.SetWithTTL(key, value, ttl)
+	return db.Apply(batch, opts)
+}
+
+func (db *YLDB) SetCFWithTTL(cf *ColumnFamily, key, value []byte, ttl time.Duration, opts *utils.WriteOptions) error {
+	var batch Batch
+	batch.SetCFWithTTL(cf, key, value, ttl)
+	return db.Apply(batch, opts)
+}
+
+// 为写入配置了DefaultTTL的列族的Set操作加上过期时间，没有这样的操作时返回原Batch，调用方需持有db.mutex
+// 过期时间在写入WAL之前确定，从WAL恢复时不会改变
+func (db *YLDB) applyDefaultTTL(batch Batch) Batch {
+	hasTTL := false
+	for _, cf := range db.cfs {
+		if cf.opts.GetDefaultTTL() > 0 {
+			hasTTL = true
+		}
+	}
+	if !hasTTL {
+		return batch
+	}
+	rewriter := &defaultTTLRewriter{db: db, now: time.Now()}
+	if err := batch.Iterate(rewriter); err != nil || !rewriter.changed {
+		// 格式错误、列族不存在等由之后的写入流程报告
+		return batch
+	}
+	return rewriter.batch
+}
+
+// 逐个复制Batch中的操作，将写入配置了DefaultTTL的列族的Set改写为SetWithTTL
+type defaultTTLRewriter struct {
+	db      *YLDB
+	now     time.Time
+	batch   Batch
+	changed bool
+}
+
+func (r *defaultTTLRewriter) Set(key, value []byte) error {
+	return r.SetCF(defaultColumnFamilyID, key, value)
+}
+
+func (r *defaultTTLRewriter) Delete(key []byte) error {
+	return r.DeleteCF(defaultColumnFamilyID, key)
+}
+
+func (r *defaultTTLRewriter) SetCF(cfID uint32, key, value []byte) error {
+	cf, exist := r.db.cfs[cfID]
+	if !exist {
+		return errors.ErrColumnFamilyNotFound
+	}
+	if ttl := cf.opts.GetDefaultTTL(); ttl > 0 {
+		r.batch.setWithTTL(cfID, key, value, r.now.Add(ttl).UnixNano())
+		r.changed = true
+	} else {
+		r.batch.SetCF(cf, key, value)
+	}
+	return nil
+}
+
+func (r *defaultTTLRewriter) DeleteCF(cfID uint32, key []byte) error {
+	cf, exist := r.db.cfs[cfID]
+	if !exist {
+		return errors.ErrColumnFamilyNotFound
+	}
+	r.batch.DeleteCF(cf, key)
+	return nil
+}
+
+func (r *defaultTTLRewriter) SetWithTTL(cfID uint32, key, value []byte, expiration int64) error {
+	r.batch.setWithTTL(cfID, key, value, expiration)
+	return nil
+}
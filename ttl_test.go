@@ -0,0 +1,179 @@
+package yldb
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var ttlPath = "./test_data/test_ttl"
+
+func TestSetWithTTL(t *testing.T) {
+	_ = os.RemoveAll(ttlPath)
+	db, err := Open(ttlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const ttl = 100 * time.Millisecond
+	_ = db.Set([]byte("apple"), []byte("red"), nil)
+	_ = db.SetWithTTL([]byte("apple"), []byte("green"), ttl, nil)
+	_ = db.SetWithTTL([]byte("banana"), []byte("yellow"), ttl, nil)
+	_ = db.SetWithTTL([]byte("cherry"), []byte("red"), time.Hour, nil)
+	_ = db.Set([]byte("durian"), []byte("green"), nil)
+
+	want := []string{"apple=green", "banana=yellow", "cherry=red", "durian=green"}
+	if got := collect(db.NewIterator(nil), false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("iterated %v, want %v", got, want)
+	}
+	if value, err := db.Get([]byte("apple"), nil); err != nil || string(value) != "green" {
+		t.Fatalf("Get(apple) = (%q, %v)", value, err)
+	}
+
+	// 过期的记录等同于删除记录，更旧的记录也不可见
+	time.Sleep(2 * ttl)
+	check := func() {
+		for _, key := range []string{"apple", "banana"} {
+			if _, err := db.Get([]byte(key), nil); err != errors.ErrDBNotFound {
+				t.Fatalf("Get(%s): got %v, want ErrDBNotFound", key, err)
+			}
+		}
+		values, errs := db.MultiGet([][]byte{[]byte("apple"), []byte("cherry")}, nil)
+		if errs[0] != errors.ErrDBNotFound || errs[1] != nil || string(values[1]) != "red" {
+			t.Fatalf("MultiGet = (%q, %v)", values, errs)
+		}
+		want := []string{"cherry=red", "durian=green"}
+		if got := collect(db.NewIterator(nil), false); !reflect.DeepEqual(got, want) {
+			t.Fatalf("iterated %v, want %v", got, want)
+		}
+		want = []string{"durian=green", "cherry=red"}
+		if got := collect(db.NewIterator(nil), true); !reflect.DeepEqual(got, want) {
+			t.Fatalf("reverse iterated %v, want %v", got, want)
+		}
+	}
+	check()
+
+	// Compaction清除过期的记录及其覆盖的旧记录
+	if err := db.CompactRange(nil, nil, &utils.CompactRangeOptions{ForceBottommost: true}); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	n := 0
+	iterators, release := db.defaultCF.current.Iterators()
+	for _, it := range iterators {
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			n++
+		}
+	}
+	release()
+	if n != 2 {
+		t.Fatalf("expected 2 records after compaction, got %d", n)
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	_ = os.RemoveAll(ttlPath)
+	const ttl = 100 * time.Millisecond
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{DefaultTTL: ttl}}
+	db, err := OpenWithOptions(ttlPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		_ = db.Set(key, key, nil)
+		// 刚写入的带过期时间的记录立即可见
+		if value, err := db.Get(key, nil); err != nil || string(value) != string(key) {
+			t.Fatalf("Get(%s) = (%q, %v)", key, value, err)
+		}
+	}
+	_ = db.SetWithTTL([]byte("long"), []byte("long"), time.Hour, nil)
+	if value, err := db.Get([]byte("key00"), nil); err != nil || string(value) != "key00" {
+		t.Fatalf("Get(key00) = (%q, %v)", value, err)
+	}
+	_ = db.Set([]byte("key01"), []byte("updated"), nil)
+	if value, err := db.Get([]byte("key01"), nil); err != nil || string(value) != "updated" {
+		t.Fatalf("Get(key01) = (%q, %v)", value, err)
+	}
+
+	// 事务中的写入同样使用DefaultTTL
+	txn := db.BeginTransaction(nil)
+	_ = txn.Set([]byte("txn"), []byte("txn"))
+	if err := txn.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get([]byte("txn"), nil); err != nil || string(value) != "txn" {
+		t.Fatalf("Get(txn) = (%q, %v)", value, err)
+	}
+
+	// 过期时间随WAL持久化，之后不使用DefaultTTL打开也不会改变
+	db.Close()
+	time.Sleep(2 * ttl)
+	db, err = Open(ttlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	want := []string{"long=long"}
+	if got := collect(db.NewIterator(nil), false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("iterated %v, want %v", got, want)
+	}
+	_ = db.Set([]byte("key00"), []byte("forever"), nil)
+	time.Sleep(2 * ttl)
+	if value, err := db.Get([]byte("key00"), nil); err != nil || string(value) != "forever" {
+		t.Fatalf("Get(key00) = (%q, %v)", value, err)
+	}
+}
+
+// 最新一次写入是带过期时间的写入时，读取到的不是同一key更旧的记录
+func TestGetAfterSetWithTTL(t *testing.T) {
+	_ = os.RemoveAll(ttlPath)
+	db, err := Open(ttlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	key := []byte("apple")
+	_ = db.Set(key, []byte("old"), nil)
+	_ = db.SetWithTTL(key, []byte("new"), time.Hour, nil)
+	if value, err := db.Get(key, nil); err != nil || string(value) != "new" {
+		t.Fatalf("Get(apple) = (%q, %v)", value, err)
+	}
+	values, errs := db.MultiGet([][]byte{key}, nil)
+	if errs[0] != nil || string(values[0]) != "new" {
+		t.Fatalf("MultiGet = (%q, %v)", values, errs)
+	}
+	txn := db.BeginTransaction(nil)
+	if value, err := txn.Get(key, nil); err != nil || string(value) != "new" {
+		t.Fatalf("txn.Get(apple) = (%q, %v)", value, err)
+	}
+	if err := txn.Set(key, []byte("txn")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get(key, nil); err != nil || string(value) != "txn" {
+		t.Fatalf("Get(apple) = (%q, %v)", value, err)
+	}
+}
+
+func TestBatchSetWithTTLHandler(t *testing.T) {
+	var batch Batch
+	batch.Set([]byte("apple"), []byte("red"))
+	batch.SetWithTTL([]byte("banana"), []byte("yellow"), time.Hour)
+	if err := batch.Iterate(&recordingHandler{}); err != errors.ErrBatchUnsupportedKind {
+		t.Fatalf("got %v, want ErrBatchUnsupportedKind", err)
+	}
+	var copied Batch
+	if err := copied.SetRepr(batch.Repr()); err != nil {
+		t.Fatal(err)
+	}
+}
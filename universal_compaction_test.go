@@ -0,0 +1,81 @@
+package yldb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/config"
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var universalCompactionPath = "./test_data/test_universal_compaction"
+
+func TestUniversalCompaction(t *testing.T) {
+	_ = os.RemoveAll(universalCompactionPath)
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{
+		WriteBufferSize: 4 << 10,
+		CompactionStyle: utils.CompactionStyleUniversal,
+	}}
+	db, err := OpenWithOptions(universalCompactionPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 多轮覆盖写入并删除部分key
+	const n = 1000
+	for round := 0; round < 3; round++ {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%05d", i))
+			_ = db.Set(key, []byte(fmt.Sprintf("%s-%d", key, round)), nil)
+		}
+	}
+	for i := 0; i < n; i += 3 {
+		_ = db.Delete([]byte(fmt.Sprintf("key%05d", i)), nil)
+	}
+	db.mutex.Lock()
+	err = db.flush()
+	db.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForCompactions(db)
+
+	runs := db.defaultCF.current.NumLevelFiles(0)
+	for level := 1; level < config.NumLevels; level++ {
+		if db.defaultCF.current.NumLevelFiles(level) > 0 {
+			runs++
+		}
+	}
+	if runs >= config.L0CompactionTrigger {
+		t.Fatalf("expected fewer than %d sorted runs, got %d", config.L0CompactionTrigger, runs)
+	}
+	checkNoObsoleteTables(t, db.defaultCF)
+
+	check := func() {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%05d", i))
+			value, err := db.Get(key, nil)
+			if i%3 == 0 {
+				if err != errors.ErrDBNotFound {
+					t.Fatalf("Get(%s) = (%q, %v), want ErrDBNotFound", key, value, err)
+				}
+			} else if want := fmt.Sprintf("%s-2", key); err != nil || string(value) != want {
+				t.Fatalf("Get(%s) = (%q, %v), want %q", key, value, err, want)
+			}
+		}
+		if got := collect(db.NewIterator(nil), false); len(got) != n-(n+2)/3 {
+			t.Fatalf("iterated %d keys", len(got))
+		}
+	}
+	check()
+
+	db.Close()
+	db, err = OpenWithOptions(universalCompactionPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check()
+}
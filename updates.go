@@ -0,0 +1,169 @@
+package yldb
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+	"github.com/Cauchy-NY/yldb/wal"
+)
+
+// UpdatesIterator 按序列号顺序遍历WAL中已提交的Batch，用于变更数据捕获
+// 创建时打开所有需要读取的WAL，之后这些WAL即使被删除也可以继续读取
+// 遍历到正在写入的WAL末尾时结束，之后的写入需要重新调用GetUpdatesSince获取
+type UpdatesIterator struct {
+	files  []*os.File
+	reader *wal.Reader
+	// 只返回包含大于seq的序列号的Batch
+	seq uint64
+	// 下一个Batch应有的序列号，为0时表示尚未读到第一个Batch
+	next  uint64
+	batch Batch
+	valid bool
+	err   error
+}
+
+// 返回遍历序列号大于seq的所有写入的迭代器，每次返回一个完整的Batch
+// 调用方通常传入已处理的最后一个序列号，即上一次得到的Batch的SeqNum()+Count()-1
+// 所需的WAL已被删除时返回ErrWALNotRetained，可以通过Options.WALTTL延长WAL的保留时间
+// 迭代器创建后已指向第一个Batch，使用完毕后需要调用Close
+func (db *YLDB) GetUpdatesSince(seq uint64) (*UpdatesIterator, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	dir := db.primaryDir()
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var numbers []uint64
+	for _, info := range infos {
+		fileType, number, ok := utils.ParseFileName(info.Name())
+		if ok && fileType == utils.LogFile {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool {
+		return numbers[i] < numbers[j]
+	})
+
+	it := &UpdatesIterator{seq: seq}
+	// 从第一条记录的序列号不大于seq+1的最后一个WAL开始读取
+	for _, number := range numbers {
+		file, err := os.Open(utils.LogFileName(dir, number))
+		if err != nil {
+			_ = it.Close()
+			return nil, err
+		}
+		if first, ok := firstBatchSeq(file); ok && first <= seq+1 {
+			// 更早的WAL中只有序列号不大于seq的写入
+			for _, f := range it.files {
+				_ = f.Close()
+			}
+			it.files = it.files[:0]
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			_ = file.Close()
+			_ = it.Close()
+			return nil, err
+		}
+		it.files = append(it.files, file)
+	}
+
+	if seq < db.seq {
+		it.Next()
+		if it.err == nil && (!it.valid || it.batch.SeqNum() > seq+1) {
+			// 所需的序列号所在的WAL已被删除
+			it.err = errors.ErrWALNotRetained
+		}
+		if it.err != nil {
+			_ = it.Close()
+			return nil, it.err
+		}
+	}
+	return it, nil
+}
+
+// 返回WAL中第一个Batch的序列号
+func firstBatchSeq(file *os.File) (uint64, bool) {
+	record, err := wal.NewReader(file).ReadRecord()
+	if err != nil {
+		return 0, false
+	}
+	var batch Batch
+	if batch.SetRepr(record) != nil {
+		return 0, false
+	}
+	return batch.SeqNum(), true
+}
+
+func (it *UpdatesIterator) Valid() bool {
+	return it.valid
+}
+
+// 返回当前的Batch，Batch的SeqNum()为其中第一个操作的序列号
+func (it *UpdatesIterator) Batch() Batch {
+	return it.batch
+}
+
+// 返回遍历过程中遇到的错误
+func (it *UpdatesIterator) Err() error {
+	return it.err
+}
+
+func (it *UpdatesIterator) Next() {
+	it.valid = false
+	for it.err == nil {
+		if it.reader == nil {
+			if len(it.files) == 0 {
+				return
+			}
+			it.reader = wal.NewReader(it.files[0])
+		}
+
+		record, err := it.reader.ReadRecord()
+		if err == io.EOF || err == errors.ErrWALTruncated {
+			// 当前WAL读完（或读到正在写入的记录），继续读取下一个WAL
+			_ = it.files[0].Close()
+			it.files = it.files[1:]
+			it.reader = nil
+			continue
+		}
+		if err != nil {
+			it.err = err
+			return
+		}
+
+		var batch Batch
+		if err := batch.SetRepr(record); err != nil {
+			it.err = err
+			return
+		}
+		count := uint64(batch.Count())
+		if count == 0 || batch.SeqNum()+count-1 <= it.seq {
+			continue
+		}
+		if it.next != 0 && batch.SeqNum() != it.next {
+			// 序列号不连续，中间的WAL已被删除
+			it.err = errors.ErrWALNotRetained
+			return
+		}
+		it.next = batch.SeqNum() + count
+		it.batch = batch
+		it.valid = true
+		return
+	}
+}
+
+func (it *UpdatesIterator) Close() error {
+	for _, file := range it.files {
+		_ = file.Close()
+	}
+	it.files = nil
+	it.reader = nil
+	it.valid = false
+	return nil
+}
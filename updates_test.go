@@ -0,0 +1,101 @@
+package yldb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var updatesPath = "./test_data/test_updates"
+
+func TestGetUpdatesSince(t *testing.T) {
+	_ = os.RemoveAll(updatesPath)
+	opts := &utils.Options{
+		ColumnFamilyOptions: utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10},
+		WALTTL:              time.Hour,
+	}
+	db, err := OpenWithOptions(updatesPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 多次flush之后，保留的WAL中仍然有全部写入
+	for i := 1; i <= 1000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = db.Set(key, key, nil)
+	}
+	cf, _ := db.CreateColumnFamily("cf", nil)
+	var batch Batch
+	batch.Delete([]byte("key00001"))
+	batch.SetCF(cf, []byte("a"), []byte("b"))
+	_ = db.Apply(batch, nil)
+
+	it, err := db.GetUpdatesSince(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := uint64(1)
+	for ; it.Valid(); it.Next() {
+		b := it.Batch()
+		if b.SeqNum() != next {
+			t.Fatalf("batch seq = %d, want %d", b.SeqNum(), next)
+		}
+		next += uint64(b.Count())
+	}
+	_ = it.Close()
+	if it.Err() != nil || next != 1003 {
+		t.Fatalf("iterated up to %d, err %v", next, it.Err())
+	}
+
+	it, err = db.GetUpdatesSince(999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &recordingHandler{}
+	for ; it.Valid(); it.Next() {
+		b := it.Batch()
+		if err := b.Iterate(handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = it.Close()
+	want := []string{"set:key01000=key01000", "delete:key00001", "set(1):a=b"}
+	if fmt.Sprint(handler.ops) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", handler.ops, want)
+	}
+
+	// 没有新的写入
+	it, err = db.GetUpdatesSince(1002)
+	if err != nil || it.Valid() {
+		t.Fatalf("expected empty iterator, got valid=%v err=%v", it.Valid(), err)
+	}
+	_ = it.Close()
+}
+
+func TestGetUpdatesSinceNotRetained(t *testing.T) {
+	_ = os.RemoveAll(updatesPath)
+	opts := &utils.Options{ColumnFamilyOptions: utils.ColumnFamilyOptions{WriteBufferSize: 4 << 10}}
+	db, err := OpenWithOptions(updatesPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 1; i <= 1000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		_ = db.Set(key, key, nil)
+	}
+
+	if _, err := db.GetUpdatesSince(0); err != errors.ErrWALNotRetained {
+		t.Fatalf("expected ErrWALNotRetained, got %v", err)
+	}
+	it, err := db.GetUpdatesSince(999)
+	if err != nil || !it.Valid() {
+		t.Fatalf("expected the last write, got valid=%v err=%v", it.Valid(), err)
+	}
+	_ = it.Close()
+}
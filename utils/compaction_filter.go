@@ -0,0 +1,36 @@
+package utils
+
+// CompactionFilter 在Compaction中对每条保留下来的记录调用，可以保留、删除记录或修改其value
+// 只作用于每个key在输入文件中最新的一条写入记录，且该记录不被任何快照读到；删除记录和已过期的记录不会传给Filter
+// 带过期时间的记录传入的是不含过期时间的value，修改value时保留原来的过期时间
+// 可能被多个后台Compaction并发调用，实现需要是并发安全的
+type CompactionFilter interface {
+	// key和existingValue只在调用期间有效，需要保留时应复制
+	// 返回CompactionFilterChangeValue时newValue为新的value
+	Filter(ctx *CompactionFilterContext, key, existingValue []byte) (decision CompactionFilterDecision, newValue []byte)
+	Name() string
+}
+
+type CompactionFilterDecision int
+
+const (
+	// 保留记录
+	CompactionFilterKeep CompactionFilterDecision = iota
+	// 删除记录：更下层没有该key的数据时直接丢弃，否则改写为删除记录
+	CompactionFilterRemove
+	// 用newValue替换记录的value
+	CompactionFilterChangeValue
+)
+
+// CompactionFilterContext 描述正在进行的Compaction
+type CompactionFilterContext struct {
+	// 合并的最上层Level和输出的Level
+	Level       int
+	OutputLevel int
+	// 输入文件包含了列族的所有文件
+	IsFullCompaction bool
+	// 输出Level之下没有与输入文件key范围重叠的数据
+	IsBottommostLevel bool
+	// 由CompactRange触发
+	IsManualCompaction bool
+}
@@ -1,6 +1,20 @@
 package utils
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type FileType int
+
+const (
+	LogFile FileType = iota
+	TableFile
+	DescriptorFile
+	CurrentFile
+	TempFile
+)
 
 func makeFileName(dbname string, number uint64, suffix string) string {
 	return fmt.Sprintf("%s/%06d.%s", dbname, number, suffix)
@@ -21,3 +35,40 @@ func CurrentFileName(dbname string) string {
 func TempFileName(dbname string, number uint64) string {
 	return makeFileName(dbname, number, "dbtmp")
 }
+
+func LogFileName(dbname string, number uint64) string {
+	return makeFileName(dbname, number, "log")
+}
+
+// 非默认列族的SST文件存放在各自的子目录中
+func ColumnFamilyDirName(dbname string, id uint32) string {
+	return fmt.Sprintf("%s/cf_%d", dbname, id)
+}
+
+// 解析数据库目录下的文件名（不含目录），返回文件类型和编号
+func ParseFileName(name string) (FileType, uint64, bool) {
+	if name == "CURRENT" {
+		return CurrentFile, 0, true
+	}
+	if strings.HasPrefix(name, "MANIFEST-") {
+		number, err := strconv.ParseUint(strings.TrimPrefix(name, "MANIFEST-"), 10, 64)
+		return DescriptorFile, number, err == nil
+	}
+	dot := strings.LastIndexByte(name, '.')
+	if dot < 0 {
+		return 0, 0, false
+	}
+	number, err := strconv.ParseUint(name[:dot], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	switch name[dot+1:] {
+	case "log":
+		return LogFile, number, true
+	case "ldb":
+		return TableFile, number, true
+	case "dbtmp":
+		return TempFile, number, true
+	}
+	return 0, 0, false
+}
@@ -1,14 +1,328 @@
 package utils
 
+import (
+	"math"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/config"
+)
+
 type ReadOptions struct {
-	// 保留待用
+	// 读取时使用的快照，为nil时读取最新数据
+	Snapshot *Snapshot
+}
+
+func (o *ReadOptions) GetSnapshot() *Snapshot {
+	if o == nil {
+		return nil
+	}
+	return o.Snapshot
 }
 
 type WriteOptions struct {
-	// 保留待用
-	sync bool
+	// 写入WAL后是否立即刷盘
+	Sync bool
+	// 写入需要延迟或停止时不等待，直接返回errors.ErrWriteStall
+	NoSlowdown bool
 }
 
 func (o *WriteOptions) GetSync() bool {
-	return o != nil && o.sync
+	return o != nil && o.Sync
+}
+
+func (o *WriteOptions) GetNoSlowdown() bool {
+	return o != nil && o.NoSlowdown
+}
+
+// Options 是打开数据库时的配置
+type Options struct {
+	// 默认列族的配置
+	ColumnFamilyOptions
+	// 已存在的非默认列族的配置（按名称），未指定的列族使用默认配置
+	ColumnFamilies map[string]*ColumnFamilyOptions
+	// WAL中的数据flush到SST文件之后该WAL继续保留的时间，供GetUpdatesSince读取，不大于0时flush之后立即删除
+	WALTTL time.Duration
+	// 同时进行的后台major compaction数量上限，不大于0时使用config.MaxBackgroundCompactions
+	MaxBackgroundCompactions int
+	// 一次Compaction最多划分为多少个key范围并行合并，不大于0时使用config.MaxSubcompactions
+	MaxSubcompactions int
+	// flush和后台Compaction写入SST文件时共用的限速器，flush的优先级更高，为nil时不限速
+	RateLimiter *RateLimiter
+	// 写入被延迟时的初始写入速度（字节/秒），之后随Compaction的积压情况调整，不大于0时使用config.DelayedWriteRate
+	DelayedWriteRate int64
+}
+
+func (o *Options) GetRateLimiter() *RateLimiter {
+	if o == nil {
+		return nil
+	}
+	return o.RateLimiter
+}
+
+func (o *Options) GetDelayedWriteRate() int64 {
+	if o == nil || o.DelayedWriteRate <= 0 {
+		return config.DelayedWriteRate
+	}
+	return o.DelayedWriteRate
+}
+
+func (o *Options) GetMaxBackgroundCompactions() int {
+	if o == nil || o.MaxBackgroundCompactions <= 0 {
+		return config.MaxBackgroundCompactions
+	}
+	return o.MaxBackgroundCompactions
+}
+
+func (o *Options) GetMaxSubcompactions() int {
+	if o == nil || o.MaxSubcompactions <= 0 {
+		return config.MaxSubcompactions
+	}
+	return o.MaxSubcompactions
+}
+
+func (o *Options) GetWALTTL() time.Duration {
+	if o == nil || o.WALTTL <= 0 {
+		return 0
+	}
+	return o.WALTTL
+}
+
+func (o *Options) GetColumnFamilyOptions(name string) *ColumnFamilyOptions {
+	if o == nil {
+		return nil
+	}
+	if name == DefaultColumnFamilyName {
+		return &o.ColumnFamilyOptions
+	}
+	return o.ColumnFamilies[name]
+}
+
+const DefaultColumnFamilyName = "default"
+
+// ColumnFamilyOptions 是单个列族的配置
+type ColumnFamilyOptions struct {
+	// 用户key的比较器，为nil时使用DefaultComparator，同一列族每次打开时必须使用同名的比较器
+	Comparator Comparator
+	// MemTable的大小上限，不大于0时使用config.WriteBufferSize
+	WriteBufferSize uint64
+	// Compaction策略，默认为CompactionStyleLevel
+	CompactionStyle CompactionStyle
+	// CompactionStyleLevel中由最底层的实际大小逐层向上推算各Level的目标大小，
+	// 目标大小不超过config.L1FileMaxBytes的最深一层作为L0合并的目标，其上的各层不再使用；
+	// 为false时L1的目标大小固定为config.L1FileMaxBytes，之下每层为上一层的config.LevelSizeMultiplier倍
+	LevelCompactionDynamicLevelBytes bool
+	// CompactionStyleUniversal的配置，为nil时使用默认配置
+	UniversalCompactionOptions *UniversalCompactionOptions
+	// CompactionStyleFIFO的配置，为nil时使用默认配置
+	FIFOCompactionOptions *FIFOCompactionOptions
+	// Compaction中对保留下来的记录调用的过滤器，为nil时不过滤
+	CompactionFilter CompactionFilter
+	// 未指定过期时间的写入的默认存活时间，不大于0时永不过期
+	DefaultTTL time.Duration
+	// Level0文件数量达到Level0SlowdownWritesTrigger时延迟写入，达到Level0StopWritesTrigger时停止写入，
+	// 不大于0时分别使用config.L0SlowdownWritesTrigger和config.L0StopWritesTrigger
+	Level0SlowdownWritesTrigger int
+	Level0StopWritesTrigger     int
+	// 估算的待合并数据量达到SoftPendingCompactionBytesLimit时延迟写入，达到HardPendingCompactionBytesLimit时停止写入，
+	// 为0时分别使用config.SoftPendingCompactionBytesLimit和config.HardPendingCompactionBytesLimit
+	SoftPendingCompactionBytesLimit uint64
+	HardPendingCompactionBytesLimit uint64
+}
+
+func (o *ColumnFamilyOptions) GetComparator() Comparator {
+	if o == nil || o.Comparator == nil {
+		return NewDefaultComparator()
+	}
+	return o.Comparator
+}
+
+func (o *ColumnFamilyOptions) GetWriteBufferSize() uint64 {
+	if o == nil || o.WriteBufferSize <= 0 {
+		return config.WriteBufferSize
+	}
+	return o.WriteBufferSize
+}
+
+func (o *ColumnFamilyOptions) GetCompactionStyle() CompactionStyle {
+	if o == nil {
+		return CompactionStyleLevel
+	}
+	return o.CompactionStyle
+}
+
+func (o *ColumnFamilyOptions) GetLevelCompactionDynamicLevelBytes() bool {
+	return o != nil && o.LevelCompactionDynamicLevelBytes
+}
+
+func (o *ColumnFamilyOptions) GetUniversalCompactionOptions() *UniversalCompactionOptions {
+	if o == nil {
+		return nil
+	}
+	return o.UniversalCompactionOptions
+}
+
+func (o *ColumnFamilyOptions) GetFIFOCompactionOptions() *FIFOCompactionOptions {
+	if o == nil {
+		return nil
+	}
+	return o.FIFOCompactionOptions
+}
+
+func (o *ColumnFamilyOptions) GetCompactionFilter() CompactionFilter {
+	if o == nil {
+		return nil
+	}
+	return o.CompactionFilter
+}
+
+func (o *ColumnFamilyOptions) GetDefaultTTL() time.Duration {
+	if o == nil || o.DefaultTTL <= 0 {
+		return 0
+	}
+	return o.DefaultTTL
+}
+
+// 不小于config.L0CompactionTrigger，保证停止写入时总会触发Compaction
+func (o *ColumnFamilyOptions) GetLevel0SlowdownWritesTrigger() int {
+	if o == nil || o.Level0SlowdownWritesTrigger <= 0 {
+		return config.L0SlowdownWritesTrigger
+	}
+	if o.Level0SlowdownWritesTrigger < config.L0CompactionTrigger {
+		return config.L0CompactionTrigger
+	}
+	return o.Level0SlowdownWritesTrigger
+}
+
+// 不小于GetLevel0SlowdownWritesTrigger()
+func (o *ColumnFamilyOptions) GetLevel0StopWritesTrigger() int {
+	trigger := config.L0StopWritesTrigger
+	if o != nil && o.Level0StopWritesTrigger > 0 {
+		trigger = o.Level0StopWritesTrigger
+	}
+	if slowdown := o.GetLevel0SlowdownWritesTrigger(); trigger < slowdown {
+		return slowdown
+	}
+	return trigger
+}
+
+func (o *ColumnFamilyOptions) GetSoftPendingCompactionBytesLimit() uint64 {
+	if o == nil || o.SoftPendingCompactionBytesLimit == 0 {
+		return config.SoftPendingCompactionBytesLimit
+	}
+	return o.SoftPendingCompactionBytesLimit
+}
+
+// 不小于GetSoftPendingCompactionBytesLimit()
+func (o *ColumnFamilyOptions) GetHardPendingCompactionBytesLimit() uint64 {
+	limit := uint64(config.HardPendingCompactionBytesLimit)
+	if o != nil && o.HardPendingCompactionBytesLimit > 0 {
+		limit = o.HardPendingCompactionBytesLimit
+	}
+	if soft := o.GetSoftPendingCompactionBytesLimit(); limit < soft {
+		return soft
+	}
+	return limit
+}
+
+type CompactionStyle int
+
+const (
+	// 分层Compaction：L1及之下每层的数据按score逐层向下合并，读放大和空间放大较小
+	CompactionStyleLevel CompactionStyle = iota
+	// Universal Compaction：L0中的每个文件和之下的每个非空Level各是一个sorted run，
+	// 合并大小相近的sorted run，写放大较小，读放大和空间放大较大
+	CompactionStyleUniversal
+	// FIFO Compaction：所有文件都保留在L0中，从不合并数据，
+	// 总大小超过上限或文件过期时删除最旧的文件，适用于只写入新数据、只保留最近数据的场景
+	CompactionStyleFIFO
+)
+
+type UniversalCompactionOptions struct {
+	// 合并相邻sorted run时允许的大小差异百分比：下一个sorted run不大于已选sorted run总大小的(100+SizeRatio)%时一起合并，
+	// 不大于0时使用config.UniversalSizeRatio
+	SizeRatio int
+	// 一次合并的最少sorted run数量，不大于1时使用config.UniversalMinMergeWidth
+	MinMergeWidth int
+	// 一次合并的最多sorted run数量，不大于0时不限
+	MaxMergeWidth int
+	// 除最旧的sorted run之外的数据量超过最旧的sorted run的该百分比时，合并所有sorted run，
+	// 不大于0时使用config.UniversalMaxSizeAmplificationPercent
+	MaxSizeAmplificationPercent int
+}
+
+func (o *UniversalCompactionOptions) GetSizeRatio() int {
+	if o == nil || o.SizeRatio <= 0 {
+		return config.UniversalSizeRatio
+	}
+	return o.SizeRatio
+}
+
+func (o *UniversalCompactionOptions) GetMinMergeWidth() int {
+	if o == nil || o.MinMergeWidth <= 1 {
+		return config.UniversalMinMergeWidth
+	}
+	return o.MinMergeWidth
+}
+
+func (o *UniversalCompactionOptions) GetMaxMergeWidth() int {
+	if o == nil || o.MaxMergeWidth <= 0 {
+		return math.MaxInt32
+	}
+	return o.MaxMergeWidth
+}
+
+func (o *UniversalCompactionOptions) GetMaxSizeAmplificationPercent() int {
+	if o == nil || o.MaxSizeAmplificationPercent <= 0 {
+		return config.UniversalMaxSizeAmplificationPercent
+	}
+	return o.MaxSizeAmplificationPercent
+}
+
+type FIFOCompactionOptions struct {
+	// 所有文件的总大小上限，超过时删除最旧的文件，为0时使用config.FIFOMaxTableFilesSize
+	MaxTableFilesSize uint64
+	// 文件创建之后的保留时间，不大于0时不按时间删除
+	// 过期的文件在之后的flush或Compaction完成时被删除，之前仍然可以读到
+	TTL time.Duration
+}
+
+func (o *FIFOCompactionOptions) GetMaxTableFilesSize() uint64 {
+	if o == nil || o.MaxTableFilesSize == 0 {
+		return config.FIFOMaxTableFilesSize
+	}
+	return o.MaxTableFilesSize
+}
+
+func (o *FIFOCompactionOptions) GetTTL() time.Duration {
+	if o == nil || o.TTL <= 0 {
+		return 0
+	}
+	return o.TTL
+}
+
+type CompactRangeOptions struct {
+	// 是否重写最底层中与范围重叠的文件，用于立即清除删除记录和旧数据
+	ForceBottommost bool
+}
+
+func (o *CompactRangeOptions) GetForceBottommost() bool {
+	return o != nil && o.ForceBottommost
+}
+
+type TransactionOptions struct {
+	// 是否采用悲观并发控制，默认为乐观并发控制
+	Pessimistic bool
+	// 悲观模式下等待行锁的超时时间，不大于0时使用config.TxnLockTimeout
+	LockTimeout time.Duration
+}
+
+func (o *TransactionOptions) GetPessimistic() bool {
+	return o != nil && o.Pessimistic
+}
+
+func (o *TransactionOptions) GetLockTimeout() time.Duration {
+	if o == nil || o.LockTimeout <= 0 {
+		return config.TxnLockTimeout
+	}
+	return o.LockTimeout
 }
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/config"
+)
+
+type IOPriority int
+
+const (
+	// 后台Compaction的写入
+	IOPriorityLow IOPriority = iota
+	// flush的写入，有等待中的高优先级请求时低优先级请求不会被满足
+	IOPriorityHigh
+
+	numIOPriorities = 2
+)
+
+// RateLimiter 是令牌桶算法实现的写入限速器，可以由多个数据库共享
+// 令牌以bytesPerSecond的速度补充，最多积累config.RateLimiterRefillPeriod时间内补充的数量，
+// 单次请求超过已有的令牌数时会预支令牌，之后的请求需要等到令牌补足
+// nil表示不限速
+type RateLimiter struct {
+	mutex          sync.Mutex
+	bytesPerSecond int64
+	// 当前可用的令牌（字节数），预支后可能为负
+	available  float64
+	lastRefill time.Time
+	// 各优先级正在等待的请求数量
+	waiting [numIOPriorities]int
+	// 各优先级请求的总字节数和总次数
+	totalBytes    [numIOPriorities]int64
+	totalRequests [numIOPriorities]int64
+}
+
+// bytesPerSecond不大于0时不限速，只进行计数
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{
+		bytesPerSecond: bytesPerSecond,
+		lastRefill:     time.Now(),
+	}
+}
+
+// 运行时调整写入速度，不大于0时不限速
+func (l *RateLimiter) SetBytesPerSecond(bytesPerSecond int64) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill(time.Now())
+	l.bytesPerSecond = bytesPerSecond
+}
+
+func (l *RateLimiter) GetBytesPerSecond() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.bytesPerSecond
+}
+
+// 返回priority优先级的请求写入的总字节数
+func (l *RateLimiter) GetTotalBytesThrough(priority IOPriority) int64 {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.totalBytes[priority]
+}
+
+// 返回priority优先级的请求总次数
+func (l *RateLimiter) GetTotalRequests(priority IOPriority) int64 {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.totalRequests[priority]
+}
+
+// 请求写入bytes字节，阻塞直到令牌足够
+func (l *RateLimiter) Request(bytes int, priority IOPriority) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.totalBytes[priority] += int64(bytes)
+	l.totalRequests[priority]++
+
+	l.waiting[priority]++
+	for {
+		now := time.Now()
+		l.refill(now)
+		if l.bytesPerSecond <= 0 {
+			break
+		}
+		if l.available >= 0 && (priority == IOPriorityHigh || l.waiting[IOPriorityHigh] == 0) {
+			l.available -= float64(bytes)
+			break
+		}
+		// 最多等待一个补充周期，之后重新检查，以便及时响应速度调整和高优先级请求
+		wait := config.RateLimiterRefillPeriod
+		if l.available < 0 {
+			if need := time.Duration(-l.available / float64(l.bytesPerSecond) * float64(time.Second)); need < wait {
+				wait = need
+			}
+		}
+		if wait < time.Millisecond {
+			wait = time.Millisecond
+		}
+		l.mutex.Unlock()
+		time.Sleep(wait)
+		l.mutex.Lock()
+	}
+	l.waiting[priority]--
+}
+
+// 按经过的时间补充令牌，调用方需持有l.mutex
+func (l *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.lastRefill)
+	l.lastRefill = now
+	if l.bytesPerSecond <= 0 {
+		l.available = 0
+		return
+	}
+	l.available += elapsed.Seconds() * float64(l.bytesPerSecond)
+	if burst := config.RateLimiterRefillPeriod.Seconds() * float64(l.bytesPerSecond); l.available > burst {
+		l.available = burst
+	}
+}
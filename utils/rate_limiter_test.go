@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	const rate = 1 << 20
+	limiter := NewRateLimiter(rate)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		limiter.Request(50<<10, IOPriorityLow)
+	}
+	// 第一次请求预支令牌，之后的请求需要等待约450ms
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("unexpected elapsed time %v", elapsed)
+	}
+	if n := limiter.GetTotalBytesThrough(IOPriorityLow); n != 500<<10 {
+		t.Fatalf("expected %d bytes, got %d", 500<<10, n)
+	}
+	if n := limiter.GetTotalRequests(IOPriorityLow); n != 10 {
+		t.Fatalf("expected 10 requests, got %d", n)
+	}
+
+	// 运行时取消限速
+	limiter.SetBytesPerSecond(0)
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		limiter.Request(1<<20, IOPriorityHigh)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("expected no limit, took %v", elapsed)
+	}
+
+	// nil表示不限速
+	var nilLimiter *RateLimiter
+	nilLimiter.Request(1<<30, IOPriorityLow)
+}
+
+func TestRateLimiterPriority(t *testing.T) {
+	limiter := NewRateLimiter(1 << 20)
+	// 预支约200ms的令牌
+	limiter.Request(200<<10, IOPriorityLow)
+
+	var mutex sync.Mutex
+	var order []IOPriority
+	var wg sync.WaitGroup
+	request := func(priority IOPriority) {
+		defer wg.Done()
+		limiter.Request(1, priority)
+		mutex.Lock()
+		order = append(order, priority)
+		mutex.Unlock()
+	}
+	wg.Add(2)
+	go request(IOPriorityLow)
+	time.Sleep(20 * time.Millisecond)
+	go request(IOPriorityHigh)
+	wg.Wait()
+	if len(order) != 2 || order[0] != IOPriorityHigh {
+		t.Fatalf("expected the high priority request first, got %v", order)
+	}
+}
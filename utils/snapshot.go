@@ -0,0 +1,15 @@
+package utils
+
+// Snapshot 是数据库在某一序列号下的只读视图
+// 通过快照读取时，只能看到序列号不大于seq的写入
+type Snapshot struct {
+	seq uint64
+}
+
+func NewSnapshot(seq uint64) *Snapshot {
+	return &Snapshot{seq: seq}
+}
+
+func (s *Snapshot) Seq() uint64 {
+	return s.seq
+}
@@ -1,8 +1,11 @@
 package version
 
 import (
-	"github.com/Cauchy-NY/yldb/errors"
 	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/Cauchy-NY/yldb/config"
 	"github.com/Cauchy-NY/yldb/ikey"
@@ -12,38 +15,88 @@ import (
 )
 
 type Compaction struct {
-	// 合并的上层Level
+	// 合并的最上层Level
 	level int
-	// inputs[0] 待合并的上层SST Files
-	// inputs[1] 合并到下层的SST Files
-	inputs [2][]*FileMetaData
+	// 输出的Level，通常为level+1，动态计算Level目标大小时L0输出到baseLevel，重写最底层时与level相同
+	outputLevel int
+	// 各Level中待合并的SST Files
+	inputs [config.NumLevels][]*FileMetaData
+	// 手动触发的Compaction总是重写数据，不做trivial move
+	manual bool
+	// 只删除输入文件，不写入输出文件
+	deletionOnly bool
+	// 选取输入文件时的Version，用于分配文件编号、读取输入文件和判断删除记录能否清除
+	version *Version
+	// 序列号不大于smallestSnapshot的旧数据对所有快照都不可见，可以被清除
+	smallestSnapshot uint64
+	// 序列号大于largestSnapshot的数据对所有快照都不可见，可以交给CompactionFilter处理，没有快照时为0
+	largestSnapshot uint64
+	// 输入文件的user_key范围
+	smallest []byte
+	largest  []byte
+	// 输出Level之下一层中与输入文件范围重叠的文件，用于限制每个输出文件与其重叠的数据量
+	grandparents []*FileMetaData
+	// 合并后写入outputLevel的文件
+	outputs []*FileMetaData
 }
 
+// 只有一个输入文件且与grandparent重叠的数据不多时，直接将其移动到输出Level
 func (compaction *Compaction) isTrivialMove() bool {
-	return len(compaction.inputs[0]) == 1 && len(compaction.inputs[1]) == 0
+	return !compaction.manual && compaction.outputLevel != compaction.level &&
+		len(compaction.inputs[compaction.level]) == 1 && len(compaction.allInputs()) == 1 &&
+		totalFileSize(compaction.grandparents) <= config.MaxGrandParentOverlapBytes
 }
 
-func (compaction *Compaction) Log() {
-	log.Printf("Compaction, Level:%d\n", compaction.level)
-	for i := 0; i < len(compaction.inputs[0]); i++ {
-		log.Printf("inputs[0]: %d\n", compaction.inputs[0][i].number)
+// 返回输入文件中最新的创建时间
+func (compaction *Compaction) creationTime() int64 {
+	var creationTime int64
+	for _, file := range compaction.allInputs() {
+		if file.creationTime > creationTime {
+			creationTime = file.creationTime
+		}
+	}
+	return creationTime
+}
+
+// 返回所有Level的输入文件
+func (compaction *Compaction) allInputs() []*FileMetaData {
+	var files []*FileMetaData
+	for _, inputs := range compaction.inputs {
+		files = append(files, inputs...)
 	}
-	for i := 0; i < len(compaction.inputs[1]); i++ {
-		log.Printf("inputs[1]: %d\n", compaction.inputs[1][i].number)
+	return files
+}
+
+func (compaction *Compaction) Log() {
+	log.Printf("Compaction, Level:%d, OutputLevel:%d\n", compaction.level, compaction.outputLevel)
+	for level, inputs := range compaction.inputs {
+		for _, file := range inputs {
+			log.Printf("inputs[%d]: %d\n", level, file.number)
+		}
 	}
 }
 
 func (version *Version) WriteLevel0Table(imm *memdb.MemTable) error {
+	meta, err := version.BuildLevel0Table(imm)
+	if meta == nil || err != nil {
+		return err
+	}
+	version.AddLevel0Table(meta, nil)
+	return nil
+}
+
+// 将ImmTable写入新的SST文件，不修改Version，调用方不需要持有db.mutex
+func (version *Version) BuildLevel0Table(imm *memdb.MemTable) (*FileMetaData, error) {
 	meta := &FileMetaData{
-		allowSeeks: 1 << 30,
-		number:     version.nextFileNumber,
+		number:       version.NewFileNumber(),
+		creationTime: time.Now().Unix(),
 	}
-	version.nextFileNumber++
 
-	builder, err := sstable.NewTableBuilder(utils.TableFileName(version.tableCache.dbName, meta.number))
+	builder, err := sstable.NewTableBuilder(utils.TableFileName(version.tables.dbName, meta.number))
 	if builder == nil || err != nil {
-		return err
+		return nil, err
 	}
+	builder.SetRateLimiter(version.rateLimiter, utils.IOPriorityHigh)
 
 	it := imm.Iterator()
 	it.SeekToFirst()
@@ -56,21 +109,96 @@ func (version *Version) WriteLevel0Table(imm *memdb.MemTable) error {
 		builder.Finish()
 		meta.fileSize = uint64(builder.FileSize())
 	}
+	meta.resetAllowSeeks()
+	return meta, builder.Err()
+}
 
-	// 优化：如果新写入磁盘的ImmTable数据范围和L0层SST文件没有交集，则说明在L0层没有ImmTable内任何Key的OldValue
-	//      即由ImmTable新生成的SST文件可以写入LN层，N∈[0, MaxMemCompactLevel)，且0-N层都没有与其相交的SST文件
+// 将BuildLevel0Table生成的文件加入Version，返回文件所在的Level
+// 优化：如果新写入磁盘的ImmTable数据范围和L0层SST文件没有交集，则说明在L0层没有ImmTable内任何Key的OldValue，
+// 即由ImmTable新生成的SST文件可以写入LN层，N∈[0, MaxMemCompactLevel)，且0-N层都没有与其相交的SST文件
+// 动态计算Level目标大小时跳过L0合并目标之上不再使用的Level，N∈[0, baseLevel+MaxMemCompactLevel-1)
+// 与running中正在进行的Compaction范围重叠时只能放在L0，否则Compaction完成后输出文件会与其重叠
+// FIFO和Universal Compaction中新写入的文件总是放在L0，见level0Only
+func (version *Version) AddLevel0Table(meta *FileMetaData, running []*Compaction) int {
 	level := 0
-	if !version.overlapInLevel(level, meta.smallest.UserKey(), meta.largest.UserKey()) {
-		for ; level < config.MaxMemCompactLevel; level++ {
-			if version.overlapInLevel(level+1, meta.smallest.UserKey(), meta.largest.UserKey()) {
+	if !version.level0Only() && !version.overlapInLevel(level, meta.smallest.UserKey(), meta.largest.UserKey()) &&
+		!version.overlapRunning(running, meta.smallest.UserKey(), meta.largest.UserKey()) {
+		baseLevel, _ := version.levelTargets()
+		maxLevel := baseLevel + config.MaxMemCompactLevel - 1
+		if maxLevel > config.NumLevels-1 {
+			maxLevel = config.NumLevels - 1
+		}
+		for l := 1; l <= maxLevel; l++ {
+			if version.overlapInLevel(l, meta.smallest.UserKey(), meta.largest.UserKey()) {
 				break
 			}
+			if l >= baseLevel {
+				level = l
+			}
 		}
 	}
 
 	version.addMetaFile(level, meta)
+	return level
+}
 
-	return nil
+// FIFO Compaction按文件的新旧删除文件，Universal Compaction按sorted run的新旧合并，
+// 新文件放到更深的Level会打乱文件或sorted run的新旧顺序，只能放在L0
+func (version *Version) level0Only() bool {
+	return version.fifo() || version.universal()
+}
+
+func (version *Version) overlapRunning(running []*Compaction, smallestKey, largestKey []byte) bool {
+	for _, compaction := range running {
+		if version.cmp.Compare(smallestKey, compaction.largest) <= 0 &&
+			version.cmp.Compare(compaction.smallest, largestKey) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// 将导入的SST文件加入Version，返回文件所在的Level
+// 文件放在与其key范围不重叠的最深一层，并且该层之上的各层都不能与其重叠，
+// 这样查找时导入的数据总是先于更旧的数据被读到；与L0重叠时只能放在L0，FIFO和Universal Compaction中总是放在L0
+// 导入期间flush的文件编号比导入的文件大，其中与导入文件重叠的数据更新，只会在L0中（见ReserveRange），
+// 导入的文件放在L0时需要排在这些文件之前
+func (version *Version) IngestFile(number, fileSize uint64, smallest, largest ikey.InternalKey) int {
+	meta := &FileMetaData{
+		number:       number,
+		fileSize:     fileSize,
+		smallest:     smallest,
+		largest:      largest,
+		creationTime: time.Now().Unix(),
+	}
+	meta.resetAllowSeeks()
+	index := len(version.files[0])
+	overlapOlder := false
+	for i, file := range version.files[0] {
+		if version.cmp.Compare(smallest.UserKey(), file.largest.UserKey()) > 0 ||
+			version.cmp.Compare(largest.UserKey(), file.smallest.UserKey()) < 0 {
+			continue
+		}
+		if file.number > number {
+			index = i
+			break
+		}
+		overlapOlder = true
+	}
+	level := 0
+	if !version.level0Only() && !overlapOlder {
+		for ; level < config.NumLevels-1; level++ {
+			if version.overlapInLevel(level+1, smallest.UserKey(), largest.UserKey()) {
+				break
+			}
+		}
+	}
+	if level == 0 {
+		version.insertLevel0File(index, meta)
+	} else {
+		version.addMetaFile(level, meta)
+	}
+	return level
 }
 
 func (version *Version) overlapInLevel(level int, smallestKey, largestKey []byte) bool {
@@ -90,19 +218,21 @@ func (version *Version) overlapInLevel(level int, smallestKey, largestKey []byte
 		}
 	} else {
 		index := version.findFile(version.files[level], smallestKey)
-		if index < numFiles && version.cmp.Compare(largestKey, version.files[level][index].smallest.UserKey()) > 0 {
+		if index < numFiles && version.cmp.Compare(largestKey, version.files[level][index].smallest.UserKey()) >= 0 {
 			return true
 		}
 	}
 	return false
 }
 
-func (version Version) deleteMetaFile(level int, meta *FileMetaData) {
-	// todo 旧文件物理删除
+func (version *Version) deleteMetaFile(level int, meta *FileMetaData) {
 	numFiles := len(version.files[level])
 	for i := 0; i < numFiles; i++ {
 		if version.files[level][i].number == meta.number {
 			version.files[level] = append(version.files[level][:i], version.files[level][i+1:]...)
+			if version.fileToCompact == meta {
+				version.fileToCompact = nil
+			}
 			log.Printf("DeleteFile, Level:%d, Num:%d, %s-%s",
 				level, meta.number,
 				string(meta.smallest.UserKey()),
@@ -113,6 +243,19 @@ func (version Version) deleteMetaFile(level int, meta *FileMetaData) {
 	}
 }
 
+// 将文件插入L0中index的位置，index之后的文件比该文件更新
+func (version *Version) insertLevel0File(index int, meta *FileMetaData) {
+	log.Printf("AddFile, Level:0, Num:%d, %s-%s",
+		meta.number,
+		string(meta.smallest.UserKey()),
+		string(meta.largest.UserKey()),
+	)
+	files := make([]*FileMetaData, 0, len(version.files[0])+1)
+	files = append(files, version.files[0][:index]...)
+	files = append(files, meta)
+	version.files[0] = append(files, version.files[0][index:]...)
+}
+
 func (version *Version) addMetaFile(level int, meta *FileMetaData) {
 	log.Printf("AddFile, Level:%d, Num:%d, %s-%s",
 		level, meta.number,
@@ -121,7 +264,7 @@ func (version *Version) addMetaFile(level int, meta *FileMetaData) {
 	)
 
 	if level == 0 {
-		// level0不需要归并
+		// level0不需要归并，按加入的顺序排列，越靠后的文件越新
 		version.files[level] = append(version.files[level], meta)
 	} else {
 		numFiles := len(version.files[level])
@@ -137,130 +280,669 @@ func (version *Version) addMetaFile(level int, meta *FileMetaData) {
 	}
 }
 
-func (version *Version) DoCompactionWork() bool {
-	compaction := version.pickCompaction()
+// 为后台Compaction选取与running中正在进行的Compaction不冲突的输入文件，没有可做的Compaction时返回nil
+// 选中的文件被标记为正在合并，调用方需持有db.mutex，Compaction结束后调用Release
+func (version *Version) PickCompaction(running []*Compaction, smallestSnapshot, largestSnapshot uint64) *Compaction {
+	var compaction *Compaction
+	if version.universal() {
+		compaction = version.pickUniversalCompaction(running)
+	} else if version.fifo() {
+		compaction = version.pickFIFOCompaction(running)
+	} else {
+		compaction = version.pickLeveledCompaction(running)
+	}
 	if compaction == nil {
-		return false
+		return nil
+	}
+	version.startCompaction(compaction, smallestSnapshot, largestSnapshot)
+	return compaction
+}
+
+// 记录Compaction开始时的Version和快照，并将输入文件标记为正在合并
+func (version *Version) startCompaction(compaction *Compaction, smallestSnapshot, largestSnapshot uint64) {
+	compaction.version = version
+	compaction.smallestSnapshot = smallestSnapshot
+	compaction.largestSnapshot = largestSnapshot
+	for _, inputs := range compaction.inputs {
+		for _, file := range inputs {
+			file.beingCompacted = true
+		}
+	}
+}
+
+// 依次尝试score超过1.0的各Level（score高的优先），都不可做时再处理无效查找过多的文件
+func (version *Version) pickLeveledCompaction(running []*Compaction) *Compaction {
+	for _, level := range version.levelsByScore() {
+		if compaction := version.pickLevelCompaction(level, running); compaction != nil {
+			return compaction
+		}
+	}
+	if version.fileToCompact != nil {
+		compaction := version.newCompaction(version.fileToCompactLevel, []*FileMetaData{version.fileToCompact}, running)
+		if compaction != nil {
+			version.fileToCompact = nil
+		}
+		return compaction
+	}
+	return nil
+}
+
+func (version *Version) pickLevelCompaction(level int, running []*Compaction) *Compaction {
+	if level == 0 {
+		// 从最旧的文件开始，只合并与其直接或间接重叠的L0文件
+		for _, file := range version.files[0] {
+			if file.beingCompacted {
+				continue
+			}
+			if compaction := version.newCompaction(0, []*FileMetaData{file}, running); compaction != nil {
+				return compaction
+			}
+		}
+		return nil
+	}
+	// 从上一次compaction之后的文件开始选取文件进行compaction
+	// 上一次选取的compaction的文件记录在version.compactPointer[level]，到该层末尾后从第一个文件开始
+	files := version.files[level]
+	start := 0
+	if pointer := version.compactPointer[level]; pointer != nil {
+		for start < len(files) && version.cmp.Compare(files[start].largest.UserKey(), pointer.UserKey()) <= 0 {
+			start++
+		}
+	}
+	for i := 0; i < len(files); i++ {
+		file := files[(start+i)%len(files)]
+		if compaction := version.newCompaction(level, []*FileMetaData{file}, running); compaction != nil {
+			version.compactPointer[level] = file.largest
+			return compaction
+		}
+	}
+	return nil
+}
+
+// 以inputs为上层输入构造level到level+1的Compaction，输入文件正在被合并或与running冲突时返回nil
+// L0合并到level0OutputLevel返回的Level
+func (version *Version) newCompaction(level int, inputs []*FileMetaData, running []*Compaction) *Compaction {
+	smallest, largest := version.keyRange(inputs)
+	if level == 0 {
+		// L0中的文件之间可能重叠，需要加入所有与其直接或间接重叠的文件，
+		// 否则未被选中的更旧的文件会留在输出文件之上
+		inputs = version.overlappingInputs(0, smallest, largest)
+		smallest, largest = version.keyRange(inputs)
+	}
+	outputLevel := level + 1
+	if level == 0 {
+		outputLevel = version.level0OutputLevel(smallest, largest)
+	}
+	// 选择输出层范围重叠的文件进行compaction
+	outputs := version.overlappingInputs(outputLevel, smallest, largest)
+
+	// 在不增加输出层输入文件的前提下，尽量扩大上层的输入文件
+	if len(outputs) > 0 {
+		allSmallest, allLargest := version.keyRange(append(append([]*FileMetaData(nil), inputs...), outputs...))
+		expanded := version.overlappingInputs(level, allSmallest, allLargest)
+		if len(expanded) > len(inputs) && totalFileSize(expanded)+totalFileSize(outputs) < config.ExpandedCompactionByteSizeLimit {
+			newSmallest, newLargest := version.keyRange(expanded)
+			if (level != 0 || version.level0OutputLevel(newSmallest, newLargest) == outputLevel) &&
+				len(version.overlappingInputs(outputLevel, newSmallest, newLargest)) == len(outputs) {
+				inputs = expanded
+			}
+		}
+	}
+
+	compaction := &Compaction{level: level, outputLevel: outputLevel}
+	compaction.inputs[level] = append(compaction.inputs[level], inputs...)
+	compaction.inputs[outputLevel] = outputs
+	compaction.smallest, compaction.largest = version.keyRange(compaction.allInputs())
+	version.setupGrandparents(compaction)
+
+	for _, file := range compaction.allInputs() {
+		if file.beingCompacted {
+			return nil
+		}
+	}
+	for _, other := range running {
+		if version.conflict(compaction, other) {
+			return nil
+		}
+	}
+	return compaction
+}
+
+// 记录输出Level之下一层中与Compaction的输入文件范围重叠的文件，输出到L0时不需要
+func (version *Version) setupGrandparents(compaction *Compaction) {
+	if compaction.outputLevel == 0 || compaction.outputLevel+1 >= config.NumLevels {
+		return
 	}
+	smallest, largest := version.keyRange(compaction.allInputs())
+	compaction.grandparents = version.overlappingInputs(compaction.outputLevel+1, smallest, largest)
+}
+
+// 写入Compaction的输出文件，不修改任何Version，调用方不需要持有db.mutex
+// 输入数据较多时划分为最多maxSubcompactions个互不重叠的key范围，由多个goroutine并行合并
+// 失败时已写入的输出文件不会被引用
+func (compaction *Compaction) Run(maxSubcompactions int) error {
 	log.Printf("DoCompactionWork begin\n")
 	defer log.Printf("DoCompactionWork end\n")
 	compaction.Log()
-	if compaction.isTrivialMove() {
-		// Move file to next level
-		version.deleteMetaFile(compaction.level, compaction.inputs[0][0])
-		version.addMetaFile(compaction.level+1, compaction.inputs[0][0])
-		return true
+	if compaction.isTrivialMove() || compaction.deletionOnly {
+		return nil
 	}
 
-	var list []*FileMetaData
-	var cur ikey.InternalKey
-	it := version.iterator(compaction)
-	for it.SeekToFirst(); it.Valid(); it.Next() {
-		var meta FileMetaData
-		meta.allowSeeks = 1 << 30
-		meta.number = version.nextFileNumber
-		version.nextFileNumber++
-		builder, _ := sstable.NewTableBuilder(utils.TableFileName(version.tableCache.dbName, meta.number))
+	if compaction.outputLevel == 0 {
+		maxSubcompactions = 1
+	}
+	subcompactions := compaction.splitSubcompactions(maxSubcompactions)
+	if len(subcompactions) == 1 {
+		subcompactions[0].run(compaction)
+	} else {
+		var wg sync.WaitGroup
+		for _, sub := range subcompactions {
+			wg.Add(1)
+			go func(sub *subcompaction) {
+				defer wg.Done()
+				sub.run(compaction)
+			}(sub)
+		}
+		wg.Wait()
+	}
 
-		meta.smallest = it.InternalKey()
-		for ; it.Valid(); it.Next() {
-			if cur != nil {
-				// 去除重复的记录
-				ret := version.cmp.Compare(it.InternalKey().UserKey(), cur.UserKey())
-				if ret == 0 {
-					continue
-				} else if ret < 0 {
-					log.Printf("Error: %v", errors.ErrMajorCompactionError)
-					log.Fatalf("%s < %s", string(it.InternalKey().UserKey()), string(cur.UserKey()))
-				}
-				cur = it.InternalKey()
-			}
-			meta.largest = it.InternalKey()
-			builder.Add(it.InternalKey(), it.Value())
-			if builder.FileSize() > config.MaxFileSize {
+	// 各范围按key顺序排列，输出文件依次拼接后仍然有序且互不重叠
+	var err error
+	for _, sub := range subcompactions {
+		compaction.outputs = append(compaction.outputs, sub.outputs...)
+		if err == nil {
+			err = sub.err
+		}
+	}
+	return err
+}
+
+// subcompaction 是Compaction中user_key属于[start, limit)的部分，start/limit为nil表示不限
+type subcompaction struct {
+	start   []byte
+	limit   []byte
+	outputs []*FileMetaData
+	err     error
+}
+
+func (sub *subcompaction) run(compaction *Compaction) {
+	sub.outputs, sub.err = compaction.writeOutputs(sub.start, sub.limit)
+}
+
+// 以输入文件中Data Block的边界将Compaction划分为互不重叠的key范围，各范围的输入数据量大致相同
+// 每个范围的输入数据不少于config.MaxFileSize，避免产生过多的小文件
+// 同一个user_key的所有记录总是属于同一个范围
+func (compaction *Compaction) splitSubcompactions(maxSubcompactions int) []*subcompaction {
+	files := compaction.allInputs()
+	n := int(totalFileSize(files) / config.MaxFileSize)
+	if n > maxSubcompactions {
+		n = maxSubcompactions
+	}
+	if n <= 1 {
+		return []*subcompaction{{}}
+	}
+
+	type boundary struct {
+		key  []byte
+		size uint64
+	}
+	var boundaries []boundary
+	var total uint64
+	version := compaction.version
+	for _, file := range files {
+		version.tables.dataBlocks(file.number, func(lastKey ikey.InternalKey, size uint64) {
+			boundaries = append(boundaries, boundary{key: lastKey.UserKey(), size: size})
+			total += size
+		})
+	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		return version.cmp.Compare(boundaries[i].key, boundaries[j].key) < 0
+	})
+
+	var subcompactions []*subcompaction
+	var start []byte
+	var size uint64
+	for _, b := range boundaries {
+		size += b.size
+		if size >= total/uint64(n) && len(subcompactions) < n-1 && (start == nil || version.cmp.Compare(b.key, start) > 0) {
+			subcompactions = append(subcompactions, &subcompaction{start: start, limit: b.key})
+			start = b.key
+			size = 0
+		}
+	}
+	return append(subcompactions, &subcompaction{start: start})
+}
+
+// 清除输入文件的正在合并标记，调用方需持有db.mutex
+func (compaction *Compaction) Release() {
+	for _, inputs := range compaction.inputs {
+		for _, file := range inputs {
+			file.beingCompacted = false
+		}
+	}
+}
+
+// 返回在当前Version上应用已完成的Compaction之后的新Version，调用方需持有db.mutex
+// Compaction进行期间当前Version可能已经加入了新文件，因此不能直接使用Compaction开始时的Version
+func (version *Version) Apply(compaction *Compaction) *Version {
+	copyVersion := version.Copy()
+	copyVersion.applyCompaction(compaction)
+	return copyVersion
+}
+
+func (version *Version) applyCompaction(compaction *Compaction) {
+	if compaction.isTrivialMove() {
+		// Move file to next level
+		file := compaction.inputs[compaction.level][0]
+		version.deleteMetaFile(compaction.level, file)
+		version.addMetaFile(compaction.outputLevel, file)
+		return
+	}
+	// L0中的文件按由旧到新的顺序排列，输出到L0的文件需要放在输入文件原来的位置，
+	// 以保持在Compaction期间flush的更新的文件之前
+	index := len(version.files[0])
+	if compaction.outputLevel == 0 {
+		for i, file := range version.files[0] {
+			if containsFile(compaction.inputs[0], file) {
+				index = i
 				break
 			}
 		}
-		builder.Finish()
-		meta.fileSize = uint64(builder.FileSize())
+	}
+	for level, inputs := range compaction.inputs {
+		for _, file := range inputs {
+			version.deleteMetaFile(level, file)
+		}
+	}
+	for _, meta := range compaction.outputs {
+		if compaction.outputLevel == 0 {
+			version.insertLevel0File(index, meta)
+			index++
+		} else {
+			version.addMetaFile(compaction.outputLevel, meta)
+		}
+	}
+}
 
-		list = append(list, &meta)
+func containsFile(files []*FileMetaData, meta *FileMetaData) bool {
+	for _, file := range files {
+		if file == meta {
+			return true
+		}
 	}
 	return false
 }
 
-func (version *Version) pickCompaction() *Compaction {
-	var compaction Compaction
-	compaction.level = version.pickCompactionLevel()
-	if compaction.level < 0 {
+// 选取将level中与[start, end]重叠的文件手动合并到outputLevel的Compaction，没有需要合并的文件时返回nil
+// 输入文件正在被合并或与running冲突时busy为true，调用方需等待其结束后重试
+// 选中的文件被标记为正在合并，调用方需持有db.mutex，Compaction结束后调用Release
+func (version *Version) PickManualCompaction(level, outputLevel int, start, end []byte, running []*Compaction,
+	smallestSnapshot, largestSnapshot uint64) (compaction *Compaction, busy bool) {
+	compaction = version.manualCompaction(level, outputLevel, start, end)
+	if compaction == nil {
+		return nil, false
+	}
+	for _, file := range compaction.allInputs() {
+		if file.beingCompacted {
+			return nil, true
+		}
+	}
+	for _, other := range running {
+		if version.conflict(compaction, other) {
+			return nil, true
+		}
+	}
+	version.startCompaction(compaction, smallestSnapshot, largestSnapshot)
+	return compaction, false
+}
+
+// 返回占用[smallest, largest]范围内所有Level的Compaction，用于导入文件：
+// 加入running之后与该范围重叠的Compaction不会被选取，flush的与该范围重叠的文件只会放在L0
+func (version *Version) ReserveRange(smallest, largest []byte) *Compaction {
+	return &Compaction{
+		level:       0,
+		outputLevel: config.NumLevels - 1,
+		version:     version,
+		smallest:    smallest,
+		largest:     largest,
+	}
+}
+
+// 两个Compaction读写的Level区间相交且key范围重叠时冲突，不能同时进行
+func (version *Version) conflict(a, b *Compaction) bool {
+	if a.level > b.outputLevel || b.level > a.outputLevel {
+		return false
+	}
+	return version.cmp.Compare(a.smallest, b.largest) <= 0 && version.cmp.Compare(b.smallest, a.largest) <= 0
+}
+
+// 返回手动Compaction逐层合并到的最底层：L0合并的目标Level和含有[start, end]范围数据的最深一层中较深的一层
+func (version *Version) CompactRangeMaxLevel(start, end []byte) int {
+	maxLevel, _ := version.levelTargets()
+	for level := maxLevel + 1; level < config.NumLevels; level++ {
+		if len(version.overlappingInputs(level, start, end)) > 0 {
+			maxLevel = level
+		}
+	}
+	return maxLevel
+}
+
+// 构造将level中与[start, end]重叠的文件合并到outputLevel的Compaction，没有需要合并的文件时返回nil
+func (version *Version) manualCompaction(level, outputLevel int, start, end []byte) *Compaction {
+	inputs := version.overlappingInputs(level, start, end)
+	if len(inputs) == 0 {
 		return nil
 	}
-	var smallest, largest ikey.InternalKey
-	if compaction.level == 0 {
-		// 简化处理：Level0整层进行Compaction
-		compaction.inputs[0] = append(compaction.inputs[0], version.files[compaction.level]...)
-		smallest = compaction.inputs[0][0].smallest
-		largest = compaction.inputs[0][0].largest
-		for _, file := range compaction.inputs[0] {
-			if version.cmp.Compare(file.largest.UserKey(), largest.UserKey()) > 0 {
-				largest = file.largest
-			}
-			if version.cmp.Compare(file.smallest.UserKey(), smallest.UserKey()) < 0 {
-				smallest = file.smallest
+	compaction := &Compaction{level: level, outputLevel: outputLevel, manual: true}
+	compaction.inputs[level] = inputs
+	if outputLevel != level {
+		smallest, largest := version.keyRange(inputs)
+		compaction.inputs[outputLevel] = version.overlappingInputs(outputLevel, smallest, largest)
+	}
+	version.setupGrandparents(compaction)
+	compaction.smallest, compaction.largest = version.keyRange(compaction.allInputs())
+	return compaction
+}
+
+// 按internal_key顺序归并输入文件中user_key属于[start, limit)的记录，清除不再被任何快照读到的记录和过期的记录，
+// 用CompactionFilter过滤保留下来的记录，按config.MaxFileSize和与grandparent重叠的数据量切分输出文件
+func (compaction *Compaction) writeOutputs(start, limit []byte) ([]*FileMetaData, error) {
+	version := compaction.version
+	smallestSnapshot := compaction.smallestSnapshot
+	var outputs []*FileMetaData
+	var meta *FileMetaData
+	var builder *sstable.TableBuilder
+	finish := func() error {
+		builder.Finish()
+		meta.fileSize = uint64(builder.FileSize())
+		meta.resetAllowSeeks()
+		outputs = append(outputs, meta)
+		err := builder.Err()
+		builder = nil
+		return err
+	}
+
+	filter := version.opts.GetCompactionFilter()
+	var filterContext *utils.CompactionFilterContext
+	if filter != nil {
+		filterContext = compaction.filterContext()
+	}
+
+	largestSnapshot := compaction.largestSnapshot
+	now := time.Now().UnixNano()
+	// 当前输出文件已经越过的grandparent文件的位置和重叠的数据量
+	grandparentIndex := 0
+	seenKey := false
+	var overlappedBytes uint64
+	shouldStopBefore := func(ukey []byte) bool {
+		grandparents := compaction.grandparents
+		for grandparentIndex < len(grandparents) &&
+			version.cmp.Compare(ukey, grandparents[grandparentIndex].largest.UserKey()) > 0 {
+			if seenKey {
+				overlappedBytes += grandparents[grandparentIndex].fileSize
 			}
+			grandparentIndex++
+		}
+		seenKey = true
+		if overlappedBytes > config.MaxGrandParentOverlapBytes {
+			overlappedBytes = 0
+			return true
 		}
+		return false
+	}
+
+	var currentKey []byte
+	// 当前user_key上一条记录的序列号，InternalKeySeqNumMax表示还没有记录
+	lastSeqForKey := ikey.InternalKeySeqNumMax
+	it, err := version.iterator(compaction)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	if start == nil {
+		it.SeekToFirst()
 	} else {
-		// 从上一次compaction之后的文件开始选取文件进行compaction
-		// 上一次选取的compaction的文件记录在version.compactPointer[level]
-		for _, file := range version.files[compaction.level] {
-			if version.compactPointer[compaction.level] == nil ||
-				version.cmp.Compare(file.largest.UserKey(), version.compactPointer[compaction.level].UserKey()) > 0 {
-				compaction.inputs[0] = append(compaction.inputs[0], file)
-				break
+		it.Seek(start)
+	}
+	for ; it.Valid(); it.Next() {
+		internalKey := it.InternalKey()
+		if limit != nil && version.cmp.Compare(internalKey.UserKey(), limit) >= 0 {
+			break
+		}
+		if currentKey == nil || version.cmp.Compare(internalKey.UserKey(), currentKey) != 0 {
+			currentKey = append(currentKey[:0], internalKey.UserKey()...)
+			lastSeqForKey = ikey.InternalKeySeqNumMax
+			// 同一个user_key的记录必须写入同一个文件，否则输出Level中文件的key范围会重叠
+			// L0中每个文件是一个独立的sorted run，输出到L0时不切分文件
+			stop := shouldStopBefore(internalKey.UserKey())
+			if builder != nil && compaction.outputLevel > 0 && (builder.FileSize() > config.MaxFileSize || stop) {
+				if err := finish(); err != nil {
+					return outputs, err
+				}
 			}
 		}
-		// 上一次compaction的文件是该层最后一个文件，则将选择该层第一个文件进行compaction
-		if len(compaction.inputs[0]) == 0 {
-			compaction.inputs[0] = append(compaction.inputs[0], version.files[compaction.level][0])
+
+		value := it.Value()
+		if internalKey.Kind() == ikey.InternalKeyKindSetWithTTL {
+			if _, live := ikey.LiveValue(internalKey.Kind(), value, now); !live {
+				// 过期的记录对任何读取都等同于删除记录
+				internalKey = ikey.MakeInternalKey(nil, internalKey.UserKey(), ikey.InternalKeyKindDelete, internalKey.SeqNum())
+				value = nil
+			}
+		}
+
+		drop := false
+		if lastSeqForKey <= smallestSnapshot {
+			// 该user_key有更新的记录且对所有快照可见，这条记录不会再被读到
+			drop = true
+		} else if internalKey.Kind() == ikey.InternalKeyKindDelete && internalKey.SeqNum() <= smallestSnapshot &&
+			compaction.isBaseLevelForKey(internalKey.UserKey()) {
+			// 删除记录对所有快照可见，且更下层没有该user_key的数据，删除记录本身也不再需要
+			drop = true
+		}
+		newest := lastSeqForKey == ikey.InternalKeySeqNumMax
+		lastSeqForKey = internalKey.SeqNum()
+		if drop {
+			continue
+		}
+
+		if filter != nil && newest && internalKey.Kind() != ikey.InternalKeyKindDelete && internalKey.SeqNum() > largestSnapshot {
+			// 只处理user_key最新的、不被任何快照读到的记录，修改它不会影响快照读到的数据
+			userValue, _ := ikey.LiveValue(internalKey.Kind(), value, now)
+			decision, newValue := filter.Filter(filterContext, internalKey.UserKey(), userValue)
+			switch decision {
+			case utils.CompactionFilterRemove:
+				if internalKey.SeqNum() <= smallestSnapshot && compaction.isBaseLevelForKey(internalKey.UserKey()) {
+					continue
+				}
+				// 更旧的记录仍然需要被快照读到，或更下层还有该user_key的旧数据，需要写入删除记录将其覆盖
+				internalKey = ikey.MakeInternalKey(nil, internalKey.UserKey(), ikey.InternalKeyKindDelete, internalKey.SeqNum())
+				value = nil
+			case utils.CompactionFilterChangeValue:
+				value = newValue
+				if internalKey.Kind() == ikey.InternalKeyKindSetWithTTL {
+					// 修改value时保留原来的过期时间
+					expiration, _, _ := ikey.DecodeTTLValue(it.Value())
+					value = ikey.EncodeTTLValue(newValue, expiration)
+				}
+			}
+		}
+
+		if builder == nil {
+			meta = &FileMetaData{number: version.NewFileNumber(), creationTime: compaction.creationTime()}
+			var err error
+			builder, err = sstable.NewTableBuilder(utils.TableFileName(version.tables.dbName, meta.number))
+			if err != nil {
+				return outputs, err
+			}
+			builder.SetRateLimiter(version.rateLimiter, utils.IOPriorityLow)
+			meta.smallest = internalKey
+		}
+		meta.largest = internalKey
+		builder.Add(internalKey, value)
+	}
+	if builder != nil {
+		if err := finish(); err != nil {
+			return outputs, err
 		}
-		smallest = compaction.inputs[0][0].smallest
-		largest = compaction.inputs[0][0].largest
 	}
+	return outputs, nil
+}
 
-	// 选择下一次范围重叠的文件进行compaction
-	for _, file := range version.files[compaction.level+1] {
-		if version.cmp.Compare(file.largest.UserKey(), smallest.UserKey()) < 0 ||
-			version.cmp.Compare(file.smallest.UserKey(), largest.UserKey()) > 0 {
-			// "file"的key在合并范围之外，跳过
-		} else {
-			compaction.inputs[1] = append(compaction.inputs[1], file)
+// 返回传给CompactionFilter的Compaction信息
+func (compaction *Compaction) filterContext() *utils.CompactionFilterContext {
+	version := compaction.version
+	files := compaction.allInputs()
+	numFiles := 0
+	for level := 0; level < config.NumLevels; level++ {
+		numFiles += len(version.files[level])
+	}
+	bottommost := compaction.outputLevel > 0 || len(compaction.inputs[0]) == len(version.files[0])
+	smallest, largest := version.keyRange(files)
+	for level := compaction.outputLevel + 1; bottommost && level < config.NumLevels; level++ {
+		bottommost = len(version.overlappingInputs(level, smallest, largest)) == 0
+	}
+	return &utils.CompactionFilterContext{
+		Level:              compaction.level,
+		OutputLevel:        compaction.outputLevel,
+		IsFullCompaction:   len(files) == numFiles,
+		IsBottommostLevel:  bottommost,
+		IsManualCompaction: compaction.manual,
+	}
+}
+
+// 比输入文件更旧的文件中都没有user_key的数据时返回true
+func (compaction *Compaction) isBaseLevelForKey(ukey []byte) bool {
+	if compaction.outputLevel == 0 && len(compaction.inputs[0]) < len(compaction.version.files[0]) {
+		// 保守处理：L0中还有未参与合并、可能更旧的文件
+		return false
+	}
+	return compaction.version.isBaseLevelForKey(compaction.outputLevel, ukey)
+}
+
+// level之下的各层中都没有user_key的数据时返回true
+func (version *Version) isBaseLevelForKey(level int, ukey []byte) bool {
+	for l := level + 1; l < config.NumLevels; l++ {
+		for _, file := range version.files[l] {
+			if version.cmp.Compare(ukey, file.smallest.UserKey()) >= 0 &&
+				version.cmp.Compare(ukey, file.largest.UserKey()) <= 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 返回level中与[start, end]重叠的文件，start/end为nil表示不限
+// L0中的文件之间可能重叠，范围会扩大到所选文件的并集，保证L0中未被选中的文件不含该范围内的key
+func (version *Version) overlappingInputs(level int, start, end []byte) []*FileMetaData {
+	var inputs []*FileMetaData
+	files := version.files[level]
+	for i := 0; i < len(files); {
+		file := files[i]
+		i++
+		if start != nil && version.cmp.Compare(file.largest.UserKey(), start) < 0 ||
+			end != nil && version.cmp.Compare(file.smallest.UserKey(), end) > 0 {
+			continue
+		}
+		inputs = append(inputs, file)
+		if level == 0 {
+			if start != nil && version.cmp.Compare(file.smallest.UserKey(), start) < 0 {
+				start = file.smallest.UserKey()
+				inputs = inputs[:0]
+				i = 0
+			} else if end != nil && version.cmp.Compare(file.largest.UserKey(), end) > 0 {
+				end = file.largest.UserKey()
+				inputs = inputs[:0]
+				i = 0
+			}
+		}
+	}
+	return inputs
+}
+
+// 返回文件集合的user_key范围
+func (version *Version) keyRange(files []*FileMetaData) ([]byte, []byte) {
+	smallest := files[0].smallest.UserKey()
+	largest := files[0].largest.UserKey()
+	for _, file := range files[1:] {
+		if version.cmp.Compare(file.smallest.UserKey(), smallest) < 0 {
+			smallest = file.smallest.UserKey()
+		}
+		if version.cmp.Compare(file.largest.UserKey(), largest) > 0 {
+			largest = file.largest.UserKey()
 		}
 	}
-	return &compaction
+	return smallest, largest
 }
 
-func (version *Version) pickCompactionLevel() int {
-	// 通过计算每层的score，选出最急需Compaction的Level
-	// 若第LevelN得分最大且大于1.0，则第N层为所选Compaction的Level
-	// Level0分数计算和该层文件数相关，其他各层分数计算与文件大小相关
-	// 若所有Level的score均小于1.0，则返回-1
-	compactionLevel := -1
-	bestScore := 1.0
-	score := 0.0
+// 通过计算每层的score，按score由高到低返回score超过1.0、需要Compaction的Level
+// Level0分数计算和该层文件数相关，其他各层分数计算与文件大小和该层的目标大小相关
+func (version *Version) levelsByScore() []int {
+	var levels []int
+	scores := make(map[int]float64)
+	_, targets := version.levelTargets()
 	for level := 0; level < config.NumLevels-1; level++ {
+		score := 0.0
 		if level == 0 {
 			score = float64(len(version.files[0])) / float64(config.L0CompactionTrigger)
 		} else {
-			score = float64(totalFileSize(version.files[level])) / maxBytesForLevel(level)
+			score = float64(totalFileSize(version.files[level])) / targets[level]
 		}
+		if score > 1.0 {
+			levels = append(levels, level)
+			scores[level] = score
+		}
+	}
+	sort.SliceStable(levels, func(i, j int) bool {
+		return scores[levels[i]] > scores[levels[j]]
+	})
+	return levels
+}
+
+// 有Level的score超过1.0或有文件因无效查找过多需要合并时返回true，
+// Universal Compaction中sorted run的数量达到config.L0CompactionTrigger时返回true
+func (version *Version) NeedsCompaction() bool {
+	if version.universal() {
+		return version.needsUniversalCompaction()
+	}
+	if version.fifo() {
+		return len(version.fifoObsoleteFiles(time.Now())) > 0
+	}
+	return len(version.levelsByScore()) > 0 || version.fileToCompact != nil
+}
 
-		if score > bestScore {
-			bestScore = score
-			compactionLevel = level
+// 估算使各Level回到目标大小需要合并的数据量，用于调节写入速度
+// Level0文件数量达到触发值时计入Level0的全部数据，之下各层超出目标大小的部分合并到下一层时，
+// 按两层的大小比例计入下一层需要一起重写的数据；Universal Compaction计入选取的Compaction会合并的sorted run，FIFO Compaction为0
+func (version *Version) EstimatedPendingCompactionBytes() uint64 {
+	if version.fifo() {
+		return 0
+	}
+	if version.universal() {
+		compaction := version.pickUniversalCompaction(nil)
+		if compaction == nil {
+			return 0
 		}
+		return totalFileSize(compaction.allInputs())
+	}
 
+	_, targets := version.levelTargets()
+	var pending, incoming float64
+	if len(version.files[0]) >= config.L0CompactionTrigger {
+		incoming = float64(totalFileSize(version.files[0]))
+		pending = incoming
+	}
+	for level := 1; level < config.NumLevels-1; level++ {
+		size := float64(totalFileSize(version.files[level])) + incoming
+		incoming = 0
+		if size > targets[level] {
+			incoming = size - targets[level]
+			ratio := float64(totalFileSize(version.files[level+1])) / size
+			pending += incoming * (ratio + 1)
+		}
 	}
-	return compactionLevel
+	return uint64(pending)
 }
 
 func totalFileSize(files []*FileMetaData) uint64 {
@@ -274,23 +956,69 @@ func totalFileSize(files []*FileMetaData) uint64 {
 func maxBytesForLevel(level int) float64 {
 	result := config.L1FileMaxBytes
 	for level > 1 {
-		result *= 10
+		result *= config.LevelSizeMultiplier
 		level--
 	}
 	return result
 }
 
-func (version *Version) iterator(c *Compaction) *MergeIterator {
-	var list []*sstable.TableIterator
-	for i := 0; i < len(c.inputs[0]); i++ {
-		list = append(list, version.tableCache.iterator(c.inputs[0][i].number))
+// 返回L0合并的目标Level（baseLevel）和L1及之下各层的目标大小
+// 动态计算时最底层的目标大小为各层实际大小的最大值，向上每层为下一层的1/LevelSizeMultiplier，
+// baseLevel为目标大小不超过config.L1FileMaxBytes的最深一层，其上的各层目标大小很小，其中的数据会被尽快合并到下层
+func (version *Version) levelTargets() (int, [config.NumLevels]float64) {
+	var targets [config.NumLevels]float64
+	if !version.opts.GetLevelCompactionDynamicLevelBytes() {
+		for level := 1; level < config.NumLevels; level++ {
+			targets[level] = maxBytesForLevel(level)
+		}
+		return 1, targets
+	}
+
+	var maxSize uint64
+	for level := 1; level < config.NumLevels; level++ {
+		if size := totalFileSize(version.files[level]); size > maxSize {
+			maxSize = size
+		}
+	}
+	lastLevel := config.NumLevels - 1
+	targets[lastLevel] = math.Max(float64(maxSize), config.L1FileMaxBytes)
+	baseLevel := lastLevel
+	for level := lastLevel - 1; level >= 1; level-- {
+		targets[level] = targets[level+1] / config.LevelSizeMultiplier
+		if targets[level+1] > config.L1FileMaxBytes {
+			baseLevel = level
+		}
 	}
-	for i := 0; i < len(c.inputs[1]); i++ {
-		list = append(list, version.tableCache.iterator(c.inputs[1][i].number))
+	return baseLevel, targets
+}
+
+// 返回L0与[smallest, largest]范围内的数据合并的输出Level
+// 通常为baseLevel，但baseLevel之上不再使用的Level中仍有与该范围重叠的更旧的数据时，
+// 只能输出到其中最上面的一层，否则查找时会先读到更旧的数据
+func (version *Version) level0OutputLevel(smallest, largest []byte) int {
+	baseLevel, _ := version.levelTargets()
+	for level := 1; level < baseLevel; level++ {
+		if version.overlapInLevel(level, smallest, largest) {
+			return level
+		}
 	}
+	return baseLevel
+}
+
+// 返回合并所有输入文件的迭代器，输入文件无法打开时返回错误，使用完毕后需要调用Close
+func (version *Version) iterator(c *Compaction) (*MergeIterator, error) {
 	it := &MergeIterator{
-		list: list,
-		cmp:  version.cmp,
+		cmp:    ikey.NewInternalKeyComparator(version.cmp),
+		tables: version.tables,
+	}
+	for _, file := range c.allInputs() {
+		cached, err := version.tables.findTable(file.number)
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		it.list = append(it.list, cached.table.Iterator())
+		it.pinned = append(it.pinned, cached)
 	}
-	return it
+	return it, nil
 }
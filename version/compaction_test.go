@@ -0,0 +1,270 @@
+package version
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/config"
+	"github.com/Cauchy-NY/yldb/ikey"
+	"github.com/Cauchy-NY/yldb/memdb"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var dbName03 = "../test_data/test_version/03"
+
+func TestSubcompactions(t *testing.T) {
+	_ = os.RemoveAll(dbName03)
+	_ = os.MkdirAll(dbName03, 0755)
+	version := NewVersion(dbName03, nil)
+
+	// 5个L0文件都包含全部key，序列号越大的文件越新
+	const numTables, numKeys = 5, 3000
+	value := bytes.Repeat([]byte("v"), 512)
+	for table := 0; table < numTables; table++ {
+		memTable := memdb.NewMemTable(nil)
+		for i := 0; i < numKeys; i++ {
+			key := ikey.MakeInternalKey(nil, []byte(fmt.Sprintf("key%06d", i)), ikey.InternalKeyKindSet, uint64(table*numKeys+i+1))
+			_ = memTable.Set(key, append([]byte(fmt.Sprintf("%d-", table)), value...))
+		}
+		meta, err := version.BuildLevel0Table(memTable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		version.addMetaFile(0, meta)
+	}
+
+	compaction := version.PickCompaction(nil, numTables*numKeys, 0)
+	if compaction == nil || compaction.level != 0 {
+		t.Fatal("expected a level0 compaction")
+	}
+	// 输入数据约8MB，每个范围至少config.MaxFileSize，只能划分为3个范围
+	if n := len(compaction.splitSubcompactions(4)); n != 3 {
+		t.Fatalf("expected 3 subcompactions, got %d", n)
+	}
+	if err := compaction.Run(4); err != nil {
+		t.Fatal(err)
+	}
+	version = version.Apply(compaction)
+	compaction.Release()
+
+	if n := version.NumLevelFiles(0); n != 0 {
+		t.Fatalf("expected empty level0, got %d files", n)
+	}
+	files := version.files[1]
+	for i := 1; i < len(files); i++ {
+		if version.cmp.Compare(files[i-1].largest.UserKey(), files[i].smallest.UserKey()) >= 0 {
+			t.Fatalf("level1 files %d and %d overlap", files[i-1].number, files[i].number)
+		}
+	}
+	// 每个key只保留最新的一条记录
+	count := 0
+	iterators, release := version.Iterators()
+	for _, it := range iterators {
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			count++
+		}
+	}
+	release()
+	if count != numKeys {
+		t.Fatalf("expected %d records, got %d", numKeys, count)
+	}
+	for i := 0; i < numKeys; i++ {
+		got, err := version.Get([]byte(fmt.Sprintf("key%06d", i)))
+		if err != nil || !bytes.HasPrefix(got, []byte(fmt.Sprintf("%d-", numTables-1))) {
+			t.Fatalf("Get(key%06d) = (%.8q, %v)", i, got, err)
+		}
+	}
+}
+
+func TestDynamicLevelBytes(t *testing.T) {
+	version := NewVersion(dbName01, &utils.ColumnFamilyOptions{LevelCompactionDynamicLevelBytes: true})
+
+	// 空数据库：L0直接合并到最底层
+	if baseLevel, _ := version.levelTargets(); baseLevel != config.NumLevels-1 {
+		t.Fatalf("expected base level %d, got %d", config.NumLevels-1, baseLevel)
+	}
+
+	// 最底层500MB：L5 50MB，L4 5MB
+	version.files[6] = append(version.files[6], universalFile(100, 500<<20))
+	baseLevel, targets := version.levelTargets()
+	if baseLevel != 4 || targets[4] != 5<<20 || targets[5] != 50<<20 {
+		t.Fatalf("unexpected base level %d with targets %v", baseLevel, targets)
+	}
+
+	// 不与已有数据重叠的flush文件跳过不再使用的Level
+	meta := &FileMetaData{
+		number:   101,
+		smallest: ikey.MakeInternalKey(nil, []byte("zz0"), ikey.InternalKeyKindSet, 1),
+		largest:  ikey.MakeInternalKey(nil, []byte("zz1"), ikey.InternalKeyKindSet, 1),
+	}
+	if level := version.AddLevel0Table(meta, nil); level != 5 {
+		t.Fatalf("expected the flushed file in level 5, got %d", level)
+	}
+	version.deleteMetaFile(5, meta)
+
+	for i := 0; i < config.L0CompactionTrigger+1; i++ {
+		version.files[0] = append(version.files[0], universalFile(uint64(200+i), 1<<20))
+	}
+	compaction := version.PickCompaction(nil, 0, 0)
+	if compaction == nil || compaction.level != 0 || compaction.outputLevel != 4 {
+		t.Fatalf("expected a level0 compaction into level 4, got %+v", compaction)
+	}
+	compaction.Release()
+
+	// 不再使用的Level中有重叠的更旧的数据时，L0只能输出到该层，该层的数据需要尽快合并到下层
+	version.files[2] = append(version.files[2], universalFile(300, 1<<20))
+	if level := version.level0OutputLevel([]byte("a"), []byte("z")); level != 2 {
+		t.Fatalf("expected level0 output level 2, got %d", level)
+	}
+	if levels := version.levelsByScore(); len(levels) != 2 || levels[0] != 2 {
+		t.Fatalf("expected level 2 to have the highest score, got %v", levels)
+	}
+}
+
+var dbName04 = "../test_data/test_version/04"
+
+func TestGrandparentOverlap(t *testing.T) {
+	_ = os.RemoveAll(dbName04)
+	_ = os.MkdirAll(dbName04, 0755)
+	version := NewVersion(dbName04, nil)
+
+	const numKeys, keysPerGrandparent = 2000, 200
+	memTable := memdb.NewMemTable(nil)
+	for i := 0; i < numKeys; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i))
+		_ = memTable.Set(ikey.MakeInternalKey(nil, key, ikey.InternalKeyKindSet, uint64(i+1)), key)
+	}
+	meta, err := version.BuildLevel0Table(memTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version.addMetaFile(1, meta)
+	// L3中每个文件覆盖200个key，每越过两个文件就超过重叠上限
+	for i := 0; i < numKeys/keysPerGrandparent; i++ {
+		version.addMetaFile(3, &FileMetaData{
+			number:   uint64(1000 + i),
+			fileSize: config.MaxGrandParentOverlapBytes/2 + 1,
+			smallest: ikey.MakeInternalKey(nil, []byte(fmt.Sprintf("key%06d", i*keysPerGrandparent)), ikey.InternalKeyKindSet, 1),
+			largest:  ikey.MakeInternalKey(nil, []byte(fmt.Sprintf("key%06d", (i+1)*keysPerGrandparent-1)), ikey.InternalKeyKindSet, 1),
+		})
+	}
+
+	compaction := version.PickCompaction(nil, numKeys, 0)
+	if compaction != nil {
+		t.Fatalf("expected no compaction, got %+v", compaction)
+	}
+	compaction = version.newCompaction(1, []*FileMetaData{meta}, nil)
+	if compaction.isTrivialMove() {
+		t.Fatal("expected no trivial move with too much grandparent overlap")
+	}
+	compaction.version = version
+	compaction.smallestSnapshot = numKeys
+	if err := compaction.Run(1); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(compaction.outputs); n != numKeys/keysPerGrandparent/2 {
+		t.Fatalf("expected %d outputs, got %d", numKeys/keysPerGrandparent/2, n)
+	}
+	for i, output := range compaction.outputs {
+		if want := fmt.Sprintf("key%06d", i*2*keysPerGrandparent); string(output.smallest.UserKey()) != want {
+			t.Fatalf("output %d starts at %s, want %s", i, output.smallest.UserKey(), want)
+		}
+	}
+}
+
+func rangeFile(number uint64, smallest, largest string) *FileMetaData {
+	return &FileMetaData{
+		number:   number,
+		fileSize: 1 << 20,
+		smallest: ikey.MakeInternalKey(nil, []byte(smallest), ikey.InternalKeyKindSet, 1),
+		largest:  ikey.MakeInternalKey(nil, []byte(largest), ikey.InternalKeyKindSet, 1),
+	}
+}
+
+func TestPartialLevel0Compaction(t *testing.T) {
+	version := NewVersion(dbName01, nil)
+	// 由旧到新
+	version.files[0] = []*FileMetaData{
+		rangeFile(100, "a", "c"),
+		rangeFile(101, "m", "p"),
+		rangeFile(102, "b", "d"),
+		rangeFile(103, "x", "z"),
+		rangeFile(104, "n", "o"),
+		rangeFile(105, "q", "q"),
+	}
+	version.files[1] = []*FileMetaData{
+		rangeFile(200, "c", "e"),
+		rangeFile(201, "l", "r"),
+	}
+	numbers := func(files []*FileMetaData) []uint64 {
+		var result []uint64
+		for _, file := range files {
+			result = append(result, file.number)
+		}
+		return result
+	}
+
+	// 从最旧的文件开始，只选取与其重叠的L0文件
+	first := version.PickCompaction(nil, 0, 0)
+	if first == nil || !reflect.DeepEqual(numbers(first.inputs[0]), []uint64{100, 102}) ||
+		!reflect.DeepEqual(numbers(first.inputs[1]), []uint64{200}) {
+		t.Fatalf("unexpected compaction %+v", first)
+	}
+
+	// 不与正在进行的Compaction重叠的文件可以同时合并，L1输入文件不变时扩大L0输入文件
+	second := version.PickCompaction([]*Compaction{first}, 0, 0)
+	if second == nil || !reflect.DeepEqual(numbers(second.inputs[0]), []uint64{101, 104, 105}) ||
+		!reflect.DeepEqual(numbers(second.inputs[1]), []uint64{201}) {
+		t.Fatalf("unexpected compaction %+v", second)
+	}
+
+	// 与L1不重叠的单个文件直接移动
+	third := version.PickCompaction([]*Compaction{first, second}, 0, 0)
+	if third == nil || !reflect.DeepEqual(numbers(third.inputs[0]), []uint64{103}) || !third.isTrivialMove() {
+		t.Fatalf("unexpected compaction %+v", third)
+	}
+	if version.PickCompaction([]*Compaction{first, second, third}, 0, 0) != nil {
+		t.Fatal("expected no more compactions")
+	}
+}
+
+func TestEstimatedPendingCompactionBytes(t *testing.T) {
+	version := NewVersion(dbName01, nil)
+	if pending := version.EstimatedPendingCompactionBytes(); pending != 0 {
+		t.Fatalf("expected no pending bytes, got %d", pending)
+	}
+
+	// L1超出目标大小20MB，与L2中按比例重叠的40MB一起重写
+	version.files[1] = append(version.files[1], universalFile(100, 30<<20))
+	version.files[2] = append(version.files[2], universalFile(101, 60<<20))
+	for i := 0; i < config.L0CompactionTrigger-1; i++ {
+		version.files[0] = append(version.files[0], universalFile(uint64(200+i), 1<<20))
+	}
+	if pending := version.EstimatedPendingCompactionBytes(); pending != 60<<20 {
+		t.Fatalf("expected %d pending bytes, got %d", 60<<20, pending)
+	}
+
+	// L0文件数量达到触发值后计入L0的数据，合并到L1后L1超出得更多
+	version.files[0] = append(version.files[0], universalFile(300, 1<<20))
+	l0 := float64(config.L0CompactionTrigger << 20)
+	excess := 30<<20 + l0 - 10<<20
+	want := uint64(l0 + excess*(60<<20/(30<<20+l0)+1))
+	if pending := version.EstimatedPendingCompactionBytes(); pending != want {
+		t.Fatalf("expected %d pending bytes, got %d", want, pending)
+	}
+
+	// Universal Compaction只计入选取的Compaction会合并的sorted run，不计入最旧的sorted run
+	universal := universalVersion([]uint64{10, 10, 15}, map[int]uint64{4: 1000, 6: 10000})
+	if pending := universal.EstimatedPendingCompactionBytes(); pending != 35 {
+		t.Fatalf("expected 35 pending bytes for universal compaction, got %d", pending)
+	}
+
+	fifo := NewVersion(dbName01, &utils.ColumnFamilyOptions{CompactionStyle: utils.CompactionStyleFIFO})
+	fifo.files[0] = version.files[0]
+	if pending := fifo.EstimatedPendingCompactionBytes(); pending != 0 {
+		t.Fatalf("expected no pending bytes for FIFO compaction, got %d", pending)
+	}
+}
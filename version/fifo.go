@@ -0,0 +1,46 @@
+package version
+
+import (
+	"time"
+
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+func (version *Version) fifo() bool {
+	return version.opts.GetCompactionStyle() == utils.CompactionStyleFIFO
+}
+
+// 返回需要删除的最旧的若干个L0文件：先删除过期的文件，再删除超出总大小上限的部分
+// L0中的文件按由旧到新的顺序排列，创建时间未知的文件不会因过期被删除
+func (version *Version) fifoObsoleteFiles(now time.Time) []*FileMetaData {
+	opts := version.opts.GetFIFOCompactionOptions()
+	files := version.files[0]
+	n := 0
+	if ttl := opts.GetTTL(); ttl > 0 {
+		for n < len(files) && files[n].creationTime > 0 && now.Sub(time.Unix(files[n].creationTime, 0)) > ttl {
+			n++
+		}
+	}
+	total := totalFileSize(files[n:])
+	for n < len(files) && total > opts.GetMaxTableFilesSize() {
+		total -= files[n].fileSize
+		n++
+	}
+	return files[:n]
+}
+
+// FIFO Compaction只删除文件，不合并数据
+// 简化处理：同一列族同时只进行一个FIFO Compaction
+func (version *Version) pickFIFOCompaction(running []*Compaction) *Compaction {
+	if len(running) > 0 {
+		return nil
+	}
+	files := version.fifoObsoleteFiles(time.Now())
+	if len(files) == 0 {
+		return nil
+	}
+	compaction := &Compaction{level: 0, outputLevel: 0, deletionOnly: true}
+	compaction.inputs[0] = append(compaction.inputs[0], files...)
+	compaction.smallest, compaction.largest = version.keyRange(files)
+	return compaction
+}
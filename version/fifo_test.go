@@ -0,0 +1,50 @@
+package version
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+func TestFIFOObsoleteFiles(t *testing.T) {
+	now := time.Now()
+	version := NewVersion(dbName01, &utils.ColumnFamilyOptions{
+		CompactionStyle: utils.CompactionStyleFIFO,
+		FIFOCompactionOptions: &utils.FIFOCompactionOptions{
+			MaxTableFilesSize: 100,
+			TTL:               time.Hour,
+		},
+	})
+	// 由旧到新：两个过期文件、一个创建时间未知的文件、两个未过期的文件
+	for i, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, -1, time.Minute, 0} {
+		file := universalFile(uint64(100+i), 20)
+		if age >= 0 {
+			file.creationTime = now.Add(-age).Unix()
+		}
+		version.files[0] = append(version.files[0], file)
+	}
+
+	// 过期文件被删除，创建时间未知的文件阻止继续按时间删除
+	if files := version.fifoObsoleteFiles(now); len(files) != 2 || files[1].number != 101 {
+		t.Fatalf("expected the 2 expired files, got %d", len(files))
+	}
+
+	// 总大小超过上限时从最旧的文件开始删除
+	version.files[0] = version.files[0][2:]
+	for i := 0; i < 4; i++ {
+		version.files[0] = append(version.files[0], universalFile(uint64(200+i), 20))
+	}
+	if files := version.fifoObsoleteFiles(now); len(files) != 2 || files[0].number != 102 {
+		t.Fatalf("expected the 2 oldest files, got %d", len(files))
+	}
+
+	compaction := version.PickCompaction(nil, 0, 0)
+	if compaction == nil || !compaction.deletionOnly || len(compaction.inputs[0]) != 2 {
+		t.Fatalf("expected a deletion-only compaction, got %+v", compaction)
+	}
+	version = version.Apply(compaction)
+	if n := version.NumLevelFiles(0); n != 5 || totalFileSize(version.files[0]) > 100 {
+		t.Fatalf("expected 5 files within the size limit, got %d", n)
+	}
+}
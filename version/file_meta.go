@@ -4,16 +4,30 @@ import (
 	"encoding/binary"
 	"io"
 
+	"github.com/Cauchy-NY/yldb/config"
 	"github.com/Cauchy-NY/yldb/errors"
 	"github.com/Cauchy-NY/yldb/ikey"
 )
 
 type FileMetaData struct {
+	// 剩余的无效查找次数，减为0时该文件会被合并到下一层
 	allowSeeks uint64
 	number     uint64
 	fileSize   uint64
 	smallest   ikey.InternalKey
 	largest    ikey.InternalKey
+	// 文件的创建时间（Unix时间，秒），Compaction的输出文件取输入文件中最新的创建时间，0表示未知
+	creationTime int64
+	// 正在被后台Compaction合并，不会被其他Compaction选中，只在db.mutex下读写，不写入MANIFEST
+	beingCompacted bool
+}
+
+// 根据文件大小设置allowSeeks，文件越大，Compaction的代价越高，允许的无效查找次数越多
+func (meta *FileMetaData) resetAllowSeeks() {
+	meta.allowSeeks = meta.fileSize / config.BytesPerSeek
+	if meta.allowSeeks < config.MinAllowSeeks {
+		meta.allowSeeks = config.MinAllowSeeks
+	}
 }
 
 func (meta *FileMetaData) EncodeTo(w io.Writer) error {
@@ -1,15 +1,46 @@
 package version
 
 import (
+	"github.com/Cauchy-NY/yldb/config"
 	"github.com/Cauchy-NY/yldb/ikey"
 	"github.com/Cauchy-NY/yldb/sstable"
-	"github.com/Cauchy-NY/yldb/utils"
 )
 
+// 返回Version中所有SST文件的迭代器，打开失败的文件会被跳过
+// 迭代器使用完毕后需要调用返回的函数释放其引用的文件，之后被淘汰或删除的文件才会关闭
+func (version *Version) Iterators() ([]*sstable.TableIterator, func()) {
+	var list []*sstable.TableIterator
+	var pinned []*cachedTable
+	for level := 0; level < config.NumLevels; level++ {
+		for _, file := range version.files[level] {
+			if it, cached := version.tables.iterator(file.number); it != nil {
+				list = append(list, it)
+				pinned = append(pinned, cached)
+			}
+		}
+	}
+	tables := version.tables
+	return list, func() {
+		tables.release(pinned)
+	}
+}
+
+// MergeIterator 按internal_key顺序归并多个SST文件，同一user_key的记录按序列号由新到旧排列
 type MergeIterator struct {
-	cmp     utils.Comparator
+	cmp     ikey.InternalKeyComparator
 	list    []*sstable.TableIterator
 	current *sstable.TableIterator
+	// list中的迭代器引用的文件，Close时释放
+	tables *tableFiles
+	pinned []*cachedTable
+}
+
+// 释放迭代器引用的文件
+func (it *MergeIterator) Close() {
+	if it.tables != nil {
+		it.tables.release(it.pinned)
+		it.pinned = nil
+	}
 }
 
 func (it *MergeIterator) findSmallest() {
@@ -18,7 +49,7 @@ func (it *MergeIterator) findSmallest() {
 		if it.list[i].Valid() {
 			if smallest == nil {
 				smallest = it.list[i]
-			} else if it.cmp.Compare(smallest.UserKey(), it.list[i].UserKey()) > 0 {
+			} else if it.cmp.Compare(smallest.InternalKey(), it.list[i].InternalKey()) > 0 {
 				smallest = it.list[i]
 			}
 		}
@@ -53,8 +84,12 @@ func (it *MergeIterator) Prev() {
 	panic("implement me")
 }
 
+// 定位到第一条user_key>=target的记录
 func (it *MergeIterator) Seek(target []byte) {
-	panic("implement me")
+	for i := 0; i < len(it.list); i++ {
+		it.list[i].Seek(target)
+	}
+	it.findSmallest()
 }
 
 func (it *MergeIterator) SeekToFirst() {
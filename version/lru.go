@@ -21,7 +21,7 @@ func newLRU(size int) (*LRUCache, error) {
 	head := &Node{nil, nil, nil, nil}
 	tail := &Node{nil, nil, nil, nil}
 	head.next = tail
-	tail.next = head
+	tail.prev = head
 	return &LRUCache{
 		head:  head,
 		tail:  tail,
@@ -133,6 +133,7 @@ func (cache *LRUCache) Remove(key interface{}) bool {
 		node.prev.next = node.next
 		node.next.prev = node.prev
 		delete(cache.items, node.key)
+		cache.len--
 		return true
 	}
 	return false
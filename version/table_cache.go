@@ -4,54 +4,204 @@ import (
 	"sync"
 
 	"github.com/Cauchy-NY/yldb/config"
+	"github.com/Cauchy-NY/yldb/ikey"
 	"github.com/Cauchy-NY/yldb/sstable"
 	"github.com/Cauchy-NY/yldb/utils"
 )
 
+// TableCache 缓存打开的SST文件，同一数据库的所有列族共用一个TableCache，
+// 打开的文件总数不超过config.MaxOpenFiles-config.NumNonTableCacheFiles（正在被迭代器使用的文件除外）
+// 文件被淘汰或移除时如果仍有迭代器在使用，等到最后一个迭代器释放时才关闭
 type TableCache struct {
-	mu     sync.Mutex
-	dbName string
-	cache  *LRUCache
+	mu    sync.Mutex
+	cache *LRUCache
+}
+
+type tableKey struct {
+	dir    string
+	number uint64
+}
+
+type cachedTable struct {
+	table *sstable.SSTable
+	// 正在使用该文件的查找和迭代器数量
+	refs int
+	// 已从cache中移除，引用数降为0时关闭
+	evicted bool
 }
 
-func NewTableCache(dbName string) *TableCache {
+func NewTableCache() *TableCache {
 	lruCache, _ := newLRU(config.MaxOpenFiles - config.NumNonTableCacheFiles)
 	return &TableCache{
-		mu:     sync.Mutex{},
-		dbName: dbName,
-		cache:  lruCache,
+		mu:    sync.Mutex{},
+		cache: lruCache,
 	}
 }
 
-func (tableCache *TableCache) Evict(fileNum uint64) {
-	tableCache.cache.Remove(fileNum)
+// 返回dir目录中编号为fileNum的SST文件并增加其引用数，使用完毕后需要调用release
+func (tableCache *TableCache) acquire(dir string, cmp utils.Comparator, fileNum uint64) (*cachedTable, error) {
+	tableCache.mu.Lock()
+	defer tableCache.mu.Unlock()
+
+	key := tableKey{dir: dir, number: fileNum}
+	if value, ok := tableCache.cache.Get(key); ok {
+		cached := value.(*cachedTable)
+		cached.refs++
+		return cached, nil
+	}
+	table, err := sstable.Open(utils.TableFileName(dir, fileNum), cmp)
+	if err != nil {
+		return nil, err
+	}
+	if tableCache.cache.Len() >= tableCache.cache.size {
+		if _, oldest, ok := tableCache.cache.RemoveOldest(); ok {
+			tableCache.evict(oldest.(*cachedTable))
+		}
+	}
+	cached := &cachedTable{table: table, refs: 1}
+	tableCache.cache.Set(key, cached)
+	return cached, nil
 }
 
-func (tableCache *TableCache) Get(fileNum uint64, key []byte) ([]byte, error) {
-	table, err := tableCache.findTable(fileNum)
-	if table != nil {
-		return table.Get(key)
+func (tableCache *TableCache) release(cached *cachedTable) {
+	tableCache.mu.Lock()
+	defer tableCache.mu.Unlock()
+
+	cached.refs--
+	if cached.refs == 0 && cached.evicted {
+		_ = cached.table.Close()
 	}
-	return nil, err
 }
 
-func (tableCache *TableCache) findTable(fileNum uint64) (*sstable.SSTable, error) {
+// 标记已从cache中移除的文件，没有引用时立即关闭，调用方需持有tableCache.mu
+func (tableCache *TableCache) evict(cached *cachedTable) {
+	cached.evicted = true
+	if cached.refs == 0 {
+		_ = cached.table.Close()
+	}
+}
+
+// 从cache中移除dir目录中编号为fileNum的SST文件，用于文件被删除时
+func (tableCache *TableCache) Evict(dir string, fileNum uint64) {
 	tableCache.mu.Lock()
 	defer tableCache.mu.Unlock()
 
-	if table, ok := tableCache.cache.Get(fileNum); ok {
-		return table.(*sstable.SSTable), nil
-	} else {
-		ssTable, err := sstable.Open(utils.TableFileName(tableCache.dbName, fileNum))
-		tableCache.cache.Set(fileNum, ssTable)
-		return ssTable, err
+	key := tableKey{dir: dir, number: fileNum}
+	if value, ok := tableCache.cache.Peek(key); ok {
+		tableCache.cache.Remove(key)
+		tableCache.evict(value.(*cachedTable))
 	}
 }
 
-func (tableCache *TableCache) iterator(fileNum uint64) *sstable.TableIterator {
-	table, _ := tableCache.findTable(fileNum)
-	if table != nil {
-		return table.Iterator()
+// 从cache中移除dir目录中的所有SST文件，用于删除列族时
+func (tableCache *TableCache) EvictDir(dir string) {
+	tableCache.mu.Lock()
+	defer tableCache.mu.Unlock()
+
+	for _, key := range tableCache.cache.Keys() {
+		if key.(tableKey).dir == dir {
+			value, _ := tableCache.cache.Peek(key)
+			tableCache.cache.Remove(key)
+			tableCache.evict(value.(*cachedTable))
+		}
 	}
+}
+
+// 从cache中移除所有SST文件，用于关闭数据库时
+func (tableCache *TableCache) Close() {
+	tableCache.mu.Lock()
+	defer tableCache.mu.Unlock()
+
+	for {
+		_, value, ok := tableCache.cache.RemoveOldest()
+		if !ok {
+			return
+		}
+		tableCache.evict(value.(*cachedTable))
+	}
+}
+
+// 返回cache中打开的文件数量
+func (tableCache *TableCache) Len() int {
+	tableCache.mu.Lock()
+	defer tableCache.mu.Unlock()
+	return tableCache.cache.Len()
+}
+
+//----------------------------------tableFiles----------------------------------
+
+// tableFiles 是一个列族目录中的SST文件，通过共用的TableCache打开，由该列族的所有Version共享
+type tableFiles struct {
+	dbName string
+	cmp    utils.Comparator
+	cache  *TableCache
+}
+
+func newTableFiles(dbName string, cmp utils.Comparator) *tableFiles {
+	return &tableFiles{
+		dbName: dbName,
+		cmp:    cmp,
+		cache:  NewTableCache(),
+	}
+}
+
+func (tables *tableFiles) Evict(fileNum uint64) {
+	tables.cache.Evict(tables.dbName, fileNum)
+}
+
+func (tables *tableFiles) Get(fileNum uint64, key []byte) ([]byte, error) {
+	cached, err := tables.findTable(fileNum)
+	if err != nil {
+		return nil, err
+	}
+	defer tables.cache.release(cached)
+	return cached.table.Get(key)
+}
+
+func (tables *tableFiles) Find(fileNum uint64, key []byte, seq uint64) (ikey.InternalKey, []byte, error) {
+	cached, err := tables.findTable(fileNum)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tables.cache.release(cached)
+	return cached.table.Find(key, seq)
+}
+
+func (tables *tableFiles) MultiFind(fileNum uint64, keys [][]byte, seq uint64,
+	found func(i int, internalKey ikey.InternalKey, value []byte)) error {
+	cached, err := tables.findTable(fileNum)
+	if err != nil {
+		return err
+	}
+	defer tables.cache.release(cached)
+	cached.table.MultiFind(keys, seq, found)
 	return nil
 }
+
+func (tables *tableFiles) findTable(fileNum uint64) (*cachedTable, error) {
+	return tables.cache.acquire(tables.dbName, tables.cmp, fileNum)
+}
+
+// 返回文件的迭代器，迭代器使用完毕后需要调用release
+func (tables *tableFiles) iterator(fileNum uint64) (*sstable.TableIterator, *cachedTable) {
+	cached, _ := tables.findTable(fileNum)
+	if cached != nil {
+		return cached.table.Iterator(), cached
+	}
+	return nil, nil
+}
+
+func (tables *tableFiles) dataBlocks(fileNum uint64, fn func(lastKey ikey.InternalKey, size uint64)) {
+	cached, _ := tables.findTable(fileNum)
+	if cached != nil {
+		cached.table.DataBlocks(fn)
+		tables.cache.release(cached)
+	}
+}
+
+// 释放iterator和findTable返回的文件
+func (tables *tableFiles) release(cached []*cachedTable) {
+	for _, c := range cached {
+		tables.cache.release(c)
+	}
+}
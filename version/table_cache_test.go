@@ -0,0 +1,85 @@
+package version
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+func TestTableCacheEvictAfterRelease(t *testing.T) {
+	_ = os.RemoveAll(dbName01)
+	createFileMetadata(201, 11, 19)
+	createFileMetadata(202, 21, 29)
+
+	lruCache, _ := newLRU(1)
+	tableCache := &TableCache{cache: lruCache}
+	tables := &tableFiles{dbName: dbName01, cmp: utils.NewDefaultComparator(), cache: tableCache}
+
+	it, pinned := tables.iterator(201)
+	if it == nil {
+		t.Fatal("failed to open table 201")
+	}
+	// 打开202时淘汰201，但201仍被迭代器使用，不能关闭
+	if _, err := tables.Get(202, []byte("25")); err != nil {
+		t.Fatal(err)
+	}
+	if tableCache.Len() != 1 || !pinned.evicted {
+		t.Fatalf("expected table 201 evicted, cache len %d", tableCache.Len())
+	}
+	it.SeekToFirst()
+	if !it.Valid() || string(it.UserKey()) != "11" {
+		t.Fatal("evicted table should stay readable while pinned")
+	}
+
+	// 最后一个引用释放后关闭，再次关闭返回错误
+	tables.release([]*cachedTable{pinned})
+	if err := pinned.table.Close(); err == nil {
+		t.Fatal("expected table 201 closed after release")
+	}
+
+	tableCache.Close()
+	if tableCache.Len() != 0 {
+		t.Fatalf("expected empty cache after Close, got %d", tableCache.Len())
+	}
+}
+
+// 不同列族的文件编号相同也互不影响，且共用同一个打开文件数限制
+func TestTableCacheSharedAcrossColumnFamilies(t *testing.T) {
+	_ = os.RemoveAll(dbName01)
+	_ = os.RemoveAll(dbName02)
+	createFileMetadata(301, 11, 19)
+	createFileMetadata(302, 21, 29)
+	if err := os.MkdirAll(dbName02, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(utils.TableFileName(dbName01, 302), utils.TableFileName(dbName02, 301)); err != nil {
+		t.Fatal(err)
+	}
+
+	lruCache, _ := newLRU(2)
+	tableCache := &TableCache{cache: lruCache}
+	cf1 := NewVersion(dbName01, nil)
+	cf2 := NewVersion(dbName02, nil)
+	cf1.SetTableCache(tableCache)
+	cf2.SetTableCache(tableCache)
+
+	if value, err := cf1.tables.Get(301, []byte("15")); err != nil || string(value) != "15" {
+		t.Fatalf("cf1 get: %q, %v", value, err)
+	}
+	if value, err := cf2.tables.Get(301, []byte("25")); err != nil || string(value) != "25" {
+		t.Fatalf("cf2 get: %q, %v", value, err)
+	}
+	if _, err := cf1.tables.Get(302, []byte("25")); err != nil {
+		t.Fatal(err)
+	}
+	if tableCache.Len() != 2 {
+		t.Fatalf("expected 2 open tables, got %d", tableCache.Len())
+	}
+
+	tableCache.EvictDir(dbName02)
+	if tableCache.Len() != 1 {
+		t.Fatalf("expected 1 open table after EvictDir, got %d", tableCache.Len())
+	}
+	tableCache.Close()
+}
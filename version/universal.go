@@ -0,0 +1,92 @@
+package version
+
+import (
+	"github.com/Cauchy-NY/yldb/config"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+// sortedRun 是Universal Compaction中内部有序、互不重叠的一组文件：L0中的每个文件，或L1及之下的每个非空Level
+type sortedRun struct {
+	level int
+	files []*FileMetaData
+	size  uint64
+}
+
+// 由新到旧返回所有sorted run
+func (version *Version) sortedRuns() []sortedRun {
+	var runs []sortedRun
+	for i := len(version.files[0]) - 1; i >= 0; i-- {
+		file := version.files[0][i]
+		runs = append(runs, sortedRun{level: 0, files: []*FileMetaData{file}, size: file.fileSize})
+	}
+	for level := 1; level < config.NumLevels; level++ {
+		if files := version.files[level]; len(files) > 0 {
+			runs = append(runs, sortedRun{level: level, files: files, size: totalFileSize(files)})
+		}
+	}
+	return runs
+}
+
+func (version *Version) universal() bool {
+	return version.opts.GetCompactionStyle() == utils.CompactionStyleUniversal
+}
+
+// sorted run的数量达到config.L0CompactionTrigger时需要Compaction
+func (version *Version) needsUniversalCompaction() bool {
+	return len(version.sortedRuns()) >= config.L0CompactionTrigger
+}
+
+// 按以下顺序选取一组相邻的sorted run进行合并：
+// 1. 空间放大：除最旧的sorted run之外的数据量超过其MaxSizeAmplificationPercent时，合并所有sorted run
+// 2. 大小比例：从新到旧找到第一组大小相近的sorted run，数量不少于MinMergeWidth
+// 3. 都不满足时合并最新的若干个sorted run，使sorted run的数量降到触发值以下
+// 简化处理：同一列族同时只进行一个Universal Compaction
+func (version *Version) pickUniversalCompaction(running []*Compaction) *Compaction {
+	if len(running) > 0 || !version.needsUniversalCompaction() {
+		return nil
+	}
+	runs := version.sortedRuns()
+	opts := version.opts.GetUniversalCompactionOptions()
+
+	var newer uint64
+	for _, run := range runs[:len(runs)-1] {
+		newer += run.size
+	}
+	if newer*100 >= runs[len(runs)-1].size*uint64(opts.GetMaxSizeAmplificationPercent()) {
+		return version.newUniversalCompaction(runs, 0, len(runs))
+	}
+
+	ratio := uint64(100 + opts.GetSizeRatio())
+	for start := 0; start < len(runs)-1; start++ {
+		size := runs[start].size
+		end := start + 1
+		for end < len(runs) && end-start < opts.GetMaxMergeWidth() && runs[end].size*100 <= size*ratio {
+			size += runs[end].size
+			end++
+		}
+		if end-start >= opts.GetMinMergeWidth() {
+			return version.newUniversalCompaction(runs, start, end)
+		}
+	}
+
+	return version.newUniversalCompaction(runs, 0, len(runs)-config.L0CompactionTrigger+2)
+}
+
+// 合并runs[start:end]，输出到下一个更旧的sorted run之上最深的Level，没有更旧的sorted run时输出到最底层
+// 更旧的sorted run在L0中时只能输出到L0，放在输入文件原来的位置
+func (version *Version) newUniversalCompaction(runs []sortedRun, start, end int) *Compaction {
+	compaction := &Compaction{level: runs[start].level}
+	switch {
+	case end == len(runs):
+		compaction.outputLevel = config.NumLevels - 1
+	case runs[end].level == 0:
+		compaction.outputLevel = 0
+	default:
+		compaction.outputLevel = runs[end].level - 1
+	}
+	for _, run := range runs[start:end] {
+		compaction.inputs[run.level] = append(compaction.inputs[run.level], run.files...)
+	}
+	compaction.smallest, compaction.largest = version.keyRange(compaction.allInputs())
+	return compaction
+}
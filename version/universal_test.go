@@ -0,0 +1,92 @@
+package version
+
+import (
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/config"
+	"github.com/Cauchy-NY/yldb/ikey"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+func universalFile(number, size uint64) *FileMetaData {
+	return &FileMetaData{
+		number:   number,
+		fileSize: size,
+		smallest: ikey.MakeInternalKey(nil, []byte("a"), ikey.InternalKeyKindSet, 1),
+		largest:  ikey.MakeInternalKey(nil, []byte("z"), ikey.InternalKeyKindSet, 1),
+	}
+}
+
+// 按由新到旧的顺序给出各sorted run的大小，L0中的文件在前，levels中为各非空Level的大小
+func universalVersion(l0 []uint64, levels map[int]uint64) *Version {
+	version := NewVersion(dbName01, &utils.ColumnFamilyOptions{CompactionStyle: utils.CompactionStyleUniversal})
+	number := uint64(100)
+	for i := len(l0) - 1; i >= 0; i-- {
+		version.files[0] = append(version.files[0], universalFile(number, l0[i]))
+		number++
+	}
+	for level, size := range levels {
+		version.files[level] = append(version.files[level], universalFile(number, size))
+		number++
+	}
+	return version
+}
+
+func numInputRuns(compaction *Compaction) int {
+	n := len(compaction.inputs[0])
+	for level := 1; level < config.NumLevels; level++ {
+		if len(compaction.inputs[level]) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestUniversalCompactionPicker(t *testing.T) {
+	// sorted run不足触发值
+	version := universalVersion([]uint64{10, 10, 10}, nil)
+	if compaction := version.PickCompaction(nil, 0, 0); compaction != nil {
+		t.Fatal("expected no compaction")
+	}
+
+	// 空间放大：新数据之和超过最旧sorted run的200%，全部合并到最底层
+	version = universalVersion([]uint64{10, 10, 10}, map[int]uint64{6: 20})
+	compaction := version.PickCompaction(nil, 0, 0)
+	if compaction == nil || numInputRuns(compaction) != 4 || compaction.outputLevel != config.NumLevels-1 {
+		t.Fatalf("expected a full compaction, got %+v", compaction)
+	}
+
+	// 大小比例：最新的3个sorted run大小相近，输出到下一个sorted run之上的Level
+	version = universalVersion([]uint64{10, 10, 15}, map[int]uint64{4: 1000, 6: 10000})
+	compaction = version.PickCompaction(nil, 0, 0)
+	if compaction == nil || numInputRuns(compaction) != 3 || compaction.outputLevel != 3 {
+		t.Fatalf("expected 3 runs merged into level 3, got %+v", compaction)
+	}
+	// 同一列族同时只进行一个Universal Compaction
+	if version.PickCompaction([]*Compaction{compaction}, 0, 0) != nil {
+		t.Fatal("expected no concurrent universal compaction")
+	}
+
+	// 大小差异过大：合并最新的sorted run使数量降到触发值以下
+	version = universalVersion([]uint64{1}, map[int]uint64{3: 10, 4: 100, 5: 1000, 6: 10000})
+	compaction = version.PickCompaction(nil, 0, 0)
+	if compaction == nil || numInputRuns(compaction) != 3 || compaction.outputLevel != 4 {
+		t.Fatalf("expected 3 runs merged into level 4, got %+v", compaction)
+	}
+}
+
+// flush和导入的文件即使与各Level都不重叠也放在L0，作为最新的sorted run
+func TestUniversalNewFilesInLevel0(t *testing.T) {
+	version := universalVersion(nil, map[int]uint64{6: 100})
+	meta := metaWithRange(200, "0", "1")
+	if level := version.AddLevel0Table(meta, nil); level != 0 {
+		t.Fatalf("expected the flushed file in level0, got level%d", level)
+	}
+	meta = metaWithRange(201, "2", "3")
+	if level := version.IngestFile(201, 1<<20, meta.smallest, meta.largest); level != 0 {
+		t.Fatalf("expected the ingested file in level0, got level%d", level)
+	}
+	if runs := version.sortedRuns(); len(runs) != 3 || runs[0].files[0].number != 201 || runs[1].files[0].number != 200 {
+		t.Fatalf("unexpected sorted runs %+v", runs)
+	}
+}
@@ -5,7 +5,8 @@ import (
 	"io"
 	"log"
 	"os"
-	"sort"
+	"sync/atomic"
+	"time"
 
 	"github.com/Cauchy-NY/yldb/config"
 	"github.com/Cauchy-NY/yldb/errors"
@@ -14,26 +15,45 @@ import (
 )
 
 type Version struct {
-	tableCache     *TableCache
-	nextFileNumber uint64
+	tables *tableFiles
+	// 文件编号分配器，由同一列族的所有Version共享，后台任务可以不持有db.mutex并发地分配文件编号
+	nextFileNumber *uint64
 	seq            uint64
 	files          [config.NumLevels][]*FileMetaData
 	compactPointer [config.NumLevels]ikey.InternalKey
 	cmp            utils.Comparator
+	// 列族的配置，决定Compaction的策略
+	opts *utils.ColumnFamilyOptions
+	// flush和Compaction写入SST文件时使用的限速器，为nil时不限速
+	rateLimiter *utils.RateLimiter
+	// allowSeeks减为0、等待被合并到下一层的文件
+	fileToCompact      *FileMetaData
+	fileToCompactLevel int
 }
 
-func NewVersion(dbName string, cmp utils.Comparator) *Version {
+// opts为nil时使用默认配置
+func NewVersion(dbName string, opts *utils.ColumnFamilyOptions) *Version {
+	cmp := opts.GetComparator()
+	nextFileNumber := uint64(1)
 	version := &Version{
-		tableCache:     NewTableCache(dbName),
-		nextFileNumber: 1,
+		tables:         newTableFiles(dbName, cmp),
+		nextFileNumber: &nextFileNumber,
 		cmp:            cmp,
-	}
-	if cmp == nil {
-		version.cmp = utils.NewDefaultComparator()
+		opts:           opts,
 	}
 	return version
 }
 
+// 设置之后由该Version复制出的所有Version写入SST文件时使用的限速器
+func (version *Version) SetRateLimiter(rateLimiter *utils.RateLimiter) {
+	version.rateLimiter = rateLimiter
+}
+
+// 设置之后由该Version复制出的所有Version打开SST文件时共用的TableCache
+func (version *Version) SetTableCache(tableCache *TableCache) {
+	version.tables.cache = tableCache
+}
+
 func Load(dbName string, number uint64) (*Version, error) {
 	fileName := utils.DescriptorFileName(dbName, number)
 	file, err := os.Open(fileName)
@@ -46,9 +66,8 @@ func Load(dbName string, number uint64) (*Version, error) {
 }
 
 func (version *Version) Save() (uint64, error) {
-	fileNumber := version.nextFileNumber
-	fileName := utils.DescriptorFileName(version.tableCache.dbName, version.nextFileNumber)
-	version.nextFileNumber++
+	fileNumber := version.NewFileNumber()
+	fileName := utils.DescriptorFileName(version.tables.dbName, fileNumber)
 	file, err := os.Create(fileName)
 	if err != nil {
 		return fileNumber, err
@@ -59,10 +78,16 @@ func (version *Version) Save() (uint64, error) {
 
 func (version *Version) Copy() *Version {
 	copyVersion := &Version{
-		tableCache:     version.tableCache,
+		tables:         version.tables,
 		nextFileNumber: version.nextFileNumber,
 		seq:            version.seq,
+		compactPointer: version.compactPointer,
 		cmp:            version.cmp,
+		opts:           version.opts,
+		rateLimiter:    version.rateLimiter,
+
+		fileToCompact:      version.fileToCompact,
+		fileToCompactLevel: version.fileToCompactLevel,
 	}
 	for level := 0; level < config.NumLevels; level++ {
 		copyVersion.files[level] = make([]*FileMetaData, len(version.files[level]))
@@ -74,7 +99,7 @@ func (version *Version) Copy() *Version {
 func (version *Version) EncodeTo(w io.Writer) error {
 	var errs []error
 
-	errs = append(errs, binary.Write(w, binary.LittleEndian, version.nextFileNumber))
+	errs = append(errs, binary.Write(w, binary.LittleEndian, version.NextFileNumber()))
 	errs = append(errs, binary.Write(w, binary.LittleEndian, version.seq))
 	for level := 0; level < config.NumLevels; level++ {
 		numFiles := len(version.files[level])
@@ -96,7 +121,9 @@ func (version *Version) EncodeTo(w io.Writer) error {
 func (version *Version) DecodeFrom(r io.Reader) error {
 	var errs []error
 
-	errs = append(errs, binary.Read(r, binary.LittleEndian, &version.nextFileNumber))
+	var nextFileNumber uint64
+	errs = append(errs, binary.Read(r, binary.LittleEndian, &nextFileNumber))
+	atomic.StoreUint64(version.nextFileNumber, nextFileNumber)
 	errs = append(errs, binary.Read(r, binary.LittleEndian, &version.seq))
 	var numFiles int32
 	for level := 0; level < config.NumLevels; level++ {
@@ -117,11 +144,49 @@ func (version *Version) DecodeFrom(r io.Reader) error {
 	return nil
 }
 
+// 按Level和文件的顺序写入所有文件的创建时间，格式为：4字节文件数量，每个文件8字节创建时间
+// 创建时间在MANIFEST中单独存放，旧版本的MANIFEST可以没有这一部分
+func (version *Version) EncodeFileTimes(w io.Writer) error {
+	var times []int64
+	for level := 0; level < config.NumLevels; level++ {
+		for _, file := range version.files[level] {
+			times = append(times, file.creationTime)
+		}
+	}
+	if err := binary.Write(w, binary.LittleEndian, int32(len(times))); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, times)
+}
+
+// 读取EncodeFileTimes写入的创建时间，没有读到任何数据时返回io.EOF
+func (version *Version) DecodeFileTimes(r io.Reader) error {
+	var numFiles int32
+	if err := binary.Read(r, binary.LittleEndian, &numFiles); err != nil {
+		return err
+	}
+	times := make([]int64, numFiles)
+	if err := binary.Read(r, binary.LittleEndian, times); err != nil {
+		return errors.ErrVersionDecodeError
+	}
+	i := 0
+	for level := 0; level < config.NumLevels; level++ {
+		for _, file := range version.files[level] {
+			if i >= len(times) {
+				return errors.ErrVersionDecodeError
+			}
+			file.creationTime = times[i]
+			i++
+		}
+	}
+	return nil
+}
+
 func (version *Version) Log() {
 	for level := 0; level < config.NumLevels; level++ {
 		log.Printf("Version Level %v:\n", level)
 		for i := 0; i < len(version.files[level]); i++ {
-			log.Println(utils.TableFileName(version.tableCache.dbName, version.files[level][i].number))
+			log.Println(utils.TableFileName(version.tables.dbName, version.files[level][i].number))
 		}
 	}
 }
@@ -130,13 +195,66 @@ func (version *Version) NumLevelFiles(l int) int {
 	return len(version.files[l])
 }
 
+// 返回当前Version引用的所有SST文件编号
+func (version *Version) LiveFiles() []uint64 {
+	var numbers []uint64
+	for level := 0; level < config.NumLevels; level++ {
+		for _, file := range version.files[level] {
+			numbers = append(numbers, file.number)
+		}
+	}
+	return numbers
+}
+
+// 从TableCache中移除已删除的SST文件
+func (version *Version) EvictTable(number uint64) {
+	version.tables.Evict(number)
+}
+
+// 分配一个新的SST文件编号，可以不持有db.mutex并发调用
+func (version *Version) NewFileNumber() uint64 {
+	return atomic.AddUint64(version.nextFileNumber, 1) - 1
+}
+
+// 返回下一个将被分配的文件编号
+func (version *Version) NextFileNumber() uint64 {
+	return atomic.LoadUint64(version.nextFileNumber)
+}
+
 func (version *Version) NextSeq() uint64 {
 	version.seq++
 	return version.seq
 }
 
+func (version *Version) LastSeq() uint64 {
+	return version.seq
+}
+
+// 外部写入分配序列号后不会经过Version，Compaction安装新Version前需要同步最新的序列号
+func (version *Version) SetLastSeq(seq uint64) {
+	if seq > version.seq {
+		version.seq = seq
+	}
+}
+
 func (version *Version) Get(ukey []byte) ([]byte, error) {
+	internalKey, value, err := version.Find(ukey, ikey.InternalKeySeqNumMax)
+	if err != nil {
+		return nil, err
+	}
+	value, live := ikey.LiveValue(internalKey.Kind(), value, time.Now().UnixNano())
+	if !live {
+		return nil, errors.ErrSSTableDeletion
+	}
+	return value, nil
+}
+
+// 按Level由新到旧查找user_key在序列号seq及之前的最新一条记录（包括删除记录）
+// 查找过但不含该key的文件，若之后还需要继续查找其他文件，则扣减其allowSeeks
+func (version *Version) Find(ukey []byte, seq uint64) (ikey.InternalKey, []byte, error) {
 	var searchFiles []*FileMetaData // user_key可能存在的文件集合
+	var lastMiss *FileMetaData      // 上一个查找过但不含该key的文件
+	lastMissLevel := 0
 
 	for level := 0; level < config.NumLevels; level++ {
 		numFiles := len(version.files[level])
@@ -144,21 +262,14 @@ func (version *Version) Get(ukey []byte) ([]byte, error) {
 			continue
 		}
 		if level == 0 {
-			// level0各文件的key范围可能存在重叠
-			for i := 0; i < numFiles; i++ {
+			// level0各文件的key范围可能存在重叠，越靠后的文件越新，由新到旧查找，以最新的数据副本为准
+			for i := numFiles - 1; i >= 0; i-- {
 				file := version.files[level][i]
 				if version.cmp.Compare(ukey, file.smallest.UserKey()) >= 0 &&
 					version.cmp.Compare(ukey, file.largest.UserKey()) <= 0 {
 					searchFiles = append(searchFiles, file)
 				}
 			}
-			if len(searchFiles) == 0 {
-				continue
-			}
-			// level0中文件按时间倒排，以最新的数据副本为准
-			sort.Slice(searchFiles, func(i, j int) bool {
-				return searchFiles[i].number > searchFiles[j].number
-			})
 		} else {
 			// 从level1开始每层的各文件key范围之间不存在重叠
 			files := version.files[level]
@@ -170,13 +281,133 @@ func (version *Version) Get(ukey []byte) ([]byte, error) {
 			}
 		}
 		for _, file := range searchFiles {
-			if value, err := version.tableCache.Get(file.number, ukey); err != errors.ErrSSTableNotFound {
-				return value, err
+			if lastMiss != nil {
+				version.chargeSeek(lastMissLevel, lastMiss)
+				lastMiss = nil
+			}
+			internalKey, value, err := version.tables.Find(file.number, ukey, seq)
+			if err != errors.ErrSSTableNotFound {
+				return internalKey, value, err
 			}
+			lastMiss, lastMissLevel = file, level
 		}
 		searchFiles = searchFiles[:0] // 该层搜索文件清空
 	}
-	return nil, errors.ErrVersionNotFound
+	return nil, nil, errors.ErrVersionNotFound
+}
+
+// 扣减文件的allowSeeks，减为0时记录为待合并的文件，最底层的文件没有下一层可以合并
+// 只有分层Compaction按文件进行合并，其他策略不记录待合并的文件
+func (version *Version) chargeSeek(level int, file *FileMetaData) {
+	if file.allowSeeks > 0 {
+		file.allowSeeks--
+	}
+	if file.allowSeeks == 0 && version.fileToCompact == nil && level < config.NumLevels-1 &&
+		version.opts.GetCompactionStyle() == utils.CompactionStyleLevel {
+		version.fileToCompact = file
+		version.fileToCompactLevel = level
+	}
+}
+
+// 有文件的allowSeeks减为0、需要进行Compaction时返回true
+func (version *Version) NeedsSeekCompaction() bool {
+	return version.fileToCompact != nil
+}
+
+// MultiFindResult 是MultiFind中单个key的查找结果，Err为ErrVersionNotFound时表示所有Level中都不存在该key
+type MultiFindResult struct {
+	InternalKey ikey.InternalKey
+	Value       []byte
+	Err         error
+}
+
+// 批量查找多个user_key在序列号seq及之前的最新一条记录，keys需按version.cmp升序排列
+// 与逐个调用Find相比，同一文件中的key合并为一次查找，共用该文件的迭代器和已读取的Data Block
+func (version *Version) MultiFind(keys [][]byte, seq uint64) []MultiFindResult {
+	results := make([]MultiFindResult, len(keys))
+	pending := make([]int, len(keys)) // 尚未找到的key在keys中的下标，保持升序
+	for i := range keys {
+		pending[i] = i
+		results[i].Err = errors.ErrVersionNotFound
+	}
+
+	done := make([]bool, len(keys))
+	search := func(file *FileMetaData, group []int) {
+		groupKeys := make([][]byte, len(group))
+		for j, i := range group {
+			groupKeys[j] = keys[i]
+		}
+		err := version.tables.MultiFind(file.number, groupKeys, seq, func(j int, internalKey ikey.InternalKey, value []byte) {
+			i := group[j]
+			results[i] = MultiFindResult{InternalKey: internalKey, Value: value}
+			done[i] = true
+		})
+		if err != nil {
+			for _, i := range group {
+				results[i].Err = err
+				done[i] = true
+			}
+		}
+	}
+	// 去掉已经有结果的key
+	compact := func() {
+		n := 0
+		for _, i := range pending {
+			if !done[i] {
+				pending[n] = i
+				n++
+			}
+		}
+		pending = pending[:n]
+	}
+
+	for level := 0; level < config.NumLevels && len(pending) > 0; level++ {
+		files := version.files[level]
+		if len(files) == 0 {
+			continue
+		}
+		if level == 0 {
+			// level0各文件的key范围可能存在重叠，越靠后的文件越新，按时间由新到旧依次查找
+			for f := len(files) - 1; f >= 0; f-- {
+				file := files[f]
+				var group []int
+				for _, i := range pending {
+					if version.cmp.Compare(keys[i], file.smallest.UserKey()) >= 0 &&
+						version.cmp.Compare(keys[i], file.largest.UserKey()) <= 0 {
+						group = append(group, i)
+					}
+				}
+				if len(group) > 0 {
+					search(file, group)
+					compact()
+				}
+			}
+			continue
+		}
+
+		// 从level1开始每层的各文件key范围之间不存在重叠，有序的key和有序的文件可以归并分组
+		index := version.findFile(files, keys[pending[0]])
+		for p := 0; p < len(pending) && index < len(files); {
+			file := files[index]
+			key := keys[pending[p]]
+			if version.cmp.Compare(file.largest.UserKey(), key) < 0 {
+				index++
+				continue
+			}
+			var group []int
+			for ; p < len(pending) && version.cmp.Compare(keys[pending[p]], file.largest.UserKey()) <= 0; p++ {
+				if version.cmp.Compare(keys[pending[p]], file.smallest.UserKey()) >= 0 {
+					group = append(group, pending[p])
+				}
+			}
+			if len(group) > 0 {
+				search(file, group)
+			}
+			index++
+		}
+		compact()
+	}
+	return results
 }
 
 // 在LN(N>0)层二分查找可能含有user_key的文件
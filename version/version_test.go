@@ -3,10 +3,12 @@ package version
 import (
 	"fmt"
 	"os"
+	"reflect"
 	"sort"
 	"strconv"
 	"testing"
 
+	"github.com/Cauchy-NY/yldb/config"
 	"github.com/Cauchy-NY/yldb/ikey"
 	"github.com/Cauchy-NY/yldb/memdb"
 	"github.com/Cauchy-NY/yldb/sstable"
@@ -139,3 +141,99 @@ func TestVersionSaveAndLoad(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// 同一user_key在多个文件中的记录按序列号由新到旧归并
+func TestMergeIteratorOrder(t *testing.T) {
+	dir := "../test_data/test_version/merge"
+	_ = os.RemoveAll(dir)
+	_ = os.MkdirAll(dir, 0755)
+	version := NewVersion(dir, nil)
+	// 先写入的较新的文件被放到较深的Level
+	for _, seq := range []uint64{2, 1} {
+		memTable := memdb.NewMemTable(nil)
+		_ = memTable.Set(ikey.MakeInternalKey(nil, []byte("key"), ikey.InternalKeyKindSet, seq), []byte(strconv.FormatUint(seq, 10)))
+		if err := version.WriteLevel0Table(memTable); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	iterators, release := version.Iterators()
+	defer release()
+	it := &MergeIterator{list: iterators, cmp: ikey.NewInternalKeyComparator(version.cmp)}
+	var seqs []uint64
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		seqs = append(seqs, it.InternalKey().SeqNum())
+	}
+	if len(seqs) != 2 || seqs[0] != 2 || seqs[1] != 1 {
+		t.Fatalf("unexpected merge order %v", seqs)
+	}
+}
+
+// 只有key范围的文件元数据，不在磁盘上创建文件
+func metaWithRange(number uint64, smallest, largest string) *FileMetaData {
+	return &FileMetaData{
+		number:   number,
+		smallest: ikey.MakeInternalKey(nil, []byte(smallest), ikey.InternalKeyKindSet, number),
+		largest:  ikey.MakeInternalKey(nil, []byte(largest), ikey.InternalKeyKindSet, number),
+	}
+}
+
+func TestManualCompactionAndIngest(t *testing.T) {
+	version := NewVersion(dbName01, nil)
+	version.files[0] = []*FileMetaData{
+		metaWithRange(100, "a", "c"),
+		metaWithRange(101, "m", "p"),
+	}
+	version.files[1] = []*FileMetaData{
+		metaWithRange(200, "c", "e"),
+	}
+	numbers := func(files []*FileMetaData) []uint64 {
+		var result []uint64
+		for _, file := range files {
+			result = append(result, file.number)
+		}
+		return result
+	}
+
+	manual, busy := version.PickManualCompaction(0, 1, []byte("a"), []byte("b"), nil, 0, 0)
+	if busy || manual == nil || !reflect.DeepEqual(numbers(manual.inputs[0]), []uint64{100}) ||
+		!reflect.DeepEqual(numbers(manual.inputs[1]), []uint64{200}) {
+		t.Fatalf("unexpected manual compaction %+v", manual)
+	}
+	// 与正在进行的手动Compaction冲突时需要等待
+	if _, busy := version.PickManualCompaction(0, 1, []byte("b"), []byte("c"), []*Compaction{manual}, 0, 0); !busy {
+		t.Fatal("expected the manual compaction to be busy")
+	}
+	if compaction, busy := version.PickManualCompaction(1, 2, []byte("x"), []byte("z"), nil, 0, 0); busy || compaction != nil {
+		t.Fatal("expected nothing to compact")
+	}
+
+	// 导入占用的范围内不能进行Compaction，flush的文件只能放在L0
+	reservation := version.ReserveRange([]byte("m"), []byte("p"))
+	running := []*Compaction{manual, reservation}
+	if _, busy := version.PickManualCompaction(0, 1, []byte("m"), []byte("m"), running, 0, 0); !busy {
+		t.Fatal("expected the reserved range to be busy")
+	}
+	if level := version.AddLevel0Table(metaWithRange(300, "n", "n"), running); level != 0 {
+		t.Fatalf("expected the flushed file in level0, got level%d", level)
+	}
+	if level := version.AddLevel0Table(metaWithRange(301, "x", "x"), running); level != config.MaxMemCompactLevel {
+		t.Fatalf("expected the flushed file in level%d, got level%d", config.MaxMemCompactLevel, level)
+	}
+
+	// 导入的文件与更旧的L0文件重叠时放在L0，排在导入期间flush的文件之前
+	ingested := metaWithRange(250, "m", "o")
+	if level := version.IngestFile(250, 1<<20, ingested.smallest, ingested.largest); level != 0 {
+		t.Fatalf("expected the ingested file in level0, got level%d", level)
+	}
+	if got := numbers(version.files[0]); !reflect.DeepEqual(got, []uint64{100, 101, 250, 300}) {
+		t.Fatalf("unexpected level0 files %v", got)
+	}
+	// 只与更新的L0文件重叠时放在更深的Level
+	version.files[0] = []*FileMetaData{metaWithRange(300, "s", "t")}
+	ingested = metaWithRange(280, "s", "s")
+	if level := version.IngestFile(280, 1<<20, ingested.smallest, ingested.largest); level != config.NumLevels-1 {
+		t.Fatalf("expected the ingested file in level%d, got level%d", config.NumLevels-1, level)
+	}
+	manual.Release()
+}
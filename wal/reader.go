@@ -0,0 +1,58 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/Cauchy-NY/yldb/errors"
+)
+
+// Reader 顺序读取预写日志中的记录
+type Reader struct {
+	r *bufio.Reader
+	// 已完整读取的记录末尾在日志中的偏移
+	offset int64
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// 读取下一条记录
+// 日志正常结束时返回io.EOF；尾部记录不完整（写入过程中崩溃或仍在写入）或记录长度超过日志剩余内容时返回ErrWALTruncated；
+// 记录内容损坏时返回ErrWALChecksumMismatch
+func (r *Reader) ReadRecord() ([]byte, error) {
+	var header [headerLen]byte
+	if n, err := io.ReadFull(r.r, header[:]); err != nil {
+		if n == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errors.ErrWALTruncated
+	}
+	checksum := binary.LittleEndian.Uint32(header[0:4])
+	length := binary.LittleEndian.Uint32(header[4:8])
+	// 损坏的记录头可能给出远超日志剩余内容的长度，只预先分配有限的空间，之后按实际读到的内容增长
+	var buf bytes.Buffer
+	if length <= maxPreallocLen {
+		buf.Grow(int(length))
+	} else {
+		buf.Grow(maxPreallocLen)
+	}
+	if n, _ := buf.ReadFrom(io.LimitReader(r.r, int64(length))); n < int64(length) {
+		return nil, errors.ErrWALTruncated
+	}
+	data := buf.Bytes()
+	if crc32.Checksum(data, crcTable) != checksum {
+		return nil, errors.ErrWALChecksumMismatch
+	}
+	r.offset += int64(headerLen + len(data))
+	return data, nil
+}
+
+// 返回已完整读取的记录末尾的偏移，可用于之后从该位置继续读取
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
@@ -0,0 +1,94 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/Cauchy-NY/yldb/errors"
+)
+
+var (
+	dbName   = "../test_data/test_wal"
+	fileName = dbName + "/" + "000001.log"
+)
+
+func writeRecords(t *testing.T, n int) []byte {
+	_ = os.RemoveAll(dbName)
+	_ = os.MkdirAll(dbName, 0755)
+	w, err := Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := w.AddRecord([]byte("record-" + strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = w.Close()
+	data, _ := ioutil.ReadFile(fileName)
+	if int64(len(data)) != w.Size() {
+		t.Fatalf("size: got %d, want %d", w.Size(), len(data))
+	}
+	return data
+}
+
+func TestReadWrite(t *testing.T) {
+	data := writeRecords(t, 10)
+	r := NewReader(bytes.NewReader(data))
+	for i := 0; i < 10; i++ {
+		record, err := r.ReadRecord()
+		if err != nil || string(record) != "record-"+strconv.Itoa(i) {
+			t.Fatalf("%d: got (%q, %v)", i, record, err)
+		}
+	}
+	if _, err := r.ReadRecord(); err != io.EOF {
+		t.Fatalf("got %v, want %v", err, io.EOF)
+	}
+	if r.Offset() != int64(len(data)) {
+		t.Fatalf("offset: got %d, want %d", r.Offset(), len(data))
+	}
+}
+
+func TestTruncatedTail(t *testing.T) {
+	data := writeRecords(t, 3)
+	r := NewReader(bytes.NewReader(data[:len(data)-2]))
+	for i := 0; i < 2; i++ {
+		if _, err := r.ReadRecord(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offset := r.Offset()
+	if _, err := r.ReadRecord(); err != errors.ErrWALTruncated {
+		t.Fatalf("got %v, want %v", err, errors.ErrWALTruncated)
+	}
+
+	// 从上次完整记录的位置继续读取
+	r = NewReader(bytes.NewReader(data[offset:]))
+	if record, err := r.ReadRecord(); err != nil || string(record) != "record-2" {
+		t.Fatalf("got (%q, %v)", record, err)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	data := writeRecords(t, 1)
+	data[len(data)-1] ^= 0xff
+	r := NewReader(bytes.NewReader(data))
+	if _, err := r.ReadRecord(); err != errors.ErrWALChecksumMismatch {
+		t.Fatalf("got %v, want %v", err, errors.ErrWALChecksumMismatch)
+	}
+}
+
+// 记录头中的长度超过日志剩余内容时按不完整的尾部记录处理
+func TestCorruptedLength(t *testing.T) {
+	data := writeRecords(t, 2)
+	binary.LittleEndian.PutUint32(data[4:8], 0xffffffff)
+	r := NewReader(bytes.NewReader(data))
+	if _, err := r.ReadRecord(); err != errors.ErrWALTruncated {
+		t.Fatalf("got %v, want %v", err, errors.ErrWALTruncated)
+	}
+}
@@ -0,0 +1,56 @@
+package wal
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+)
+
+const headerLen = 8
+
+// 读取记录时预先分配的最大空间
+const maxPreallocLen = 64 << 10
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Writer 以追加方式写入预写日志
+// 每条记录的格式为：
+// - 4字节：小端模式的记录内容CRC32C校验和
+// - 4字节：小端模式的记录内容长度
+// - 记录内容
+type Writer struct {
+	file   *os.File
+	offset int64
+}
+
+func Create(fileName string) (*Writer, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{file: file}, nil
+}
+
+// 追加一条记录，记录头与内容通过一次Write写入，崩溃时至多留下一条不完整的尾部记录
+func (w *Writer) AddRecord(data []byte) error {
+	buf := make([]byte, headerLen+len(data))
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.Checksum(data, crcTable))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(data)))
+	copy(buf[headerLen:], data)
+	n, err := w.file.Write(buf)
+	w.offset += int64(n)
+	return err
+}
+
+func (w *Writer) Sync() error {
+	return w.file.Sync()
+}
+
+// 返回已写入的字节数
+func (w *Writer) Size() int64 {
+	return w.offset
+}
+
+func (w *Writer) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,138 @@
+package yldb
+
+import (
+	"time"
+
+	"github.com/Cauchy-NY/yldb/config"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+type WriteStallCondition int
+
+const (
+	// 正常写入
+	WriteStallNormal WriteStallCondition = iota
+	// Level0文件数量或待合并的数据量达到slowdown阈值，写入按延迟写入速度限速
+	WriteStallDelayed
+	// Level0文件数量或待合并的数据量达到stop阈值，写入等待Compaction完成
+	WriteStallStopped
+)
+
+// WriteStallStats 是写入延迟和停止的累计统计
+// MemTable写满且上一个ImmTable尚未flush完成时的等待也计入停止
+type WriteStallStats struct {
+	Condition WriteStallCondition
+	// 当前的延迟写入速度（字节/秒）
+	DelayedWriteRate int64
+	// 被延迟的写入次数及延迟的总时长
+	DelayCount    uint64
+	DelayDuration time.Duration
+	// 被停止的写入次数及等待的总时长
+	StopCount    uint64
+	StopDuration time.Duration
+}
+
+// writeController 根据Compaction的积压情况调节写入速度，所有字段由db.mutex保护
+// 进入延迟状态时写入速度为配置的DelayedWriteRate，之后Level0文件数量或待合并的数据量每增加一次，
+// 速度乘以config.DelayedWriteRateDecay（不低于config.MinDelayedWriteRate），每减少一次则除以该系数（不超过配置值）
+type writeController struct {
+	maxDelayedWriteRate int64
+	delayedWriteRate    int64
+	condition           WriteStallCondition
+	// 上次更新时各列族中最多的Level0文件数量和最大的待合并数据量
+	level0Files  int
+	pendingBytes uint64
+	// 延迟状态下下一次写入可以开始的时间
+	nextWriteTime time.Time
+	stats         WriteStallStats
+}
+
+func newWriteController(delayedWriteRate int64) *writeController {
+	return &writeController{
+		maxDelayedWriteRate: delayedWriteRate,
+		delayedWriteRate:    delayedWriteRate,
+	}
+}
+
+func (c *writeController) update(condition WriteStallCondition, level0Files int, pendingBytes uint64) {
+	if condition == WriteStallDelayed {
+		if c.condition == WriteStallNormal {
+			c.delayedWriteRate = c.maxDelayedWriteRate
+		} else if level0Files > c.level0Files || pendingBytes > c.pendingBytes {
+			minRate := int64(config.MinDelayedWriteRate)
+			if minRate > c.maxDelayedWriteRate {
+				minRate = c.maxDelayedWriteRate
+			}
+			c.delayedWriteRate = int64(float64(c.delayedWriteRate) * config.DelayedWriteRateDecay)
+			if c.delayedWriteRate < minRate {
+				c.delayedWriteRate = minRate
+			}
+		} else if level0Files < c.level0Files || pendingBytes < c.pendingBytes {
+			c.delayedWriteRate = int64(float64(c.delayedWriteRate) / config.DelayedWriteRateDecay)
+			if c.delayedWriteRate > c.maxDelayedWriteRate {
+				c.delayedWriteRate = c.maxDelayedWriteRate
+			}
+		}
+	}
+	c.condition = condition
+	c.level0Files = level0Files
+	c.pendingBytes = pendingBytes
+}
+
+// 返回延迟状态下本次写入需要等待的时间
+func (c *writeController) delay(now time.Time) time.Duration {
+	if c.nextWriteTime.Before(now) {
+		return 0
+	}
+	return c.nextWriteTime.Sub(now)
+}
+
+// 按延迟写入速度记录本次写入的bytes字节，推迟之后写入的开始时间
+func (c *writeController) charge(bytes int, now time.Time) {
+	if c.nextWriteTime.Before(now) {
+		c.nextWriteTime = now
+	}
+	c.nextWriteTime = c.nextWriteTime.Add(time.Duration(float64(bytes) / float64(c.delayedWriteRate) * float64(time.Second)))
+}
+
+// 根据各列族的Level0文件数量和估算的待合并数据量计算写入状态，并更新writeController，调用方需持有db.mutex
+func (db *YLDB) updateWriteStallCondition() WriteStallCondition {
+	condition := WriteStallNormal
+	maxFiles := 0
+	var maxPending uint64
+	for _, cf := range db.cfs {
+		files := 0
+		// FIFO Compaction的文件都保留在L0中，文件数量不代表Compaction的积压
+		if cf.opts.GetCompactionStyle() != utils.CompactionStyleFIFO {
+			files = cf.current.NumLevelFiles(0)
+		}
+		pending := cf.current.EstimatedPendingCompactionBytes()
+		if files >= cf.opts.GetLevel0StopWritesTrigger() || pending >= cf.opts.GetHardPendingCompactionBytesLimit() {
+			condition = WriteStallStopped
+		} else if files >= cf.opts.GetLevel0SlowdownWritesTrigger() || pending >= cf.opts.GetSoftPendingCompactionBytesLimit() {
+			if condition == WriteStallNormal {
+				condition = WriteStallDelayed
+			}
+		}
+		if files > maxFiles {
+			maxFiles = files
+		}
+		if pending > maxPending {
+			maxPending = pending
+		}
+	}
+	db.writeController.update(condition, maxFiles, maxPending)
+	return condition
+}
+
+// 返回当前的写入状态和累计的写入延迟、停止统计
+func (db *YLDB) GetWriteStallStats() WriteStallStats {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.updateWriteStallCondition()
+	stats := db.writeController.stats
+	stats.Condition = db.writeController.condition
+	stats.DelayedWriteRate = db.writeController.delayedWriteRate
+	return stats
+}
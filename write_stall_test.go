@@ -0,0 +1,115 @@
+package yldb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Cauchy-NY/yldb/errors"
+	"github.com/Cauchy-NY/yldb/utils"
+)
+
+var writeStallPath = "./test_data/test_write_stall"
+
+func TestWriteStall(t *testing.T) {
+	_ = os.RemoveAll(writeStallPath)
+	opts := &utils.Options{
+		ColumnFamilyOptions: utils.ColumnFamilyOptions{
+			Level0SlowdownWritesTrigger: 4,
+			Level0StopWritesTrigger:     6,
+		},
+		DelayedWriteRate: 100 << 10,
+	}
+	db, err := OpenWithOptions(writeStallPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 占满后台Compaction的并发数，flush的文件都留在L0中
+	db.mutex.Lock()
+	db.compactions = db.opts.GetMaxBackgroundCompactions()
+	db.mutex.Unlock()
+	held := true
+	release := func() {
+		db.mutex.Lock()
+		defer db.mutex.Unlock()
+		if held {
+			held = false
+			db.compactions = 0
+			db.maybeScheduleCompaction()
+		}
+	}
+	defer release()
+	flushUntil := func(files int) {
+		for i := 0; db.defaultCF.current.NumLevelFiles(0) < files; i++ {
+			_ = db.Set([]byte(fmt.Sprintf("key%02d", i%10)), []byte("value"), nil)
+			db.mutex.Lock()
+			err := db.flush()
+			db.mutex.Unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	noSlowdown := &utils.WriteOptions{NoSlowdown: true}
+	value := bytes.Repeat([]byte("v"), 10<<10)
+
+	flushUntil(4)
+	if stats := db.GetWriteStallStats(); stats.Condition != WriteStallDelayed || stats.DelayedWriteRate != 100<<10 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	// 第一次写入不需要等待，之后的写入需要等待约100ms
+	if err := db.Set([]byte("a"), value, noSlowdown); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set([]byte("b"), value, noSlowdown); err != errors.ErrWriteStall {
+		t.Fatalf("got %v, want ErrWriteStall", err)
+	}
+	start := time.Now()
+	if err := db.Set([]byte("b"), value, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expected the write to be delayed, took %v", elapsed)
+	}
+
+	// L0文件继续增加时降低写入速度
+	flushUntil(5)
+	stats := db.GetWriteStallStats()
+	if stats.DelayedWriteRate >= 100<<10 || stats.DelayCount == 0 || stats.DelayDuration < 50*time.Millisecond {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	flushUntil(6)
+	if stats := db.GetWriteStallStats(); stats.Condition != WriteStallStopped {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if err := db.Set([]byte("c"), []byte("c"), noSlowdown); err != errors.ErrWriteStall {
+		t.Fatalf("got %v, want ErrWriteStall", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- db.Set([]byte("c"), []byte("c"), nil)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected the write to be stopped, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Compaction完成之后恢复写入
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	stats = db.GetWriteStallStats()
+	if stats.StopCount == 0 || stats.StopDuration < 50*time.Millisecond {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if value, err := db.Get([]byte("c"), nil); err != nil || string(value) != "c" {
+		t.Fatalf("Get(c) = (%q, %v)", value, err)
+	}
+}
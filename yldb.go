@@ -5,84 +5,184 @@ import (
 	"sync"
 	"time"
 
-	"github.com/Cauchy-NY/yldb/config"
 	"github.com/Cauchy-NY/yldb/errors"
 	"github.com/Cauchy-NY/yldb/ikey"
 	"github.com/Cauchy-NY/yldb/memdb"
 	"github.com/Cauchy-NY/yldb/utils"
 	"github.com/Cauchy-NY/yldb/version"
+	"github.com/Cauchy-NY/yldb/wal"
 )
 
 type YLDB struct {
-	name       string
-	mem        *memdb.MemTable
-	imm        *memdb.MemTable
-	current    *version.Version
-	mutex      sync.Mutex
-	cond       *sync.Cond
-	compacting bool
-	closed     bool
+	name string
+	// 所有列族（按ID），其中包括默认列族
+	cfs       map[uint32]*ColumnFamily
+	defaultCF *ColumnFamily
+	nextCFID  uint32
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	closed    bool
+	// 后台任务状态：是否正在flush、正在进行的major compaction数量，以及正在进行的手动Compaction和导入的数量
+	flushing    bool
+	compactions int
+	manualJobs  int
+	// 最近一次后台flush和major compaction的错误，同类任务之后成功时清除；
+	// 存在时需要等待后台任务的写入和flush直接返回该错误
+	flushError      error
+	compactionError error
+	// 后台任务失败后在该时间之前不再调度同类任务
+	nextFlushRetry      time.Time
+	nextCompactionRetry time.Time
+	// 定期检查FIFO列族中过期文件的定时器，没有设置了TTL的FIFO列族时为nil
+	ttlTimer *time.Timer
+	opts     *utils.Options
+	// 只读模式下不创建、修改或删除任何文件
+	readOnly bool
+	// 从库模式下主库的目录，以及从库已回放到的各WAL偏移
+	primary    string
+	logOffsets map[uint64]int64
+	// 最后一次写入分配的序列号，所有列族共享
+	seq uint64
+	// 所有列族共享的WAL
+	log           *wal.Writer
+	logFileNumber uint64 // 正在写入的WAL编号
+	logNumber     uint64 // 编号更小的WAL中的数据都已持久化到SST文件中
+	nextLogNumber uint64
+	// 当前MANIFEST文件编号
+	manifestNumber uint64
+	// 尚未释放的快照
+	snapshots []*utils.Snapshot
+	// 悲观事务的行锁与事务ID分配
+	locks     *lockManager
+	nextTxnID uint64
+	// 根据Compaction的积压情况延迟或停止写入
+	writeController *writeController
+	// 所有列族共用的TableCache，打开的SST文件总数受MaxOpenFiles限制
+	tableCache *version.TableCache
+	// 尚未释放的LiveFiles数量，大于0时不删除任何SST文件
+	deletionHolds int
 }
 
 func Open(dbName string) (*YLDB, error) {
+	return OpenWithOptions(dbName, nil)
+}
+
+func OpenWithOptions(dbName string, opts *utils.Options) (*YLDB, error) {
 	err := os.MkdirAll(dbName, 0755)
 	if err != nil {
 		return nil, err
 	}
+	db := newDB(dbName, opts)
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if err := db.recover(opts); err != nil {
+		return nil, err
+	}
+	if err := db.switchLog(); err != nil {
+		return nil, err
+	}
+	db.maybeScheduleCompaction()
+	db.maybeScheduleTTLCheck()
+	return db, nil
+}
+
+// 以只读模式打开已存在的数据库，所有写操作都返回ErrDBReadOnly
+// 只读取CURRENT、MANIFEST和WAL，WAL中的数据回放到内存中，不会创建、修改或删除任何文件，
+// 因此可以用于只读挂载的数据目录，打开之后主库的新写入不可见
+func OpenReadOnly(dbName string, opts *utils.Options) (*YLDB, error) {
+	if _, err := os.Stat(dbName); err != nil {
+		return nil, err
+	}
+	db := newDB(dbName, opts)
+	db.readOnly = true
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if err := db.recover(opts); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func newDB(dbName string, opts *utils.Options) *YLDB {
 	db := &YLDB{
-		name:       dbName,
-		mem:        memdb.NewMemTable(nil),
-		imm:        nil,
-		mutex:      sync.Mutex{},
-		compacting: false,
-		closed:     false,
+		name:          dbName,
+		opts:          opts,
+		cfs:           make(map[uint32]*ColumnFamily),
+		nextCFID:      defaultColumnFamilyID + 1,
+		mutex:         sync.Mutex{},
+		closed:        false,
+		nextLogNumber: 1,
+		locks:         newLockManager(),
+		tableCache:    version.NewTableCache(),
 	}
+	db.writeController = newWriteController(opts.GetDelayedWriteRate())
 	db.cond = sync.NewCond(&db.mutex)
+	return db
+}
+
+// 返回CURRENT、MANIFEST和WAL所在的目录，从库模式下为主库的目录
+func (db *YLDB) primaryDir() string {
+	if db.primary != "" {
+		return db.primary
+	}
+	return db.name
+}
+
+// 从MANIFEST和WAL中恢复所有列族，调用方需持有db.mutex
+func (db *YLDB) recover(opts *utils.Options) error {
 	num := db.ReadCurrentFile()
 	if num > 0 {
-		v, err := version.Load(dbName, num)
-		if err != nil {
-			return nil, err
+		if err := db.loadManifest(num, opts); err != nil {
+			return err
 		}
-		db.current = v
 	} else {
-		db.current = version.NewVersion(dbName, nil)
+		db.defaultCF = newColumnFamily(db.name, defaultColumnFamilyID, utils.DefaultColumnFamilyName,
+			opts.GetColumnFamilyOptions(utils.DefaultColumnFamilyName))
+		db.cfs[defaultColumnFamilyID] = db.defaultCF
 	}
-	return db, nil
+	for _, cf := range db.cfs {
+		cf.current.SetRateLimiter(opts.GetRateLimiter())
+		cf.current.SetTableCache(db.tableCache)
+	}
+
+	if db.nextLogNumber < db.logNumber {
+		db.nextLogNumber = db.logNumber
+	}
+	return db.recoverLogs()
 }
 
 func (db *YLDB) Close() {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
-	for db.compacting {
+	db.closed = true
+	if db.ttlTimer != nil {
+		db.ttlTimer.Stop()
+		db.ttlTimer = nil
+	}
+	// 唤醒被停止的写入
+	db.cond.Broadcast()
+	for db.backgroundBusy() {
 		db.cond.Wait()
 	}
+	if db.log != nil {
+		_ = db.log.Close()
+		db.log = nil
+	}
+	// 仍在使用中的SST文件在迭代器关闭时再关闭
+	db.tableCache.Close()
 }
 
 func (db *YLDB) Get(key []byte, opts *utils.ReadOptions) ([]byte, error) {
-	// todo 增加LastSeq&VersionSet机制，实现MVCC，取消全局锁
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
-
-	if db.mem != nil { // 1.先查内存中的MemTable
-		if val, err := db.mem.Get(key); err != errors.ErrMemTableNotFound {
-			return val, nil
-		}
-	}
-
-	if db.imm != nil { // 2.再查内存中的ImmTable
-		if val, err := db.imm.Get(key); err != errors.ErrMemTableNotFound {
-			return val, nil
-		}
-	}
-
-	if db.current != nil { // 3.最后对磁盘上的数据按Level由新到旧依次查询
-		if val, err := db.current.Get(key); err != errors.ErrVersionNotFound {
-			return val, nil
-		}
-	}
+	return db.GetCF(db.defaultCF, key, opts)
+}
 
-	return nil, errors.ErrDBNotFound
+// 在默认列族中查找user_key在序列号seq及之前的最新一条记录（包括删除记录），调用方需持有db.mutex
+func (db *YLDB) find(key []byte, seq uint64) (ikey.InternalKey, []byte, error) {
+	return db.defaultCF.find(key, seq)
 }
 
 func (db *YLDB) Set(key, value []byte, opts *utils.WriteOptions) error {
@@ -101,7 +201,7 @@ func (db *YLDB) Apply(batch Batch, opts *utils.WriteOptions) error {
 	if len(batch.data) == 0 {
 		return nil
 	}
-	n := batch.count()
+	n := batch.Count()
 	if n == invalidBatchCount {
 		return errors.ErrBatchInvalid
 	}
@@ -110,48 +210,184 @@ func (db *YLDB) Apply(batch Batch, opts *utils.WriteOptions) error {
 	defer db.mutex.Unlock()
 
 	// 保证现在有MemTable有足够空间进行写入操作
-	if err := db.makeRoomForWrite(); err != nil {
+	if err := db.makeRoomForWrite(len(batch.data), opts); err != nil {
 		return err
 	}
 
-	// todo batch写入 append log
+	return db.writeBatch(batch, opts)
+}
 
-	// batch添加到MemTable中
-	it := batch.iterator()
-	for {
-		kind, userKey, value, ok := it.next()
-		if !ok {
-			break
-		}
+// 写入从其他实例复制来的Batch并保留其原有的序列号，用于主从复制
+// batch.SeqNum()必须等于LastSequence()+1，否则返回ErrReplicationSeqMismatch
+func (db *YLDB) WriteReplicated(batch Batch, opts *utils.WriteOptions) error {
+	if len(batch.data) == 0 {
+		return nil
+	}
+	if batch.Count() == invalidBatchCount {
+		return errors.ErrBatchInvalid
+	}
 
-		seqNum := db.current.NextSeq()
-		internalKey := ikey.MakeInternalKey(nil, userKey, kind, seqNum)
-		// 暂时不考虑MemTable在batch set操作中出现的error
-		_ = db.mem.Set(internalKey, value)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if err := db.makeRoomForWrite(len(batch.data), opts); err != nil {
+		return err
 	}
+	if batch.SeqNum() != db.seq+1 {
+		return errors.ErrReplicationSeqMismatch
+	}
+	return db.writeBatch(batch, opts)
+}
 
+// 返回最后一次写入的序列号
+func (db *YLDB) LastSequence() uint64 {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.seq
+}
+
+// 为batch分配序列号，写入WAL后再写入各列族的MemTable，调用方需持有db.mutex并已调用makeRoomForWrite
+// 所有写入路径都经过这里，写入配置了DefaultTTL的列族的Set在此加上过期时间
+func (db *YLDB) writeBatch(batch Batch, opts *utils.WriteOptions) error {
+	if db.closed {
+		return errors.ErrDBClosed
+	}
+	if db.readOnly {
+		return errors.ErrDBReadOnly
+	}
+	if err := batch.Iterate(columnFamilyChecker{db: db}); err != nil {
+		return err
+	}
+	batch = db.applyDefaultTTL(batch)
+
+	seq := db.seq + 1
+	batch.setSeqNum(seq)
+	if err := db.log.AddRecord(batch.data); err != nil {
+		return err
+	}
+	if opts.GetSync() {
+		if err := db.log.Sync(); err != nil {
+			return err
+		}
+	}
+
+	// batch添加到MemTable中
+	inserter := &memTableInserter{db: db, seq: seq}
+	if err := batch.Iterate(inserter); err != nil {
+		return err
+	}
+	db.seq = inserter.seq - 1
 	return nil
 }
 
-func (db *YLDB) makeRoomForWrite() error {
+// 保证MemTable有足够的空间写入bytes字节，并按Compaction的积压情况延迟或停止写入，调用方需持有db.mutex
+// opts.NoSlowdown为true时需要等待则返回ErrWriteStall
+func (db *YLDB) makeRoomForWrite(bytes int, opts *utils.WriteOptions) error {
+	if db.readOnly {
+		return errors.ErrDBReadOnly
+	}
+	allowDelay := true
 	for true {
-		if db.current.NumLevelFiles(0) >= config.L0SlowdownWritesTrigger {
-			// 调整写入速度
-			db.mutex.Unlock()
-			time.Sleep(config.SlowdownSleepTime)
-			db.mutex.Lock()
-		} else if db.mem.ApproximateMemoryUsage() <= config.WriteBufferSize {
+		if db.closed {
+			return errors.ErrDBClosed
+		}
+		condition := db.updateWriteStallCondition()
+		if condition == WriteStallStopped {
+			// Level0文件或待合并的数据过多，等待后台Compaction完成
+			if opts.GetNoSlowdown() {
+				return errors.ErrWriteStall
+			}
+			if err := db.backgroundError(); err != nil {
+				return err
+			}
+			db.maybeScheduleCompaction()
+			db.waitForStall()
+		} else if allowDelay && condition == WriteStallDelayed {
+			// 调整写入速度，每次写入至多延迟一次
+			allowDelay = false
+			now := time.Now()
+			if wait := db.writeController.delay(now); wait > 0 {
+				if opts.GetNoSlowdown() {
+					return errors.ErrWriteStall
+				}
+				db.writeController.stats.DelayCount++
+				db.mutex.Unlock()
+				time.Sleep(wait)
+				db.mutex.Lock()
+				db.writeController.stats.DelayDuration += time.Since(now)
+			}
+			db.writeController.charge(bytes, time.Now())
+		} else if !db.memTableFull() {
 			// 当前MemTable未满，可以写入
 			return nil
-		} else if db.imm != nil {
+		} else if db.hasImm() {
 			// 当前MemTable满了，且ImmTable尚在Compaction
-			db.cond.Wait()
+			if opts.GetNoSlowdown() {
+				return errors.ErrWriteStall
+			}
+			if db.flushError != nil {
+				return db.flushError
+			}
+			db.waitForStall()
 		} else {
-			// MemTable转换为ImmTable并触发Compaction
-			// todo log文件转换
-			db.imm = db.mem
-			db.mem = memdb.NewMemTable(nil)
-			db.maybeScheduleCompaction()
+			if err := db.rotateMemTables(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 停止写入直到后台任务完成，计入写入停止的统计，调用方需持有db.mutex
+func (db *YLDB) waitForStall() {
+	start := time.Now()
+	db.writeController.stats.StopCount++
+	db.cond.Wait()
+	db.writeController.stats.StopDuration += time.Since(start)
+}
+
+// 所有列族的MemTable一起转换为ImmTable并触发Compaction
+// 这样flush完成后，转换之前的WAL可以整体删除，调用方需持有db.mutex且当前没有ImmTable
+func (db *YLDB) rotateMemTables() error {
+	if err := db.switchLog(); err != nil {
+		return err
+	}
+	for _, cf := range db.cfs {
+		if cf.mem.ApproximateMemoryUsage() > 0 {
+			cf.imm = cf.mem
+			cf.mem = memdb.NewMemTable(cf.cmp)
+		}
+	}
+	db.maybeScheduleCompaction()
+	return nil
+}
+
+// 将所有列族MemTable中的数据写入SST文件，返回时flush已完成，调用方需持有db.mutex
+func (db *YLDB) flush() error {
+	if db.readOnly {
+		return errors.ErrDBReadOnly
+	}
+	for db.hasImm() {
+		if db.flushError != nil {
+			return db.flushError
+		}
+		db.cond.Wait()
+	}
+	if db.closed {
+		return errors.ErrDBClosed
+	}
+	for _, cf := range db.cfs {
+		if cf.mem.ApproximateMemoryUsage() > 0 {
+			if err := db.rotateMemTables(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	for db.hasImm() || db.flushing {
+		db.cond.Wait()
+		if db.hasImm() && db.flushError != nil {
+			return db.flushError
 		}
 	}
 	return nil